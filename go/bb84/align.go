@@ -0,0 +1,155 @@
+package bb84
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+	"github.com/alan-christopher/bb84/go/bb84/entropy"
+	"github.com/alan-christopher/bb84/go/generated/bb84pb"
+)
+
+const (
+	// minAlignmentSamples is the fewest test basis coincidences an alignment
+	// candidate must have for its error rate to be trusted.
+	minAlignmentSamples = 32
+
+	// alignmentSamples is roughly how many of Bob's disclosed measurements
+	// are spent aligning each batch.
+	alignmentSamples = 8 * minAlignmentSamples
+
+	// maxAlignedErrorRate is the highest test basis error rate at which we
+	// still consider a batch to be aligned. Misaligned batches should see an
+	// error rate of around 1/2.
+	maxAlignedErrorRate = 0.35
+)
+
+// pulseRange describes a batch of n pulses starting at first, if it is
+// sequenced at all.
+func pulseRange(sequenced bool, first uint64, n int) *bb84pb.PulseRange {
+	if !sequenced {
+		return nil
+	}
+	return &bb84pb.PulseRange{First: first, Count: uint64(n)}
+}
+
+// checkPulseRanges verifies that two peers' descriptions of a batch agree.
+// Either may be nil, if that peer's hardware does not track pulses.
+func checkPulseRanges(ours, theirs *bb84pb.PulseRange) error {
+	if ours == nil || theirs == nil {
+		return nil
+	}
+	if ours.First != theirs.First || ours.Count != theirs.Count {
+		return fmt.Errorf("%w: local batch has pulses [%d, %d), peer's has [%d, %d)", ErrDesync,
+			ours.First, ours.First+ours.Count, theirs.First, theirs.First+theirs.Count)
+	}
+	return nil
+}
+
+// alignmentSample chooses, at random, about alignmentSamples of the
+// measurements marked in disclosed to align a batch on. Since the offset is
+// chosen to minimize their error rate, they must be excluded from parameter
+// estimation, which relies on its sample being chosen independently of the
+// measurements' values.
+func alignmentSample(src entropy.Source, disclosed bitmap.Dense) (bitmap.Dense, error) {
+	p := math.Min(1, alignmentSamples/float64(bitmap.CountOnes(disclosed)))
+	mask, err := sampleMask(src, disclosed.Size(), p)
+	if err != nil {
+		return bitmap.Empty(), err
+	}
+	return bitmap.And(mask, disclosed), nil
+}
+
+// align searches for the offset, of at most maxOffset pulses, at which Bob's
+// announced test basis measurements best agree with Alice's. That is, the d
+// for which Bob's pulse i appears to be Alice's pulse i + d. bDisclosed marks
+// the test basis measurements to compare, among those whose values Bob
+// disclosed in bTest, and like bTest is as announced, i.e. only for the pulses
+// he did not drop.
+func align(bits, bases, bDropped, bDisclosed, bTest bitmap.Dense, maxOffset int) (int, error) {
+	n := bits.Size()
+	bTestMask, bTestBits := bitmap.Empty(), bitmap.Empty()
+	j := 0
+	for i := 0; i < n; i++ {
+		if bDropped.Get(i) {
+			bTestMask.AppendBit(false)
+			bTestBits.AppendBit(false)
+			continue
+		}
+		bTestMask.AppendBit(bDisclosed.Get(j))
+		bTestBits.AppendBit(bTest.Get(j))
+		j++
+	}
+
+	best, bestRate := 0, 1.0
+	// Try offsets in order of increasing magnitude, so that ties favor the
+	// smallest correction.
+	for k := 0; k <= 2*maxOffset; k++ {
+		d := (k + 1) / 2
+		if k%2 == 1 {
+			d = -d
+		}
+		lo, hi := overlap(n, d)
+		if hi-lo <= 0 {
+			continue
+		}
+		aBases, err := bitmap.Slice(bases, lo+d, hi+d)
+		if err != nil {
+			return 0, err
+		}
+		aBits, err := bitmap.Slice(bits, lo+d, hi+d)
+		if err != nil {
+			return 0, err
+		}
+		bMask, err := bitmap.Slice(bTestMask, lo, hi)
+		if err != nil {
+			return 0, err
+		}
+		bBits, err := bitmap.Slice(bTestBits, lo, hi)
+		if err != nil {
+			return 0, err
+		}
+		mask := bitmap.And(aBases, bMask)
+		samples := bitmap.CountOnes(mask)
+		if samples < minAlignmentSamples {
+			continue
+		}
+		rate := float64(bitmap.CountOnes(bitmap.And(mask, bitmap.XOr(aBits, bBits)))) / float64(samples)
+		if rate < bestRate {
+			best, bestRate = d, rate
+		}
+	}
+	if bestRate > maxAlignedErrorRate {
+		return 0, fmt.Errorf("%w: no offset within %d pulses has a test error rate below %g",
+			ErrDesync, maxOffset, maxAlignedErrorRate)
+	}
+	return best, nil
+}
+
+// overlap returns the range [lo, hi) of Bob's pulses which have counterparts
+// in Alice's batch, given a batch size of n and an offset of d.
+func overlap(n, d int) (lo, hi int) {
+	lo, hi = 0, n
+	if d < 0 {
+		lo = -d
+	} else {
+		hi = n - d
+	}
+	return lo, hi
+}
+
+// realignReceived restricts data, which holds one entry per pulse Bob did not
+// drop, to those pulses within [lo, hi).
+func realignReceived(data, dropped bitmap.Dense, lo, hi int) (bitmap.Dense, error) {
+	received := bitmap.Not(dropped)
+	before, err := bitmap.Slice(received, 0, lo)
+	if err != nil {
+		return bitmap.Dense{}, err
+	}
+	within, err := bitmap.Slice(received, lo, hi)
+	if err != nil {
+		return bitmap.Dense{}, err
+	}
+	start := bitmap.CountOnes(before)
+	return bitmap.Slice(data, start, start+bitmap.CountOnes(within))
+}
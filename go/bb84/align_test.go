@@ -0,0 +1,109 @@
+package bb84
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+	"github.com/alan-christopher/bb84/go/generated/bb84pb"
+)
+
+func TestAlign(t *testing.T) {
+	const n = 4096
+	tcs := []struct {
+		name      string
+		offset    int
+		maxOffset int
+		wantErr   error
+	}{
+		{name: "aligned", offset: 0, maxOffset: 4},
+		{name: "positive offset", offset: 3, maxOffset: 4},
+		{name: "negative offset", offset: -4, maxOffset: 4},
+		{name: "out of range", offset: 7, maxOffset: 4, wantErr: ErrDesync},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			r := rand.New(rand.NewSource(1))
+			bits, bases := bitmap.Empty(), bitmap.Empty()
+			for i := 0; i < n; i++ {
+				bits.AppendBit(r.Float64() < 0.5)
+				bases.AppendBit(r.Float64() < 0.5)
+			}
+			// Bob's pulse i is Alice's pulse i + offset, and he drops about
+			// half of them.
+			bDropped, bBases, bTest := bitmap.Empty(), bitmap.Empty(), bitmap.Empty()
+			for i := 0; i < n; i++ {
+				j := i + tc.offset
+				dropped := j < 0 || j >= n || r.Float64() < 0.5
+				bDropped.AppendBit(dropped)
+				if dropped {
+					continue
+				}
+				basis := r.Float64() < 0.5
+				bBases.AppendBit(basis)
+				bTest.AppendBit(basis && (bits.Get(j) != (basis != bases.Get(j) && r.Float64() < 0.5)))
+			}
+			got, err := align(bits, bases, bDropped, bBases, bTest, tc.maxOffset)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("align() error == %v, want %v", err, tc.wantErr)
+			}
+			if err == nil && got != tc.offset {
+				t.Errorf("align() == %d, want %d", got, tc.offset)
+			}
+		})
+	}
+}
+
+func TestAlignmentSample(t *testing.T) {
+	const n = 1 << 14
+	r := rand.New(rand.NewSource(1))
+	disclosed := bitmap.Empty()
+	for i := 0; i < n; i++ {
+		disclosed.AppendBit(r.Float64() < 0.5)
+	}
+	got, err := alignmentSample(rand.New(rand.NewSource(2)), disclosed)
+	if err != nil {
+		t.Fatalf("alignmentSample: %v", err)
+	}
+	if c := bitmap.CountOnes(bitmap.And(got, bitmap.Not(disclosed))); c != 0 {
+		t.Errorf("sampled %d undisclosed measurements", c)
+	}
+	if c := bitmap.CountOnes(got); c < alignmentSamples/2 || c > 2*alignmentSamples {
+		t.Errorf("sampled %d measurements, want about %d", c, alignmentSamples)
+	}
+}
+
+func TestRealignReceived(t *testing.T) {
+	dropped, err := bitmap.FromString("01001000")
+	if err != nil {
+		t.Fatalf("bugged test setup: %v", err)
+	}
+	// One entry per received pulse: pulses 0, 2, 3, 5, 6, 7.
+	data, err := bitmap.FromString("101100")
+	if err != nil {
+		t.Fatalf("bugged test setup: %v", err)
+	}
+	got, err := realignReceived(data, dropped, 2, 6)
+	if err != nil {
+		t.Fatalf("realignReceived: %v", err)
+	}
+	want, _ := bitmap.FromString("011")
+	if got.Size() != want.Size() || !bitmap.Equal(got, want) {
+		t.Errorf("realignReceived() == %v (len %d), want %v", got.Data(), got.Size(), want.Data())
+	}
+}
+
+func TestCheckPulseRanges(t *testing.T) {
+	a := &bb84pb.PulseRange{First: 16, Count: 8}
+	b := &bb84pb.PulseRange{First: 24, Count: 8}
+	if err := checkPulseRanges(a, nil); err != nil {
+		t.Errorf("checkPulseRanges(a, nil) == %v, want nil", err)
+	}
+	if err := checkPulseRanges(a, a); err != nil {
+		t.Errorf("checkPulseRanges(a, a) == %v, want nil", err)
+	}
+	if err := checkPulseRanges(a, b); !errors.Is(err, ErrDesync) {
+		t.Errorf("checkPulseRanges(a, b) == %v, want %v", err, ErrDesync)
+	}
+}
@@ -8,8 +8,10 @@ import (
 	"io"
 	"math"
 	"math/rand"
+	"time"
 
 	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+	"github.com/alan-christopher/bb84/go/bb84/entropy"
 	"github.com/alan-christopher/bb84/go/bb84/photon"
 )
 
@@ -20,6 +22,28 @@ var (
 	DefaultEpsilon               = 1e-12
 )
 
+var (
+	// ErrKeyTooShort is returned when the information leaked during
+	// reconciliation exceeds the amount of secrecy we can certify.
+	ErrKeyTooShort = errors.New("cannot make safe key")
+
+	// ErrVerificationFailed is returned when Alice and Bob's error-corrected
+	// keys do not hash to the same value.
+	ErrVerificationFailed = errors.New("error correction failed verification")
+
+	// ErrInvalidMAC is returned when a classical message fails authentication.
+	ErrInvalidMAC = errors.New("invalid mac")
+
+	// ErrAborted is returned when either peer aborts a round after parameter
+	// estimation, because its results exceed the limits set by
+	// PeerOpts.MaxQBER or PeerOpts.MaxPhaseError.
+	ErrAborted = errors.New("round aborted")
+
+	// ErrDesync is returned when Alice and Bob's batches of pulses cannot be
+	// matched up with one another.
+	ErrDesync = errors.New("quantum channel desynchronized")
+)
+
 // Stats packages together a collection of potentially interesting metrics
 // pertaining to a BB84 key negotiation.
 type Stats struct {
@@ -30,6 +54,118 @@ type Stats struct {
 	MessagesReceived int
 	BytesRead        int
 	BytesSent        int
+
+	// BitsLeaked is the number of bits of information about the sifted key
+	// charged against its secrecy during information reconciliation.
+	BitsLeaked int
+
+	// SecretBytes is the number of bytes of the bootstrap secret consumed
+	// authenticating classical messages: each message's one-time pad, plus, in
+	// a peer's first round, the choice of hash function.
+	SecretBytes int
+
+	// Epsilons reports how the round's failure probabilities were allotted.
+	Epsilons Epsilons
+
+	// Insecure is set if the round ran under PeerOpts.InsecureAsymptotic, in
+	// which case its key is NOT secure, and must not be used as one.
+	Insecure bool
+
+	// Estimates and PeerEstimates hold the intermediate results of parameter
+	// estimation, as computed locally and as reported by the other peer,
+	// respectively. PeerEstimates is only populated if negotiation makes it as
+	// far as verifying error correction.
+	Estimates     Estimates
+	PeerEstimates Estimates
+
+	// TestEstimates, if PeerOpts.SampleProp is set, holds the estimates for
+	// the test basis's share of the key. Estimates then describes the main
+	// basis's share, except that its SafeKeyLen and KeyLen are those of the
+	// whole key.
+	TestEstimates Estimates
+
+	// Timings records the wall-clock time spent in each phase of negotiation.
+	Timings Timings
+
+	// DoubleClicks, DarkCounts, Afterpulses and DeadTimeLosses tally
+	// detector-level events, as reported by a photon.DetectorReporter. They are
+	// only populated by the receiving peer. SiftedDoubleClicks counts the
+	// double clicks which survived sifting, and so contribute to QBER.
+	DoubleClicks       int
+	SiftedDoubleClicks int
+	DarkCounts         int
+	Afterpulses        int
+	DeadTimeLosses     int
+
+	// Realignments counts the batches in which an alignment search found, and
+	// corrected for, an offset between Alice and Bob's pulses.
+	Realignments int
+}
+
+// Estimates packages together the intermediate values computed while bounding
+// the secrecy of a sifted key. See
+// https://journals.aps.org/pra/abstract/10.1103/PhysRevA.89.022307 for the
+// meaning of each.
+type Estimates struct {
+	// VacuumX and VacuumZ bound, from below, the number of detection events
+	// caused by vacuum pulses in the main and test bases.
+	VacuumX, VacuumZ float64
+
+	// SinglePhotonX and SinglePhotonZ bound, from below, the number of
+	// detection events caused by single-photon pulses in the main and test
+	// bases.
+	SinglePhotonX, SinglePhotonZ float64
+
+	// PhaseError bounds, from above, the phase error rate of the single-photon
+	// detections in the main basis.
+	PhaseError float64
+
+	// SafeKeyLen is the key length supported by parameter estimation, before
+	// accounting for information leaked during error correction. KeyLen is
+	// the length after that accounting.
+	SafeKeyLen int
+	KeyLen     int
+
+	// Bound names the ConcentrationBound the estimates were computed with. It
+	// is empty for a single-photon source, whose estimates need none, unless
+	// they were computed with PeerOpts.InsecureAsymptotic.
+	Bound string
+}
+
+// Epsilons describes the failure probabilities spent on each part of a round of
+// key negotiation. By the composability of each part, the round as a whole is
+// Total()-secure.
+type Epsilons struct {
+	// Auth is the probability that any of the round's classical messages was
+	// forged, i.e. the per-message forgery probability times the number of
+	// messages exchanged.
+	Auth float64
+
+	// Correct is the probability that Alice and Bob's keys differ despite
+	// passing verification.
+	Correct float64
+
+	// Estimation and Amplification are the shares of the secrecy parameter
+	// spent on parameter estimation and privacy amplification.
+	Estimation, Amplification float64
+}
+
+// Total returns the overall security parameter of a round. It is an upper
+// bound, as when EpsilonPrivacy is used for both Estimation and Amplification
+// it is counted twice.
+func (e Epsilons) Total() float64 {
+	return e.Auth + e.Correct + e.Estimation + e.Amplification
+}
+
+// Timings records the wall-clock time spent in each phase of a BB84 key
+// negotiation.
+type Timings struct {
+	Transmission   time.Duration
+	Sifting        time.Duration
+	Estimation     time.Duration
+	Reconciliation time.Duration
+	Verification   time.Duration
+	Extraction     time.Duration
 }
 
 // TODO: make Peer embed io.Reader, expose Stats via a secondary method, and
@@ -48,6 +184,8 @@ type Peer interface {
 // result in NewPeer returning an error.
 type PeerOpts struct {
 	// Sender/Receiver handles photon transmission. Exactly one must be non-nil.
+	// Either is used via its photon.BatchSender/BatchReceiver interface if it
+	// has one, see photon.NewBatchSender and photon.NewBatchReceiver.
 	Sender   photon.Sender
 	Receiver photon.Receiver
 
@@ -55,10 +193,18 @@ type PeerOpts struct {
 	// non-nil.
 	ClassicalChannel io.ReadWriter
 
-	// Rand provides a source of randomness, e.g. for salting hashes. This may
-	// reasonably use pRNG for experimental and/or testing purposes, but for
-	// unconditional security it should be truly random. Must be non-nil.
-	Rand *rand.Rand
+	// Rand provides a source of randomness, e.g. for the seeds of the hashes
+	// used to verify and extract the key. This may reasonably use a pRNG such
+	// as entropy.NewDeterministic for experimental and/or testing purposes,
+	// but for unconditional security it should be truly random, e.g.
+	// entropy.Crypto or a QRNG opened with entropy.OpenDevice. Must be
+	// non-nil.
+	//
+	// Unless Rand is already an *entropy.Monitor, it is monitored with the
+	// default health tests, which assume full entropy. A key negotiation
+	// during which those tests raise an alarm fails with an error wrapping
+	// entropy.ErrEntropy.
+	Rand entropy.Source
 
 	// Secret provides a bootstrap secret shared between Alice and Bob for
 	// authentication. Must be non-nil.
@@ -84,6 +230,16 @@ type PeerOpts struct {
 	// Defaults to DefaultTestBlockSize.
 	TestBlockSize int
 
+	// EpsilonSecurity, if non-zero, specifies the overall composable security
+	// parameter of each round of key negotiation, which is split
+	// automatically between authentication, correctness, parameter estimation
+	// and privacy amplification, see Stats.Epsilons. It supersedes, and may
+	// not be combined with, the finer grained parameters below. Authentication
+	// is allotted half, spread over however many messages each round takes;
+	// tags lengthen once a round outgrows the shortest the block and batch
+	// sizes allow.
+	EpsilonSecurity float64
+
 	// EpsilonAuth specifies the probability that we are willing to accept that
 	// Eve can forge a message. Each classical message exchanged spends
 	// log_2(1/EpsilonAuth) bits of Secret, rounded up to the nearest byte.
@@ -105,9 +261,73 @@ type PeerOpts struct {
 	EpsilonPrivacy float64
 
 	// PulseAttrs provide information about the attenuated laser pulses used to
-	// carry information between Alice and Bob. Must be provided.
+	// carry information between Alice and Bob. It must be left zero if, and
+	// only if, SinglePhotonSource is set.
 	PulseAttrs PulseAttrs
 
+	// SinglePhotonSource, if set, takes the Sender to be an ideal single-photon
+	// source, which emits exactly one photon per pulse, as do many teaching
+	// setups. There are then no decoys: any intensities the Sender reports are
+	// ignored, and parameter estimation bounds the phase error rate by random
+	// sampling of the test basis, as for BB84 proper. This is insecure for an
+	// attenuated laser, whose multi-photon pulses are open to photon number
+	// splitting attacks.
+	SinglePhotonSource bool
+
+	// ConcentrationBound selects the concentration inequality parameter
+	// estimation relates observed counts to their expectations with. Alice and
+	// Bob must agree on it.
+	//
+	// Defaults to Hoeffding.
+	ConcentrationBound ConcentrationBound
+
+	// InsecureAsymptotic, if set, replaces finite-key analysis with the
+	// asymptotic decoy-state key length of GLLP
+	// (https://arxiv.org/abs/quant-ph/0212066): observed counts are taken to
+	// equal their expectations, give or take what sampling noise requires, and
+	// neither parameter estimation nor privacy amplification pays for its
+	// failure probability. The resulting keys are NOT secure, and are returned
+	// with Stats.Insecure set. The mode is only meant for quick studies, e.g.
+	// of how much finite-size effects cost at a given block size, and
+	// Estimates.Bound reads "insecure-asymptotic" to flag its results too. It
+	// may not be combined with a ConcentrationBound. Alice and Bob must agree
+	// on it.
+	InsecureAsymptotic bool
+
+	// SampleProp, if non-zero, enables symmetric key generation: rather than
+	// disclosing every test basis measurement to estimate the main basis's
+	// phase error rate, Alice and Bob disclose a random sample of measurements
+	// in both bases, each measurement independently with probability
+	// SampleProp, and each basis's sample bounds the other's phase error rate.
+	// The undisclosed measurements of both bases then make up the key, which
+	// with a weakly biased, or unbiased, choice of basis can nearly double it.
+	// MainBlockSize and TestBlockSize count disclosed and undisclosed
+	// measurements alike. Alice and Bob must agree on it. Must lie in [0, 1).
+	SampleProp float64
+
+	// MaxQBER and MaxPhaseError, if positive, abort any round whose observed
+	// QBER, or whose bound on the single-photon phase error rate, exceeds them.
+	// They are checked straight after parameter estimation, so that a
+	// hopeless round discloses nothing during reconciliation. Alice and Bob
+	// exchange their decisions, and both abort with ErrAborted if either
+	// does, so they need not agree on the limits. Each must lie in [0, 0.5].
+	MaxQBER       float64
+	MaxPhaseError float64
+
+	// AlignmentSearch, if positive, enables a search for offsets of up to that
+	// many pulses between each of Alice's batches and Bob's, in case the
+	// quantum channel's hardware has slipped. The search correlates a random
+	// sample of Bob's announced test basis measurements against Alice's bits.
+	// Since the offset is chosen to fit that sample, it is excluded from
+	// parameter estimation. Only meaningful for Alice; Bob applies whatever
+	// offset, and excludes whatever sample, Alice announces.
+	AlignmentSearch int
+
+	// Transcript, if non-nil, receives a record of every classical message
+	// exchanged, suitable for reading back with ReadTranscript or driving a
+	// Replayer.
+	Transcript io.Writer
+
 	// WinnowOpts provides options for using Winnow (see
 	// https://arxiv.org/abs/quant-ph/0203096) for error correction. Non-nil iff
 	// using Winnow for information reconciliation.
@@ -129,20 +349,37 @@ type WinnowOpts struct {
 }
 
 // PulseAttrs provide information about the attenuated laser pulses used to
-// carry information between Alice and Bob. We assume a decoy-state setup with
-// three total states.
+// carry information between Alice and Bob. We assume a decoy-state setup,
+// with three intensity levels unless Intensities says otherwise.
 type PulseAttrs struct {
 	// MuLo, MuMed, and MuHi specify the mean photons per pulse of the low,
 	// medium, and high intensity pulse states, respectively. It is required
 	// that:
 	//
-	// (0 <= MuLo < MuMed < MuHi) and (MuLo + MuMed < MuHi)
+	// (0 <= MuLo < MuMed < MuHi)
 	MuLo, MuMed, MuHi float64
 
 	// ProbLo, ProbMed, and ProbHi describe the underlying probability that any
 	// given pulse will be prepared at low, medium, or high intensities. It is
 	// required that the three of them sum to one.
 	ProbLo, ProbMed, ProbHi float64
+
+	// Intensities, if non-empty, supersedes the fields above, describing each
+	// of two or more intensity levels. Level i is that of the pulses a Sender
+	// reports at intensity i, see photon.SentBatch. It is required that their
+	// mean photon numbers be non-negative and strictly increasing, that their
+	// tolerance intervals not overlap, and that their probabilities sum to one.
+	// Note that with only two levels, the number of single-photon detections
+	// cannot be usefully bounded, and so little or no key can be extracted.
+	//
+	// If a Sender reports monitored intensities for every batch of a round,
+	// see photon.SentBatch, they are trusted to tighten the tolerance
+	// intervals for that round.
+	Intensities []Intensity
+
+	// single is set, from PeerOpts.SinglePhotonSource, if the pulses instead
+	// come from an ideal single-photon source.
+	single bool
 }
 
 // NewPeer returns a new Peer, configured in accordance with opts, or an error
@@ -151,43 +388,20 @@ func NewPeer(opts PeerOpts) (Peer, error) {
 	if err := checkOpts(opts); err != nil {
 		return nil, err
 	}
-	nX := opts.MainBlockSize
-	if nX == 0 {
-		nX = DefaultMainBlockSize
-	}
-	nZ := opts.TestBlockSize
-	if nZ == 0 {
-		nZ = DefaultTestBlockSize
-	}
-	epsAuth := opts.EpsilonAuth
-	if epsAuth == 0 {
-		epsAuth = DefaultEpsilon
-	}
-	epsPriv := opts.EpsilonAuth
-	if epsPriv == 0 {
-		epsPriv = DefaultEpsilon
-	}
-	epsCorrect := opts.EpsilonCorrect
-	if epsCorrect == 0 {
-		epsCorrect = DefaultEpsilon
-	}
-	batchBytes := opts.MeasurementBatchBytes
-	if batchBytes == 0 {
-		batchBytes = DefaultMeasurementBatchBytes
+	nX, nZ, batchBytes := opts.sizes()
+	bound := opts.concentrationBound()
+	rng, ok := opts.Rand.(*entropy.Monitor)
+	if !ok {
+		var err error
+		if rng, err = entropy.NewMonitor(opts.Rand, entropy.HealthOpts{}); err != nil {
+			return nil, err
+		}
 	}
 
-	diags := make([]byte, max(5*(batchBytes+4), 2*(nX+4))+40+8)
-	if _, err := io.ReadFull(opts.Secret, diags); err != nil {
+	pf, err := newSideChannel(opts)
+	if err != nil {
 		return nil, err
 	}
-	pf := &protoFramer{
-		rw:     opts.ClassicalChannel,
-		secret: opts.Secret,
-		t: toeplitz{
-			diags: bitmap.NewDense(diags, -1),
-			m:     int(math.Ceil(math.Log2(1 / epsAuth))),
-		},
-	}
 	rec := winnower{
 		channel: pf,
 		rand:    opts.WinnowOpts.SyncRand,
@@ -196,32 +410,62 @@ func NewPeer(opts PeerOpts) (Peer, error) {
 	}
 	if opts.Sender == nil {
 		return &bob{
-			receiver:       opts.Receiver,
+			receiver:       photon.NewBatchReceiver(opts.Receiver),
 			sideChannel:    pf,
 			reconciler:     rec,
 			measBatchBytes: batchBytes,
-			rand:           opts.Rand,
-			epsPriv:        epsPriv,
-			epsCorrect:     epsCorrect,
-			pulseAttrs:     opts.PulseAttrs,
+			rand:           rng,
+			eps:            newEpsilonBudget(opts),
+			pulseAttrs:     opts.pulseAttrs(),
+			bound:          bound,
+			limits:         abortLimits{qber: opts.MaxQBER, phaseError: opts.MaxPhaseError},
+			sampleProp:     opts.SampleProp,
 			nX:             nX,
 			nZ:             nZ,
 		}, nil
 	}
 	return &alice{
-		sender:         opts.Sender,
+		sender:         photon.NewBatchSender(opts.Sender),
 		sideChannel:    pf,
 		reconciler:     rec,
 		measBatchBytes: batchBytes,
-		rand:           opts.Rand,
-		epsPriv:        epsPriv,
-		epsCorrect:     epsCorrect,
-		pulseAttrs:     opts.PulseAttrs,
+		rand:           rng,
+		eps:            newEpsilonBudget(opts),
+		pulseAttrs:     opts.pulseAttrs(),
+		bound:          bound,
+		limits:         abortLimits{qber: opts.MaxQBER, phaseError: opts.MaxPhaseError},
+		sampleProp:     opts.SampleProp,
+		alignSearch:    opts.AlignmentSearch,
 		nX:             nX,
 		nZ:             nZ,
 	}, nil
 }
 
+// newSideChannel builds the authenticated classical channel described by opts,
+// consuming the portion of opts.Secret used to choose its hash function.
+func newSideChannel(opts PeerOpts) (*protoFramer, error) {
+	nX, _, batchBytes := opts.sizes()
+	eps := newEpsilonBudget(opts)
+	diags := make([]byte, max(announcementBytes(batchBytes, len(opts.pulseAttrs().levels())), 2*(nX+4))+40+8)
+	if _, err := io.ReadFull(opts.Secret, diags); err != nil {
+		return nil, err
+	}
+	pf := &protoFramer{
+		rw:     opts.ClassicalChannel,
+		secret: opts.Secret,
+		t: toeplitz{
+			diags: bitmap.NewDense(diags, -1),
+			m:     eps.tagBits(1),
+		},
+		eps:         eps,
+		setupSecret: len(diags),
+	}
+	if opts.Transcript != nil {
+		pf.transcript = &transcriptWriter{w: opts.Transcript}
+	}
+	return pf, nil
+}
+
 func checkOpts(opts PeerOpts) error {
 	if (opts.Sender == nil) == (opts.Receiver == nil) {
 		return errors.New("exactly one of {Sender, Receiver} must be specified")
@@ -235,27 +479,104 @@ func checkOpts(opts PeerOpts) error {
 	if opts.Secret == nil {
 		return errors.New("must provide Secret")
 	}
+	if opts.AlignmentSearch < 0 {
+		return fmt.Errorf("AlignmentSearch must be non-negative, got %d", opts.AlignmentSearch)
+	}
+	if opts.MaxQBER < 0 || opts.MaxQBER > 0.5 {
+		return fmt.Errorf("MaxQBER must lie in [0, 0.5], got %g", opts.MaxQBER)
+	}
+	if opts.MaxPhaseError < 0 || opts.MaxPhaseError > 0.5 {
+		return fmt.Errorf("MaxPhaseError must lie in [0, 0.5], got %g", opts.MaxPhaseError)
+	}
 	// Only option for reconciliation at the moment is winnow.
 	if opts.WinnowOpts == nil {
 		return errors.New("must provide reconciliation options")
 	}
-	lo, med, hi := opts.PulseAttrs.MuLo, opts.PulseAttrs.MuMed, opts.PulseAttrs.MuHi
-	if 0 > lo || lo >= med || med >= hi {
-		return fmt.Errorf("pulse intensities must satisfy 0 <= lo < med < hi, but !(0 <= %f < %f < %f)",
-			lo, med, hi)
+	return checkEstimationOpts(opts)
+}
+
+// checkEstimationOpts checks the options which bear on parameter estimation.
+func checkEstimationOpts(opts PeerOpts) error {
+	if opts.EpsilonSecurity != 0 {
+		if opts.EpsilonAuth != 0 || opts.EpsilonCorrect != 0 || opts.EpsilonPrivacy != 0 {
+			return errors.New("EpsilonSecurity may not be combined with EpsilonAuth, EpsilonCorrect or EpsilonPrivacy")
+		}
+		if opts.EpsilonSecurity < 0 || opts.EpsilonSecurity >= 1 {
+			return fmt.Errorf("EpsilonSecurity must lie in (0, 1), got %g", opts.EpsilonSecurity)
+		}
+	}
+	if opts.InsecureAsymptotic && opts.ConcentrationBound != nil {
+		return errors.New("InsecureAsymptotic may not be combined with a ConcentrationBound")
+	}
+	if opts.SampleProp < 0 || opts.SampleProp >= 1 {
+		return fmt.Errorf("SampleProp must lie in [0, 1), got %g", opts.SampleProp)
+	}
+	if opts.SinglePhotonSource {
+		if !opts.PulseAttrs.zero() {
+			return errors.New("PulseAttrs may not be combined with SinglePhotonSource")
+		}
+		return nil
 	}
-	if lo+med >= hi {
-		return fmt.Errorf("pulse intensities must satisfy lo + med < hi, but !(%f + %f < %f)",
-			lo, med, hi)
+	if opts.PulseAttrs.zero() {
+		return errors.New("must provide PulseAttrs, or set SinglePhotonSource")
 	}
-	p1, p2, p3 := opts.PulseAttrs.ProbLo, opts.PulseAttrs.ProbMed, opts.PulseAttrs.ProbHi
-	if math.Abs(p1+p2+p3-1) > 1e-9 {
-		return fmt.Errorf("decoy state proportions must sum to one, %f+%f+%f=%f",
-			p1, p2, p3, p1+p2+p3)
+	levels := opts.PulseAttrs.levels()
+	if len(levels) < 2 {
+		return fmt.Errorf("decoy states require at least two intensities, got %d", len(levels))
+	}
+	if len(levels) > 256 {
+		return fmt.Errorf("at most 256 intensities are supported, got %d", len(levels))
+	}
+	pSum := 0.0
+	for i, l := range levels {
+		if l.Mu < 0 || (i > 0 && l.Mu <= levels[i-1].Mu) {
+			return fmt.Errorf("pulse intensities must be non-negative and strictly increasing, got %v", levels)
+		}
+		if l.Tolerance < 0 {
+			return fmt.Errorf("intensity %d has negative tolerance %f", i, l.Tolerance)
+		}
+		if i > 0 && l.Mu-l.Tolerance <= levels[i-1].Mu+levels[i-1].Tolerance {
+			return fmt.Errorf("intensity %d's tolerance interval overlaps that of intensity %d", i, i-1)
+		}
+		if l.Prob <= 0 {
+			return fmt.Errorf("intensity %d has non-positive probability %f", i, l.Prob)
+		}
+		pSum += l.Prob
+	}
+	if math.Abs(pSum-1) > 1e-9 {
+		return fmt.Errorf("decoy state proportions must sum to one, got %v", levels)
 	}
 	return nil
 }
 
+// sizes returns the main and test block sizes, and the measurement batch size
+// in bytes, under opts.
+func (opts PeerOpts) sizes() (nX, nZ, batchBytes int) {
+	nX, nZ, batchBytes = opts.MainBlockSize, opts.TestBlockSize, opts.MeasurementBatchBytes
+	if nX == 0 {
+		nX = DefaultMainBlockSize
+	}
+	if nZ == 0 {
+		nZ = DefaultTestBlockSize
+	}
+	if batchBytes == 0 {
+		batchBytes = DefaultMeasurementBatchBytes
+	}
+	return nX, nZ, batchBytes
+}
+
+// concentrationBound returns the ConcentrationBound parameter estimation
+// should use under opts.
+func (opts PeerOpts) concentrationBound() ConcentrationBound {
+	if opts.InsecureAsymptotic {
+		return insecureAsymptotic{}
+	}
+	if opts.ConcentrationBound == nil {
+		return Hoeffding{}
+	}
+	return opts.ConcentrationBound
+}
+
 type reconcileResult struct {
 	xHat       bitmap.Dense
 	bitsLeaked int
@@ -128,11 +128,18 @@ func Slice(d Dense, start, end int) (Dense, error) {
 	}
 
 	r := Dense{}
-	for ; start%byteSize != 0; start++ {
+	for ; start%byteSize != 0 && start < end; start++ {
 		r.AppendBit(d.Get(start))
 	}
+	if start == end {
+		return r, nil
+	}
 	j := start / byteSize
 	tmp := NewDense(d.bits[j:j+BytesFor(end-start)], end-start)
 	r.Append(tmp)
+	// The last byte copied may hold bits from beyond the end of the slice.
+	if off := r.len % byteSize; off != 0 && !r.negated {
+		r.bits[r.len/byteSize] &= 0xFF >> (byteSize - off)
+	}
 	return r, nil
 }
@@ -229,6 +229,18 @@ func TestSlice(t *testing.T) {
 			start: 8,
 			end:   17,
 			eout:  mustDense(t, "00000000 1"),
+		}, {
+			name:  "unaligned end with trailing bits",
+			bits:  mustDense(t, "00000000 11111111"),
+			start: 0,
+			end:   9,
+			eout:  mustDense(t, "00000000 1"),
+		}, {
+			name:  "within a byte",
+			bits:  mustDense(t, "11111111"),
+			start: 2,
+			end:   4,
+			eout:  mustDense(t, "11"),
 		}, {
 			name:  "long slice",
 			bits:  Dense{bits: []byte{1, 2, 3, 4, 5, 6}, len: 48},
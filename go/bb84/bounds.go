@@ -0,0 +1,134 @@
+package bb84
+
+import "math"
+
+// A ConcentrationBound relates the number of events observed over a number of
+// trials, e.g. detections among the pulses sent at some intensity, to the
+// number expected, for the purposes of finite-key analysis. Tighter bounds
+// yield longer keys, particularly for small blocks, but may rest on stronger
+// assumptions about Eve.
+type ConcentrationBound interface {
+	// Name identifies the bound, e.g. in Estimates.
+	Name() string
+
+	// Expectation bounds, from below and above, the expected number of events
+	// given that observed events occurred over trials. Each bound may fail
+	// with probability at most eps.
+	Expectation(observed, trials, eps float64) (lo, hi float64)
+}
+
+// Hoeffding is the additive bound of Hoeffding's inequality, as used in
+// https://journals.aps.org/pra/abstract/10.1103/PhysRevA.89.022307. Its
+// deviation depends only on the number of trials, and so it is loose when
+// events are rare, as detections usually are.
+type Hoeffding struct{}
+
+// Name implements the ConcentrationBound interface.
+func (Hoeffding) Name() string {
+	return "hoeffding"
+}
+
+// Expectation implements the ConcentrationBound interface.
+func (Hoeffding) Expectation(observed, trials, eps float64) (lo, hi float64) {
+	delta := math.Sqrt(trials * math.Log(1/eps) / 2)
+	return observed - delta, observed + delta
+}
+
+// Chernoff is the multiplicative Chernoff bound, whose deviation scales with
+// the square root of the number of events rather than of trials. It assumes
+// that trials are independent, i.e. that Eve is restricted to collective
+// attacks.
+type Chernoff struct{}
+
+// Name implements the ConcentrationBound interface.
+func (Chernoff) Name() string {
+	return "chernoff"
+}
+
+// Expectation implements the ConcentrationBound interface. It inverts
+//
+//	P[X <= (1-δ)E] <= exp(-δ²E/2)
+//	P[X >= (1+δ)E] <= exp(-δ²E/(2+δ))
+//
+// for the expectation E given an observation X.
+func (Chernoff) Expectation(observed, trials, eps float64) (lo, hi float64) {
+	l := math.Log(1 / eps)
+	lo = observed - (math.Sqrt(l*l+8*l*observed)-l)/2
+	hi = observed + l + math.Sqrt(l*l+2*l*observed)
+	return lo, hi
+}
+
+// Kato is the concentration inequality of https://arxiv.org/abs/2002.04357,
+// which, like Hoeffding's, holds for arbitrarily correlated trials, and so
+// against coherent attacks, but is nearly as tight as Chernoff's when events
+// are rare. Its free parameter is tuned to the observed number of events.
+type Kato struct{}
+
+// Name implements the ConcentrationBound interface.
+func (Kato) Name() string {
+	return "kato"
+}
+
+// Expectation implements the ConcentrationBound interface. Kato's inequality
+// bounds the expectation from above; the lower bound follows from applying it
+// to the trials without events.
+func (Kato) Expectation(observed, trials, eps float64) (lo, hi float64) {
+	return observed - katoDeviation(trials-observed, trials, eps),
+		observed + katoDeviation(observed, trials, eps)
+}
+
+// katoDeviation returns the smallest deviation Kato's inequality,
+//
+//	P[E - X >= (b + a(2X/n - 1))√n] <= exp(-2(b² - a²) / (1 + 4a/(3√n))²)
+//
+// permits between the expectation E and an observation X over n trials, by
+// choosing b to meet eps, and searching for the best a.
+func katoDeviation(observed, trials, eps float64) float64 {
+	if trials <= 0 {
+		return 0
+	}
+	sqrtN := math.Sqrt(trials)
+	l := math.Log(1 / eps)
+	deviation := func(a float64) float64 {
+		k := 1 + 4*a/(3*sqrtN)
+		b := math.Sqrt(a*a + l*k*k/2)
+		return (b + a*(2*observed/trials-1)) * sqrtN
+	}
+	// The deviation is convex in a, over the range for which the inequality
+	// holds.
+	lo, hi := -3*sqrtN/4, sqrtN
+	for i := 0; i < 200; i++ {
+		m1, m2 := lo+(hi-lo)/3, hi-(hi-lo)/3
+		if deviation(m1) < deviation(m2) {
+			hi = m2
+		} else {
+			lo = m1
+		}
+	}
+	return deviation((lo + hi) / 2)
+}
+
+// insecureAsymptotic stands in for a ConcentrationBound under
+// PeerOpts.InsecureAsymptotic. It takes every expectation to lie within sigmas
+// standard deviations of its observation, regardless of eps. See
+// boundYieldAsymptotic for why sigmas may be non-zero.
+type insecureAsymptotic struct {
+	sigmas float64
+}
+
+// Name implements the ConcentrationBound interface.
+func (insecureAsymptotic) Name() string {
+	return "insecure-asymptotic"
+}
+
+// Expectation implements the ConcentrationBound interface.
+func (a insecureAsymptotic) Expectation(observed, trials, eps float64) (lo, hi float64) {
+	delta := a.sigmas * math.Sqrt(observed)
+	return observed - delta, observed + delta
+}
+
+// isAsymptotic reports whether cb calls for the asymptotic key length.
+func isAsymptotic(cb ConcentrationBound) bool {
+	_, ok := cb.(insecureAsymptotic)
+	return ok
+}
@@ -0,0 +1,52 @@
+package bb84
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestConcentrationBounds(t *testing.T) {
+	bounds := []ConcentrationBound{Hoeffding{}, Chernoff{}, Kato{}}
+	t.Run("coverage", func(t *testing.T) {
+		const eps, samples, trials = 1e-2, 500, 10000
+		for _, b := range bounds {
+			for _, p := range []float64{1e-3, 0.05, 0.5} {
+				rng := rand.New(rand.NewSource(7))
+				want := trials * p
+				misses := 0
+				for i := 0; i < samples; i++ {
+					observed := 0
+					for j := 0; j < trials; j++ {
+						if rng.Float64() < p {
+							observed++
+						}
+					}
+					lo, hi := b.Expectation(float64(observed), trials, eps)
+					if lo > want || hi < want {
+						misses++
+					}
+				}
+				// Each side may fail with probability eps, and is usually
+				// much more conservative than that.
+				if misses > 2*eps*samples {
+					t.Errorf("%s, p=%g: expectation fell outside the bounds %d times in %d", b.Name(), p, misses, samples)
+				}
+			}
+		}
+	})
+	t.Run("rare events", func(t *testing.T) {
+		// Detections are rare, which is where Chernoff and Kato should beat
+		// Hoeffding by a wide margin.
+		const observed, trials, eps = 1e5, 1e9, 1e-12
+		hLo, hHi := Hoeffding{}.Expectation(observed, trials, eps)
+		for _, b := range bounds[1:] {
+			lo, hi := b.Expectation(observed, trials, eps)
+			if !(lo <= observed && observed <= hi) {
+				t.Errorf("%s: got bounds [%f, %f], which exclude the observation %f", b.Name(), lo, hi, observed)
+			}
+			if hi-lo > (hHi-hLo)/10 {
+				t.Errorf("%s: got bounds [%f, %f], want much tighter than Hoeffding's [%f, %f]", b.Name(), lo, hi, hLo, hHi)
+			}
+		}
+	})
+}
@@ -0,0 +1,412 @@
+package bb84
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+	"github.com/alan-christopher/bb84/go/bb84/photon"
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/optimize/convex/lp"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+const (
+	// photonCutoff is the largest photon number whose yield is bounded
+	// individually. Contributions from pulses with more photons are lumped
+	// together.
+	photonCutoff = 8
+
+	// simplexTol is the tolerance of the linear programs used to bound yields,
+	// which are normalized to be of order one.
+	simplexTol = 1e-10
+
+	// maxAsymptoticSigmas and asymptoticSigmasTol are the largest relaxation,
+	// and the precision of the smallest, that boundYieldAsymptotic searches
+	// for, in standard deviations.
+	maxAsymptoticSigmas = 64
+	asymptoticSigmasTol = 1.0 / 64
+)
+
+// An Intensity describes one of the intensity levels at which pulses are
+// prepared.
+type Intensity struct {
+	// Mu is the nominal mean photons per pulse.
+	Mu float64
+
+	// Tolerance, if non-zero, specifies that the actual mean photons per pulse
+	// may lie anywhere within [Mu-Tolerance, Mu+Tolerance], e.g. due to
+	// modulator drift. Parameter estimation then takes worst-case bounds over
+	// that interval.
+	Tolerance float64
+
+	// Prob is the probability that any given pulse is prepared at this
+	// intensity.
+	Prob float64
+}
+
+// A decoyLevel describes an intensity level for the purposes of parameter
+// estimation: its mean photon number is only known to lie within [lo, hi].
+type decoyLevel struct {
+	lo, hi, prob float64
+}
+
+// levels returns the intensity levels described by pa, in increasing order of
+// Mu. A single-photon source has a single level.
+func (pa PulseAttrs) levels() []Intensity {
+	if pa.singlePhoton() {
+		return []Intensity{{Mu: 1, Prob: 1}}
+	}
+	if len(pa.Intensities) > 0 {
+		return pa.Intensities
+	}
+	return []Intensity{
+		{Mu: pa.MuLo, Prob: pa.ProbLo},
+		{Mu: pa.MuMed, Prob: pa.ProbMed},
+		{Mu: pa.MuHi, Prob: pa.ProbHi},
+	}
+}
+
+// singlePhoton reports whether pa describes an ideal single-photon source.
+func (pa PulseAttrs) singlePhoton() bool {
+	return pa.single
+}
+
+// zero reports whether pa is the zero PulseAttrs.
+func (pa PulseAttrs) zero() bool {
+	return len(pa.Intensities) == 0 && pa.MuLo == 0 && pa.MuMed == 0 && pa.MuHi == 0 &&
+		pa.ProbLo == 0 && pa.ProbMed == 0 && pa.ProbHi == 0
+}
+
+// pulseAttrs returns opts.PulseAttrs, marked as describing an ideal
+// single-photon source if opts.SinglePhotonSource is set.
+func (opts PeerOpts) pulseAttrs() PulseAttrs {
+	pa := opts.PulseAttrs
+	pa.single = opts.SinglePhotonSource
+	return pa
+}
+
+// decoyLevels returns the intensity levels described by pa, tightened by any
+// monitored intensities. It is an error for the two to be inconsistent.
+func (pa PulseAttrs) decoyLevels(monitored []photon.IntensityRange) ([]decoyLevel, error) {
+	var levels []decoyLevel
+	for i, l := range pa.levels() {
+		d := decoyLevel{lo: math.Max(l.Mu-l.Tolerance, 0), hi: l.Mu + l.Tolerance, prob: l.Prob}
+		if monitored != nil {
+			m := monitored[i]
+			if m.Min > d.hi || m.Max < d.lo {
+				return nil, fmt.Errorf("intensity level %d was monitored within [%f, %f], outside its tolerance [%f, %f]",
+					i, m.Min, m.Max, d.lo, d.hi)
+			}
+			d.lo, d.hi = math.Max(d.lo, m.Min), math.Min(d.hi, m.Max)
+		}
+		levels = append(levels, d)
+	}
+	return levels, nil
+}
+
+// An intensityMonitor accumulates the ranges of intensities monitored at each
+// level over the batches making up a round.
+type intensityMonitor struct {
+	levels  int
+	ranges  []photon.IntensityRange
+	missing bool
+}
+
+// add accounts for the monitored intensities of one batch, which may be empty
+// if the batch was not monitored.
+func (m *intensityMonitor) add(ranges []photon.IntensityRange) error {
+	if len(ranges) == 0 {
+		m.missing = true
+		return nil
+	}
+	if len(ranges) != m.levels {
+		return fmt.Errorf("got monitored intensities for %d levels, but %d levels are configured",
+			len(ranges), m.levels)
+	}
+	for i, r := range ranges {
+		if !(0 <= r.Min && r.Min <= r.Max) {
+			return fmt.Errorf("intensity level %d has invalid monitored range [%f, %f]", i, r.Min, r.Max)
+		}
+	}
+	if m.ranges == nil {
+		m.ranges = append([]photon.IntensityRange(nil), ranges...)
+		return nil
+	}
+	for i, r := range ranges {
+		m.ranges[i].Min = math.Min(m.ranges[i].Min, r.Min)
+		m.ranges[i].Max = math.Max(m.ranges[i].Max, r.Max)
+	}
+	return nil
+}
+
+// monitored returns the range of intensities monitored at each level, or nil
+// unless every batch was monitored.
+func (m *intensityMonitor) monitored() []photon.IntensityRange {
+	if m.missing {
+		return nil
+	}
+	return m.ranges
+}
+
+// probNPhotons bounds, from below or above, the probability that any given
+// pulse contains exactly n photons.
+func probNPhotons(levels []decoyLevel, n int, upper bool) float64 {
+	tau := 0.0
+	for _, l := range levels {
+		// The Poisson probability of n photons is unimodal in the mean, peaking
+		// at n, so its extremes over an interval lie at the interval's ends or
+		// at n.
+		lo, hi := poisson(l.lo, n), poisson(l.hi, n)
+		p := math.Min(lo, hi)
+		if upper {
+			p = math.Max(lo, hi)
+			if l.lo <= float64(n) && float64(n) <= l.hi {
+				p = poisson(float64(n), n)
+			}
+		}
+		tau += l.prob * p
+	}
+	return tau
+}
+
+// poisson returns the probability of a pulse with mean photon number mu
+// containing exactly n photons, including for vacuum pulses.
+func poisson(mu float64, n int) float64 {
+	if mu == 0 {
+		if n == 0 {
+			return 1
+		}
+		return 0
+	}
+	return distuv.Poisson{Lambda: mu}.Prob(float64(n))
+}
+
+// boundYield bounds, from below or above, the number of events (e.g.
+// detections, or errors) caused by n-photon pulses, given the number of events
+// observed at each intensity level. Following
+// https://journals.aps.org/pra/abstract/10.1103/PhysRevA.89.022307, a
+// concentration bound relates the events observed at intensity k to the
+// expected number
+//
+//	Σ_j (μ_k^j / j!) y_j, where y_j = s_j / τ_j
+//
+// with s_j the number of events caused by j-photon pulses, and τ_j the
+// probability of a pulse containing j photons. Rather than combining those
+// relations in closed form, which is only possible for particular numbers of
+// intensities, we optimize y_n subject to all of them by linear programming.
+// Where μ_k is only known to lie within an interval, each relation is relaxed
+// to hold for the worst case over it.
+//
+// Yields beyond photonCutoff are lumped into one tail term per intensity. For
+// j > photonCutoff, (μ_k/μ_max)^j <= (μ_k/μ_max)^(photonCutoff+1), which bounds
+// each intensity's tail by that of the highest intensity. If the highest
+// intensity is itself uncertain, its tail cannot be bounded from above, and so
+// its number of events is only bounded from above.
+//
+// Lower bounds degrade to zero, and upper bounds to +Inf, if the program cannot
+// be solved.
+func boundYield(levels []decoyLevel, counts []int, cb ConcentrationBound, eps float64, n int, upper bool) float64 {
+	if isAsymptotic(cb) {
+		return boundYieldAsymptotic(levels, counts, n, upper)
+	}
+	if y, ok := solveYield(levels, counts, cb, eps, n, upper); ok {
+		return y
+	}
+	if upper {
+		return math.Inf(1)
+	}
+	return 0
+}
+
+// boundYieldAsymptotic is boundYield for PeerOpts.InsecureAsymptotic, which
+// takes observed counts to equal their expectations. Sampling noise can leave
+// them inconsistent with any mixture of photon number yields, in which case
+// they are relaxed by the fewest standard deviations which restores
+// consistency. The relaxation needed vanishes, relative to the counts, as they
+// grow.
+func boundYieldAsymptotic(levels []decoyLevel, counts []int, n int, upper bool) float64 {
+	solve := func(sigmas float64) (float64, bool) {
+		return solveYield(levels, counts, insecureAsymptotic{sigmas: sigmas}, 0, n, upper)
+	}
+	y, ok := solve(0)
+	lo, hi := 0.0, asymptoticSigmasTol
+	for ; !ok && hi <= maxAsymptoticSigmas; lo, hi = hi, 2*hi {
+		y, ok = solve(hi)
+	}
+	if !ok {
+		if upper {
+			return math.Inf(1)
+		}
+		return 0
+	}
+	for hi-lo > asymptoticSigmasTol {
+		mid := (lo + hi) / 2
+		if yMid, okMid := solve(mid); okMid {
+			y, hi = yMid, mid
+		} else {
+			lo = mid
+		}
+	}
+	return y
+}
+
+// solveYield solves boundYield's linear program, reporting whether it could.
+func solveYield(levels []decoyLevel, counts []int, cb ConcentrationBound, eps float64, n int, upper bool) (float64, bool) {
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+	if total == 0 {
+		return 0, true
+	}
+
+	// Variables are y_0..y_photonCutoff, the highest intensity's tail, and the
+	// tail of each of the other intensities. Each constraint gets a slack
+	// variable of its own, to bring the program into standard form.
+	nK := len(levels)
+	nY := photonCutoff + 1
+	maxLevel := levels[nK-1]
+	exactMax := maxLevel.lo == maxLevel.hi
+	tail := func(k int) int {
+		if k == nK-1 {
+			return nY
+		}
+		return nY + 1 + k
+	}
+	nVars := nY + nK
+	nRows := 3*nK - 1
+	if !exactMax {
+		nRows--
+	}
+	a := mat.NewDense(nRows, nVars+nRows, nil)
+	b := make([]float64, nRows)
+	row := 0
+	// Normalizing by the total number of events keeps the program well
+	// conditioned.
+	addRow := func(mu float64, tailVar int, slack, bound float64) {
+		coeff := 1.0
+		for j := 0; j < nY; j++ {
+			if j > 0 {
+				coeff *= mu / float64(j)
+			}
+			a.Set(row, j, coeff)
+		}
+		if tailVar >= 0 {
+			a.Set(row, tailVar, 1)
+		}
+		a.Set(row, nVars+row, slack)
+		b[row] = bound / float64(total)
+		row++
+	}
+	for k, l := range levels {
+		lo, hi := cb.Expectation(float64(counts[k]), float64(total), eps/limShares)
+		// The expectation is smallest at the lower end of the interval, and
+		// largest at the upper end, so each bound on it must hold there.
+		upperTail := -1
+		if k == nK-1 {
+			upperTail = tail(k)
+		}
+		addRow(l.lo, upperTail, 1, math.Exp(l.hi)*hi/l.prob)
+		if k == nK-1 && !exactMax {
+			continue
+		}
+		addRow(l.hi, tail(k), -1, math.Min(math.Exp(l.lo)*lo, math.Exp(l.hi)*lo)/l.prob)
+		if k == nK-1 {
+			continue
+		}
+		a.Set(row, tail(k), 1)
+		a.Set(row, tail(nK-1), -math.Pow(l.hi/maxLevel.lo, float64(photonCutoff+1)))
+		a.Set(row, nVars+row, 1)
+		row++
+	}
+	for _, bi := range b {
+		if math.IsNaN(bi) || math.IsInf(bi, 0) {
+			return 0, false
+		}
+	}
+	c := make([]float64, nVars+nRows)
+	c[n] = 1
+	if upper {
+		c[n] = -1
+	}
+	_, x, err := lp.Simplex(c, a, b, simplexTol, nil)
+	if err != nil {
+		return 0, false
+	}
+	y := x[n]
+	if y < 0 {
+		y = 0
+	}
+	return y * float64(total), true
+}
+
+// A tally counts, at each intensity level, the sifted detections making up a
+// block of key, the disclosed detections in the other basis, and the errors
+// among the latter. Naming follows the usual case, in which the key comes from
+// the main basis and the disclosed detections from the test basis.
+type tally struct {
+	main, test, errors []int
+}
+
+// tallyBlocks tallies each block of key: the main basis's undisclosed
+// detections, and, if a sample of the main basis was disclosed, the test
+// basis's undisclosed detections.
+func tallyBlocks(main, test siftedBasis) []tally {
+	blocks := []tally{tallyBlock(main.key, test)}
+	if main.sample.all.Size() > 0 {
+		blocks = append(blocks, tallyBlock(test.key, main))
+	}
+	return blocks
+}
+
+func tallyBlock(key measurements, other siftedBasis) tally {
+	return tally{
+		main:   measurementCounts(key.byLevel, false),
+		test:   measurementCounts(other.sample.byLevel, false),
+		errors: measurementCounts(other.errors.byLevel, true),
+	}
+}
+
+// sampleQBER returns the error rate among all the disclosed detections of
+// blocks.
+func sampleQBER(blocks []tally) float64 {
+	errs, n := 0, 0
+	for _, t := range blocks {
+		for k := range t.test {
+			errs += t.errors[k]
+			n += t.test[k]
+		}
+	}
+	return float64(errs) / float64(n)
+}
+
+// measurementCounts returns the number of events at each intensity level.
+func measurementCounts(byLevel []bitmap.Dense, ones bool) []int {
+	counts := make([]int, len(byLevel))
+	for k, m := range byLevel {
+		if ones {
+			counts[k] = bitmap.CountOnes(m)
+		} else {
+			counts[k] = m.Size()
+		}
+	}
+	return counts
+}
+
+func estimateVacuumCount(counts []int, levels []decoyLevel, cb ConcentrationBound, eps float64) float64 {
+	return probNPhotons(levels, 0, false) * boundYield(levels, counts, cb, eps, 0, false)
+}
+
+func estimateSinglePhotonCount(counts []int, levels []decoyLevel, cb ConcentrationBound, eps float64) float64 {
+	return probNPhotons(levels, 1, false) * boundYield(levels, counts, cb, eps, 1, false)
+}
+
+func estimatePhaseErrorRate(errCounts []int,
+	levels []decoyLevel, cb ConcentrationBound, eps, sZ1, sX1 float64) float64 {
+	nuZ1 := probNPhotons(levels, 1, true) * boundYield(levels, errCounts, cb, eps, 1, true)
+	if isAsymptotic(cb) {
+		return nuZ1 / sZ1
+	}
+	return nuZ1/sZ1 + gamma(eps, nuZ1/sZ1, sZ1, sX1)
+}
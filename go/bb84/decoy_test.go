@@ -0,0 +1,249 @@
+package bb84
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/alan-christopher/bb84/go/bb84/photon"
+)
+
+// expectedCounts models sending pulses through a channel with transmittance
+// eta, dark count probability pDark, and misalignment error rate eMis. It
+// returns the expected number of detections and errors at each intensity
+// level, and of those caused by vacuum and single-photon pulses.
+func expectedCounts(levels []Intensity, pulses, eta, pDark, eMis float64) (dets, errs []int, s0, s1, e1 float64) {
+	yield := func(n int) float64 {
+		return 1 - (1-pDark)*math.Pow(1-eta, float64(n))
+	}
+	errRate := func(n int) float64 {
+		if n == 0 {
+			return 0.5
+		}
+		return (eMis*(yield(n)-pDark) + 0.5*pDark) / yield(n)
+	}
+	for _, l := range levels {
+		d, e := 0.0, 0.0
+		for n := 0; n < 30; n++ {
+			pn := pulses * l.Prob * poisson(l.Mu, n)
+			d += pn * yield(n)
+			e += pn * yield(n) * errRate(n)
+			switch n {
+			case 0:
+				s0 += pn * yield(n)
+			case 1:
+				s1 += pn * yield(n)
+				e1 += pn * yield(n) * errRate(n)
+			}
+		}
+		dets = append(dets, int(math.Round(d)))
+		errs = append(errs, int(math.Round(e)))
+	}
+	return dets, errs, s0, s1, e1
+}
+
+// exactLevels returns levels as decoy levels, ignoring any tolerances.
+func exactLevels(levels []Intensity) []decoyLevel {
+	var dls []decoyLevel
+	for _, l := range levels {
+		dls = append(dls, decoyLevel{lo: l.Mu, hi: l.Mu, prob: l.Prob})
+	}
+	return dls
+}
+
+// hoeffding bounds, from below or above according to sign, the expected number
+// of events at an intensity level, as in Lim et al.
+func hoeffding(mu, p, eps, n, nk, sign float64) float64 {
+	lo, hi := Hoeffding{}.Expectation(nk, n, eps/21)
+	if sign < 0 {
+		return math.Exp(mu) / p * lo
+	}
+	return math.Exp(mu) / p * hi
+}
+
+// limBounds computes the closed-form three-intensity bounds on the number of
+// single-photon detections and errors given in
+// https://journals.aps.org/pra/abstract/10.1103/PhysRevA.89.022307.
+func limBounds(levels []Intensity, dets, errs []int, eps float64) (s1, nu1 float64) {
+	exact := exactLevels(levels)
+	tau0, tau1 := probNPhotons(exact, 0, false), probNPhotons(exact, 1, false)
+	mu1, mu2, mu3 := levels[2].Mu, levels[1].Mu, levels[0].Mu
+	p1, p2, p3 := levels[2].Prob, levels[1].Prob, levels[0].Prob
+	n := float64(dets[0] + dets[1] + dets[2])
+	n1, n2, n3 := float64(dets[2]), float64(dets[1]), float64(dets[0])
+	s0 := tau0 * (mu2*hoeffding(mu3, p3, eps, n, n3, -1) - mu3*hoeffding(mu2, p2, eps, n, n2, 1)) / (mu2 - mu3)
+	s0 = math.Max(s0, 0)
+	num := tau1 * mu1 * (hoeffding(mu2, p2, eps, n, n2, -1) - hoeffding(mu3, p3, eps, n, n3, 1) -
+		(mu2*mu2-mu3*mu3)*(hoeffding(mu1, p1, eps, n, n1, 1)-s0/tau0)/mu1/mu1)
+	s1 = num / (mu1*(mu2-mu3) - mu2*mu2 + mu3*mu3)
+	m := float64(errs[0] + errs[1] + errs[2])
+	m2, m3 := float64(errs[1]), float64(errs[0])
+	nu1 = tau1 * (hoeffding(mu2, p2, eps, m, m2, 1) - hoeffding(mu3, p3, eps, m, m3, -1)) / (mu2 - mu3)
+	return s1, nu1
+}
+
+func TestBoundYield(t *testing.T) {
+	const eps = 1e-12
+	tcs := []struct {
+		name   string
+		levels []Intensity
+	}{
+		{
+			name:   "two",
+			levels: []Intensity{{Mu: 0.1, Prob: 0.3}, {Mu: 0.5, Prob: 0.7}},
+		},
+		{
+			name:   "three",
+			levels: []Intensity{{Mu: 0.05, Prob: 0.3}, {Mu: 0.15, Prob: 0.3}, {Mu: 0.5, Prob: 0.4}},
+		},
+		{
+			name:   "four",
+			levels: []Intensity{{Mu: 0, Prob: 0.2}, {Mu: 0.05, Prob: 0.2}, {Mu: 0.15, Prob: 0.2}, {Mu: 0.5, Prob: 0.4}},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			levels := exactLevels(tc.levels)
+			dets, errs, s0, s1, e1 := expectedCounts(tc.levels, 1e9, 0.05, 1e-5, 0.02)
+			s0Lo := probNPhotons(levels, 0, false) * boundYield(levels, dets, Hoeffding{}, eps, 0, false)
+			s1Lo := probNPhotons(levels, 1, false) * boundYield(levels, dets, Hoeffding{}, eps, 1, false)
+			e1Hi := probNPhotons(levels, 1, true) * boundYield(levels, errs, Hoeffding{}, eps, 1, true)
+			if s0Lo > s0 || s1Lo > s1 {
+				t.Errorf("got lower bounds (%f, %f) on vacuum and single-photon detections, which exceed the true (%f, %f)",
+					s0Lo, s1Lo, s0, s1)
+			}
+			if e1Hi < e1 {
+				t.Errorf("got upper bound %f on single-photon errors, below the true %f", e1Hi, e1)
+			}
+			if len(tc.levels) < 3 {
+				return
+			}
+			if s1Lo < 0.8*s1 {
+				t.Errorf("got lower bound %f on single-photon detections, want at least 80%% of the true %f", s1Lo, s1)
+			}
+			if len(tc.levels) != 3 {
+				return
+			}
+			// The linear program is at least as tight as the closed form.
+			limS1, limNu1 := limBounds(tc.levels, dets, errs, eps)
+			if s1Lo < limS1*(1-1e-6) || e1Hi > limNu1*(1+1e-6) {
+				t.Errorf("got bounds (%f, %f), looser than the closed form's (%f, %f)", s1Lo, e1Hi, limS1, limNu1)
+			}
+		})
+	}
+}
+
+func TestBoundYieldAsymptotic(t *testing.T) {
+	intensities := []Intensity{{Mu: 0.05, Prob: 0.3}, {Mu: 0.15, Prob: 0.3}, {Mu: 0.5, Prob: 0.4}}
+	levels := exactLevels(intensities)
+	dets, errs, _, s1, e1 := expectedCounts(intensities, 1e9, 0.05, 1e-5, 0.02)
+	s1Lo := probNPhotons(levels, 1, false) * boundYield(levels, dets, insecureAsymptotic{}, 0, 1, false)
+	e1Hi := probNPhotons(levels, 1, true) * boundYield(levels, errs, insecureAsymptotic{}, 0, 1, true)
+	// Even without statistical fluctuations, a finite number of intensities
+	// only bounds the yields.
+	if s1Lo > s1 || s1Lo < 0.9*s1 {
+		t.Errorf("got lower bound %f on single-photon detections, want within 10%% below the true %f", s1Lo, s1)
+	}
+	if e1Hi < e1 || e1Hi > 1.2*e1 {
+		t.Errorf("got upper bound %f on single-photon errors, want within 20%% above the true %f", e1Hi, e1)
+	}
+
+	// Counts which no mixture of yields could produce, as sampling noise may,
+	// are relaxed until they are consistent.
+	noisy := append([]int(nil), dets...)
+	noisy[1] += noisy[1] / 20
+	if _, ok := solveYield(levels, noisy, insecureAsymptotic{}, 0, 1, false); ok {
+		t.Fatalf("counts %v are consistent, want them inconsistent", noisy)
+	}
+	if y := probNPhotons(levels, 1, false) * boundYield(levels, noisy, insecureAsymptotic{}, 0, 1, false); y <= 0 || math.Abs(y-s1Lo) > 0.1*s1Lo {
+		t.Errorf("got lower bound %f on single-photon detections from noisy counts, want within 10%% of %f", y, s1Lo)
+	}
+}
+
+func TestBoundYieldTolerance(t *testing.T) {
+	const eps = 1e-12
+	nominal := []Intensity{
+		{Mu: 0.05, Tolerance: 0.005, Prob: 0.3},
+		{Mu: 0.15, Tolerance: 0.01, Prob: 0.3},
+		{Mu: 0.5, Tolerance: 0.02, Prob: 0.4},
+	}
+	levels, err := PulseAttrs{Intensities: nominal}.decoyLevels(nil)
+	if err != nil {
+		t.Fatalf("decoyLevels: %v", err)
+	}
+	exact := exactLevels(nominal)
+	tcs := []struct {
+		name   string
+		actual []float64
+	}{
+		{name: "nominal", actual: []float64{0.05, 0.15, 0.5}},
+		{name: "low", actual: []float64{0.045, 0.14, 0.48}},
+		{name: "high", actual: []float64{0.055, 0.16, 0.52}},
+		{name: "mixed", actual: []float64{0.055, 0.14, 0.52}},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := make([]Intensity, len(nominal))
+			for i, l := range nominal {
+				actual[i] = Intensity{Mu: tc.actual[i], Prob: l.Prob}
+			}
+			dets, errs, _, s1, e1 := expectedCounts(actual, 1e9, 0.05, 1e-5, 0.02)
+			s1Lo := probNPhotons(levels, 1, false) * boundYield(levels, dets, Hoeffding{}, eps, 1, false)
+			e1Hi := probNPhotons(levels, 1, true) * boundYield(levels, errs, Hoeffding{}, eps, 1, true)
+			if s1Lo > s1 || e1Hi < e1 {
+				t.Errorf("got bounds (%f, %f) on single-photon detections and errors, inconsistent with the true (%f, %f)",
+					s1Lo, e1Hi, s1, e1)
+			}
+			if s1Lo <= 0 {
+				t.Errorf("got trivial lower bound %f on single-photon detections", s1Lo)
+			}
+			// Uncertainty can only loosen the bounds.
+			exactS1 := probNPhotons(exact, 1, false) * boundYield(exact, dets, Hoeffding{}, eps, 1, false)
+			exactE1 := probNPhotons(exact, 1, true) * boundYield(exact, errs, Hoeffding{}, eps, 1, true)
+			if s1Lo > exactS1 || e1Hi < exactE1 {
+				t.Errorf("got bounds (%f, %f), tighter than with exactly known intensities (%f, %f)",
+					s1Lo, e1Hi, exactS1, exactE1)
+			}
+		})
+	}
+}
+
+func TestDecoyLevelsMonitored(t *testing.T) {
+	pa := PulseAttrs{Intensities: []Intensity{
+		{Mu: 0.05, Tolerance: 0.01, Prob: 0.5},
+		{Mu: 0.5, Tolerance: 0.05, Prob: 0.5},
+	}}
+	var mon intensityMonitor
+	mon.levels = 2
+	for _, batch := range [][]photon.IntensityRange{
+		{{Min: 0.049, Max: 0.05}, {Min: 0.5, Max: 0.51}},
+		{{Min: 0.05, Max: 0.052}, {Min: 0.4, Max: 0.5}},
+	} {
+		if err := mon.add(batch); err != nil {
+			t.Fatalf("add: %v", err)
+		}
+	}
+	got, err := pa.decoyLevels(mon.monitored())
+	if err != nil {
+		t.Fatalf("decoyLevels: %v", err)
+	}
+	// The second level's monitored range extends beyond its tolerance, which
+	// still bounds it.
+	want := []decoyLevel{{lo: 0.049, hi: 0.052, prob: 0.5}, {lo: 0.45, hi: 0.51, prob: 0.5}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got levels %v, want %v", got, want)
+	}
+
+	if err := mon.add(nil); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if mon.monitored() != nil {
+		t.Errorf("got monitored intensities %v despite an unmonitored batch", mon.monitored())
+	}
+	if err := mon.add([]photon.IntensityRange{{Min: 0, Max: 1}}); err == nil {
+		t.Errorf("monitored intensities for the wrong number of levels were accepted")
+	}
+	if _, err := pa.decoyLevels([]photon.IntensityRange{{Min: 0.2, Max: 0.3}, {Min: 0.5, Max: 0.5}}); err == nil {
+		t.Errorf("monitored intensities outside their tolerance were accepted")
+	}
+}
@@ -0,0 +1,64 @@
+// Package entropy provides sources of randomness for key negotiation, and
+// continuous health tests to catch them failing.
+package entropy
+
+import (
+	crand "crypto/rand"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+)
+
+// ErrEntropy is returned when a Source fails its health tests, and so cannot
+// be trusted to have produced random output.
+var ErrEntropy = errors.New("entropy source failed health test")
+
+// A Source provides random bytes. Unlike an arbitrary io.Reader, Read either
+// fills p entirely or returns an error.
+type Source interface {
+	Read(p []byte) (n int, err error)
+}
+
+// Crypto returns a Source backed by the operating system's cryptographically
+// secure random number generator.
+func Crypto() Source {
+	return crand.Reader
+}
+
+// A Device is a Source backed by a file, typically the character device of a
+// hardware random number generator, e.g. a QRNG.
+type Device struct {
+	f *os.File
+}
+
+// OpenDevice returns a Device reading from the file at path.
+func OpenDevice(path string) (*Device, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Device{f: f}, nil
+}
+
+// Read implements the Source interface. Short reads from the underlying file
+// are retried, and running out of data is an error.
+func (d *Device) Read(p []byte) (int, error) {
+	n, err := io.ReadFull(d.f, p)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Close closes the underlying file.
+func (d *Device) Close() error {
+	return d.f.Close()
+}
+
+// NewDeterministic returns a Source producing a pseudorandom stream entirely
+// determined by seed. It is intended for tests and simulations only, and
+// provides no security whatsoever.
+func NewDeterministic(seed int64) Source {
+	return rand.New(rand.NewSource(seed))
+}
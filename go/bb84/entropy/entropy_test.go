@@ -0,0 +1,52 @@
+package entropy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDevice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qrng")
+	want := []byte{1, 2, 3, 4, 5, 6}
+	if err := os.WriteFile(path, want, 0600); err != nil {
+		t.Fatalf("writing device: %v", err)
+	}
+	d, err := OpenDevice(path)
+	if err != nil {
+		t.Fatalf("OpenDevice: %v", err)
+	}
+	defer d.Close()
+	got := make([]byte, 4)
+	if _, err := d.Read(got); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if !bytes.Equal(got, want[:4]) {
+		t.Errorf("got %v, want %v", got, want[:4])
+	}
+	if _, err := d.Read(got); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("reading past the end: got error %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	a, b := make([]byte, 64), make([]byte, 64)
+	NewDeterministic(7).Read(a)
+	NewDeterministic(7).Read(b)
+	if !bytes.Equal(a, b) {
+		t.Errorf("equally seeded sources diverged")
+	}
+}
+
+func TestCrypto(t *testing.T) {
+	m, err := NewMonitor(Crypto(), HealthOpts{})
+	if err != nil {
+		t.Fatalf("NewMonitor: %v", err)
+	}
+	if _, err := m.Read(make([]byte, 1<<16)); err != nil {
+		t.Errorf("Read: %v", err)
+	}
+}
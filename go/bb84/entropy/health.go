@@ -0,0 +1,164 @@
+package entropy
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sync"
+
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+const (
+	// DefaultMinEntropy claims full entropy, as is appropriate for a
+	// conditioned source such as Crypto.
+	DefaultMinEntropy = 8
+	// DefaultAlpha is the false positive rate recommended by NIST SP 800-90B.
+	DefaultAlpha = 1.0 / (1 << 20)
+
+	// The window size NIST SP 800-90B specifies for the adaptive proportion
+	// test on non-binary samples, and the number of samples tested at startup.
+	aptWindow      = 512
+	startupSamples = 1024
+)
+
+// HealthOpts configures the health tests run by a Monitor.
+type HealthOpts struct {
+	// MinEntropy is the min-entropy, in bits, claimed for each byte of the
+	// source's output. It must lie in (0, 8], and defaults to
+	// DefaultMinEntropy. Overclaiming it will cause spurious alarms on
+	// healthy sources, while underclaiming it weakens the tests.
+	MinEntropy float64
+
+	// Alpha is the probability that a healthy source trips a test on any given
+	// sample. Defaults to DefaultAlpha.
+	Alpha float64
+}
+
+// A Monitor is a Source which runs the continuous health tests of NIST SP
+// 800-90B, section 4.4, over the output of an underlying Source, treating each
+// byte as a sample:
+//   - the repetition count test, which detects the source getting stuck on a
+//     single value
+//   - the adaptive proportion test, which detects the source favoring a value
+//     too heavily over a window of samples
+//
+// Before any output is returned, a startup test draws and discards 1024
+// samples, subjecting them to the same tests. An alarm fails only the Read
+// which raised it, with an error wrapping ErrEntropy: the tests are reset, and
+// the startup test rerun before the next Read returns any output. A source
+// which has genuinely failed will keep raising alarms, whereas a healthy one
+// which trips a test by chance, as it will occasionally at the rate Alpha,
+// recovers.
+type Monitor struct {
+	src Source
+
+	mu        sync.Mutex
+	started   bool
+	rctCutoff int
+	aptCutoff int
+
+	// The repetition count test's state.
+	last    byte
+	repeats int
+
+	// The adaptive proportion test's state.
+	target  byte
+	seen    int
+	matches int
+}
+
+// NewMonitor returns a Monitor running health tests over src, configured by
+// opts.
+func NewMonitor(src Source, opts HealthOpts) (*Monitor, error) {
+	h := opts.MinEntropy
+	if h == 0 {
+		h = DefaultMinEntropy
+	}
+	if h <= 0 || h > 8 {
+		return nil, fmt.Errorf("min-entropy %f must lie in (0, 8]", h)
+	}
+	alpha := opts.Alpha
+	if alpha == 0 {
+		alpha = DefaultAlpha
+	}
+	if alpha <= 0 || alpha >= 1 {
+		return nil, fmt.Errorf("alpha %f must lie in (0, 1)", alpha)
+	}
+	rct, apt := cutoffs(h, alpha)
+	return &Monitor{src: src, rctCutoff: rct, aptCutoff: apt}, nil
+}
+
+// cutoffs returns the number of repeated samples which trips the repetition
+// count test, and the number of occurrences of a sample within a window which
+// trips the adaptive proportion test, for a source with min-entropy h per
+// sample and a false positive rate of alpha.
+func cutoffs(h, alpha float64) (rct, apt int) {
+	rct = 1 + int(math.Ceil(-math.Log2(alpha)/h))
+	// As in SP 800-90B, the cutoff is one more than the critical value of a
+	// binomial distribution over the whole window.
+	b := distuv.Binomial{N: aptWindow, P: math.Pow(2, -h)}
+	apt = 1
+	for b.Survival(float64(apt-1)) > alpha {
+		apt++
+	}
+	return rct, apt
+}
+
+// Read implements the Source interface.
+func (m *Monitor) Read(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.started {
+		buf := make([]byte, startupSamples)
+		if err := m.draw(buf); err != nil {
+			return 0, fmt.Errorf("startup test: %w", err)
+		}
+		m.started = true
+	}
+	if err := m.draw(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// draw fills p from the underlying source, testing every sample. Should any
+// fail, the tests are reset, so that the next draw starts afresh.
+func (m *Monitor) draw(p []byte) error {
+	// Sources are meant to fill p entirely, but a short read must not leave
+	// stale bytes to be tested, and returned, in place of fresh ones.
+	if _, err := io.ReadFull(m.src, p); err != nil {
+		return err
+	}
+	for _, s := range p {
+		if err := m.test(s); err != nil {
+			m.started, m.repeats, m.seen = false, 0, 0
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Monitor) test(s byte) error {
+	if s == m.last && m.repeats > 0 {
+		m.repeats++
+	} else {
+		m.last, m.repeats = s, 1
+	}
+	if m.repeats >= m.rctCutoff {
+		return fmt.Errorf("%w: repetition count test: %#02x repeated %d times", ErrEntropy, s, m.repeats)
+	}
+
+	if m.seen == 0 {
+		m.target, m.matches = s, 0
+	}
+	if s == m.target {
+		m.matches++
+	}
+	m.seen = (m.seen + 1) % aptWindow
+	if m.matches >= m.aptCutoff {
+		return fmt.Errorf("%w: adaptive proportion test: %#02x occurred %d times in %d samples",
+			ErrEntropy, m.target, m.matches, aptWindow)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package entropy
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCutoffs(t *testing.T) {
+	// Cutoffs as tabulated in NIST SP 800-90B, for alpha = 2^-20.
+	tcs := []struct {
+		h        float64
+		rct, apt int
+	}{
+		{h: 8, rct: 4, apt: 13},
+		{h: 4, rct: 6, apt: 62},
+		{h: 1, rct: 21, apt: 311},
+		{h: 0.5, rct: 41, apt: 410},
+	}
+	for _, tc := range tcs {
+		if rct, apt := cutoffs(tc.h, DefaultAlpha); rct != tc.rct || apt != tc.apt {
+			t.Errorf("cutoffs(%f): got (%d, %d), want (%d, %d)", tc.h, rct, apt, tc.rct, tc.apt)
+		}
+	}
+}
+
+// A funcSource is a Source whose i'th byte is f(i).
+type funcSource struct {
+	f func(i int) byte
+	i int
+}
+
+func (s *funcSource) Read(p []byte) (int, error) {
+	for j := range p {
+		p[j] = s.f(s.i)
+		s.i++
+	}
+	return len(p), nil
+}
+
+func TestMonitor(t *testing.T) {
+	healthy := NewDeterministic(1)
+	tcs := []struct {
+		name string
+		src  Source
+		opts HealthOpts
+		fail bool
+	}{
+		{name: "healthy", src: healthy},
+		{name: "stuck at startup", src: &funcSource{f: func(int) byte { return 0x5a }}, fail: true},
+		{
+			name: "stuck later",
+			src: &funcSource{f: func(i int) byte {
+				if i > 1<<15 {
+					return 0
+				}
+				b := make([]byte, 1)
+				healthy.Read(b)
+				return b[0]
+			}},
+			fail: true,
+		},
+		{
+			// Each of 16 values occurs with probability 1/16, far more often
+			// than full entropy allows, but without long runs.
+			name: "biased",
+			src:  &funcSource{f: func(i int) byte { return byte(i*7) % 16 }},
+			fail: true,
+		},
+		{
+			name: "biased but claimed",
+			src:  &funcSource{f: func(i int) byte { return byte(i*7) % 16 }},
+			opts: HealthOpts{MinEntropy: 4},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := NewMonitor(tc.src, tc.opts)
+			if err != nil {
+				t.Fatalf("NewMonitor: %v", err)
+			}
+			var failed error
+			for i := 0; i < 64 && failed == nil; i++ {
+				_, failed = m.Read(make([]byte, 1<<10))
+			}
+			if (failed != nil) != tc.fail {
+				t.Fatalf("got error %v, want failure: %v", failed, tc.fail)
+			}
+			if failed == nil {
+				return
+			}
+			if !errors.Is(failed, ErrEntropy) {
+				t.Errorf("got error %v, want ErrEntropy", failed)
+			}
+			if _, err := m.Read(make([]byte, 1)); !errors.Is(err, ErrEntropy) {
+				t.Errorf("with the source still failing, got error %v, want ErrEntropy", err)
+			}
+		})
+	}
+}
+
+func TestMonitorRecovers(t *testing.T) {
+	healthy := NewDeterministic(1)
+	// A brief glitch, long enough to trip the repetition count test, in an
+	// otherwise healthy source.
+	src := &funcSource{f: func(i int) byte {
+		if i >= 1<<14 && i < 1<<14+8 {
+			return 0
+		}
+		b := make([]byte, 1)
+		healthy.Read(b)
+		return b[0]
+	}}
+	m, err := NewMonitor(src, HealthOpts{})
+	if err != nil {
+		t.Fatalf("NewMonitor: %v", err)
+	}
+	alarms := 0
+	for i := 0; i < 64; i++ {
+		if _, err := m.Read(make([]byte, 1<<10)); errors.Is(err, ErrEntropy) {
+			alarms++
+		} else if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+	}
+	if alarms != 1 {
+		t.Errorf("got %d alarms, want 1", alarms)
+	}
+}
+
+// A shortSource returns at most one byte per Read from an underlying Source.
+type shortSource struct {
+	src Source
+}
+
+func (s shortSource) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return s.src.Read(p)
+}
+
+func TestMonitorShortReads(t *testing.T) {
+	m, err := NewMonitor(shortSource{NewDeterministic(1)}, HealthOpts{})
+	if err != nil {
+		t.Fatalf("NewMonitor: %v", err)
+	}
+	for i := 0; i < 16; i++ {
+		if _, err := m.Read(make([]byte, 1<<10)); err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+	}
+}
+
+func TestNewMonitorValidation(t *testing.T) {
+	for _, opts := range []HealthOpts{
+		{MinEntropy: -1},
+		{MinEntropy: 9},
+		{Alpha: 1},
+	} {
+		if _, err := NewMonitor(Crypto(), opts); err == nil {
+			t.Errorf("NewMonitor accepted %+v", opts)
+		}
+	}
+}
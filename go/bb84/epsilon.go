@@ -0,0 +1,127 @@
+package bb84
+
+import "math"
+
+const (
+	// limShares is the number of equal shares Lim et al. divide their secrecy
+	// parameter into, one per failure event of parameter estimation and privacy
+	// amplification. We divide each of Epsilons.Estimation and
+	// Epsilons.Amplification the same way, which can only overcount.
+	limShares = 21
+
+	// estimationShare is the share of an overall security parameter, after
+	// authentication's, allotted to parameter estimation. Its cost grows with
+	// the square root of the block size, rather than with the log of its
+	// failure probability alone, so it gets the largest share.
+	estimationShare = 0.5
+)
+
+// An epsilonBudget describes the failure probabilities a peer may spend on each
+// round of key negotiation.
+type epsilonBudget struct {
+	// security, if non-zero, is the overall security parameter to split
+	// automatically, and supersedes correct and privacy.
+	security float64
+
+	// auth is the probability of forging any single classical message. If
+	// messages is non-zero, it only applies to the first messages of each
+	// round, see authEpsilon.
+	auth     float64
+	messages int
+
+	correct, privacy float64
+}
+
+// newEpsilonBudget returns the budget described by opts, which must already
+// have been checked.
+func newEpsilonBudget(opts PeerOpts) epsilonBudget {
+	if opts.EpsilonSecurity != 0 {
+		messages := roundMessages(opts)
+		return epsilonBudget{
+			security: opts.EpsilonSecurity,
+			auth:     opts.EpsilonSecurity / 4 / float64(messages),
+			messages: messages,
+		}
+	}
+	b := epsilonBudget{auth: opts.EpsilonAuth, correct: opts.EpsilonCorrect, privacy: opts.EpsilonPrivacy}
+	for _, eps := range []*float64{&b.auth, &b.correct, &b.privacy} {
+		if *eps == 0 {
+			*eps = DefaultEpsilon
+		}
+	}
+	return b
+}
+
+// roundMessages returns the number of classical messages exchanged by the
+// shortest possible round under opts, in which every pulse sent is sifted into
+// a block: two per batch, three per winnow iteration, and two each to finish
+// estimation and error correction. Peers skip finishing estimation unless
+// either has set abort thresholds, but since neither knows the other's in
+// advance, both count it.
+func roundMessages(opts PeerOpts) int {
+	nX, nZ, batchBytes := opts.sizes()
+	batches := (nX + nZ + batchBytes*8 - 1) / (batchBytes * 8)
+	iters := 0
+	if opts.WinnowOpts != nil {
+		iters = len(opts.WinnowOpts.Iters)
+	}
+	return 2*batches + 3*iters + 4
+}
+
+// authEpsilon returns the probability of forging the k'th classical message of
+// a round, counting from one.
+//
+// Half of authentication's share of an overall security parameter is spread
+// evenly over the messages of the shortest possible round. Lossy links need
+// more batches, and so more messages, than that, so rather than cap them the
+// other half is spread over every later message k in proportion to
+// 1/(k(k-1)), which sums to one half however long the round runs. Each
+// doubling of a round's length beyond the shortest costs two more bits per
+// tag.
+func (b epsilonBudget) authEpsilon(k int) float64 {
+	if b.messages == 0 || k <= b.messages {
+		return b.auth
+	}
+	m := float64(b.messages)
+	return b.auth * m * m / (float64(k) * float64(k-1))
+}
+
+// authSpent returns the probability of forging any of the first n classical
+// messages of a round.
+func (b epsilonBudget) authSpent(n float64) float64 {
+	if b.messages == 0 || n <= float64(b.messages) {
+		return b.auth * n
+	}
+	// The tail's terms telescope: 1/(k(k-1)) = 1/(k-1) - 1/k.
+	m := float64(b.messages)
+	return b.auth*m + b.auth*m*m*(1/m-1/n)
+}
+
+// split returns the failure probabilities to estimate parameters and extract
+// the key with. The authentication share is left to be accounted for once the
+// round's messages have been counted.
+//
+// Half of an overall security parameter is allotted to authentication. Of the
+// rest, parameter estimation is allotted estimationShare, and correctness and
+// privacy amplification, which cost log2(1/ε) and 6*log2(1/ε) bits of key
+// respectively, split the remainder in the ratio 1:6, which minimizes their
+// total cost. The split is fixed in advance: were it chosen to suit the
+// round's measurements, parameter estimation would in effect have been run
+// once per candidate split, each of which would have to be paid for.
+func (b epsilonBudget) split() Epsilons {
+	if b.security == 0 {
+		return Epsilons{Correct: b.correct, Estimation: b.privacy, Amplification: b.privacy}
+	}
+	rest := b.security / 2
+	return Epsilons{
+		Correct:       (1 - estimationShare) * rest / 7,
+		Estimation:    estimationShare * rest,
+		Amplification: (1 - estimationShare) * rest * 6 / 7,
+	}
+}
+
+// tagBits returns the length of the tag authenticating the k'th classical
+// message of a round.
+func (b epsilonBudget) tagBits(k int) int {
+	return int(math.Ceil(math.Log2(1 / b.authEpsilon(k))))
+}
@@ -0,0 +1,64 @@
+package bb84
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEpsilonBudgetSplit(t *testing.T) {
+	t.Run("fixed", func(t *testing.T) {
+		b := newEpsilonBudget(PeerOpts{EpsilonCorrect: 1e-9, EpsilonPrivacy: 1e-10})
+		got := b.split()
+		want := Epsilons{Correct: 1e-9, Estimation: 1e-10, Amplification: 1e-10}
+		if got != want || b.auth != DefaultEpsilon {
+			t.Errorf("got split %+v and auth %g, want %+v and %g", got, b.auth, want, DefaultEpsilon)
+		}
+		if got := b.authSpent(1e6); got != 1e6*DefaultEpsilon {
+			t.Errorf("got %g spent on authenticating 1e6 messages, want %g", got, 1e6*DefaultEpsilon)
+		}
+	})
+	t.Run("security", func(t *testing.T) {
+		const security = 1e-10
+		b := newEpsilonBudget(PeerOpts{EpsilonSecurity: security})
+		got := b.split()
+		// However long the round, authentication never spends more than half.
+		if sum := got.Total() + b.authSpent(math.Inf(1)); math.Abs(sum-security) > 1e-6*security {
+			t.Errorf("got split %+v, with auth totalling %g security, want %g", got, sum, float64(security))
+		}
+		if want := security / 4; math.Abs(got.Estimation-want) > 1e-6*want {
+			t.Errorf("got estimation share %g, want %g", got.Estimation, want)
+		}
+		if math.Abs(got.Amplification-6*got.Correct) > 1e-6*got.Amplification {
+			t.Errorf("got split %+v, want amplification six times correctness", got)
+		}
+	})
+}
+
+func TestEpsilonBudgetAuth(t *testing.T) {
+	const security = 1e-10
+	opts := PeerOpts{EpsilonSecurity: security, MainBlockSize: 1 << 16, TestBlockSize: 1 << 16, MeasurementBatchBytes: 1 << 12}
+	b := newEpsilonBudget(opts)
+	// Four batches, and the messages to finish estimation and error
+	// correction.
+	if b.messages != 12 {
+		t.Fatalf("got %d messages in the shortest round, want 12", b.messages)
+	}
+	spent := 0.0
+	for k := 1; k <= 1<<16; k++ {
+		eps := b.authEpsilon(k)
+		if k > 1 && eps > b.authEpsilon(k-1) {
+			t.Fatalf("message %d's forgery probability %g exceeds its predecessor's", k, eps)
+		}
+		spent += eps
+	}
+	if want := b.authSpent(1 << 16); math.Abs(spent-want) > 1e-9*want {
+		t.Errorf("spent %g authenticating %d messages, want %g", spent, 1<<16, want)
+	}
+	if got := b.tagBits(1); got != int(math.Ceil(math.Log2(4*12/security))) {
+		t.Errorf("got %d bit tags for the shortest round's messages", got)
+	}
+	// Growing the round by a factor of 2^10 costs two bits per doubling.
+	if got, want := b.tagBits(12<<10), b.tagBits(12)+20; got > want+1 {
+		t.Errorf("got %d bit tags a thousandfold into the round, want at most %d", got, want+1)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 
 	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+	"github.com/alan-christopher/bb84/go/generated/bb84pb"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -20,9 +21,38 @@ type protoFramer struct {
 	rw     io.ReadWriter
 	secret io.Reader
 	t      toeplitz
+
+	// transcript, if non-nil, records every message successfully written, and
+	// every message read, whether accepted or rejected.
+	transcript *transcriptWriter
+
+	// eps, if it spreads authentication's failure probability unevenly over
+	// each round's messages, as tallied in Stats, sets the length of each
+	// message's tag, overriding t.m.
+	eps epsilonBudget
+
+	// setupSecret is the number of bytes of secret consumed choosing t, which
+	// have yet to be reported in Stats.
+	setupSecret int
+}
+
+// reportSetup accounts for the secret consumed choosing the hash function, the
+// first time it is called.
+func (p *protoFramer) reportSetup(s *Stats) {
+	s.SecretBytes += p.setupSecret
+	p.setupSecret = 0
+}
+
+// sizeTag sets the length of the next message's tag, if it varies from
+// message to message.
+func (p *protoFramer) sizeTag(s *Stats) {
+	if p.eps.messages > 0 {
+		p.t.m = p.eps.tagBits(s.MessagesSent + s.MessagesReceived + 1)
+	}
 }
 
 func (p *protoFramer) Write(m proto.Message, s *Stats) error {
+	p.sizeTag(s)
 	marshalled, err := proto.Marshal(m)
 	if err != nil {
 		return err
@@ -39,15 +69,20 @@ func (p *protoFramer) Write(m proto.Message, s *Stats) error {
 	if err != nil {
 		return err
 	}
+	s.SecretBytes += len(mac)
 	if _, err := p.rw.Write(mac); err != nil {
 		return err
 	}
 	s.BytesSent += len(mac)
 	s.MessagesSent++
+	if p.transcript != nil {
+		return p.transcript.record(bb84pb.TranscriptEntry_SENT, m, marshalled)
+	}
 	return nil
 }
 
 func (p *protoFramer) Read(m proto.Message, s *Stats) error {
+	p.sizeTag(s)
 	var mLen int32
 	if err := binary.Read(p.rw, binary.LittleEndian, &mLen); err != nil {
 		return err
@@ -67,11 +102,28 @@ func (p *protoFramer) Read(m proto.Message, s *Stats) error {
 	if err != nil {
 		return err
 	}
+	s.SecretBytes += len(emac)
 	if !bytes.Equal(mac, emac) {
-		return fmt.Errorf("invalid mac: got %v, expected %v", mac, emac)
+		return p.reject(m, marshalled, mac, fmt.Errorf("%w: got %v, expected %v", ErrInvalidMAC, mac, emac))
 	}
 	s.MessagesReceived++
-	return proto.Unmarshal(marshalled, m)
+	if err := proto.Unmarshal(marshalled, m); err != nil {
+		return p.reject(m, marshalled, mac, err)
+	}
+	if p.transcript != nil {
+		return p.transcript.record(bb84pb.TranscriptEntry_RECEIVED, m, marshalled)
+	}
+	return nil
+}
+
+// reject records a received frame which failed verification or decoding as m,
+// before returning err. Since err is what matters to the caller, a failure to
+// record the frame is not reported.
+func (p *protoFramer) reject(m proto.Message, marshalled, mac []byte, err error) error {
+	if p.transcript != nil {
+		p.transcript.recordRejected(m, marshalled, mac, err)
+	}
+	return err
 }
 
 func (p *protoFramer) buildMAC(msg []byte) ([]byte, error) {
@@ -102,3 +102,34 @@ func TestMACVerification(t *testing.T) {
 		t.Fatalf("Read of invalid MAC did not fail.")
 	}
 }
+
+func TestTagLengths(t *testing.T) {
+	pf := &protoFramer{
+		rw:     &bytes.Buffer{},
+		secret: bytes.NewBuffer(make([]byte, 1<<12)),
+		t:      toeplitz{diags: bitmap.NewDense(make([]byte, 1<<10), -1)},
+		eps:    epsilonBudget{auth: 1.0 / (1 << 40), messages: 2},
+	}
+	s := &Stats{}
+	var tagBytes []int
+	for i := 0; i < 64; i++ {
+		before := s.BytesSent
+		if err := pf.Write(&bb84pb.PulseRange{Count: 1}, s); err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		// Each frame is a 4 byte length, a 2 byte message, and the tag.
+		tagBytes = append(tagBytes, s.BytesSent-before-6)
+	}
+	if tagBytes[0] != 5 || tagBytes[1] != 5 {
+		t.Errorf("got tags of %v bytes for the first messages, want 5", tagBytes[:2])
+	}
+	if tagBytes[63] <= tagBytes[1] {
+		t.Errorf("got tags of %d bytes for the 64th message, want them longer than the %d of the first", tagBytes[63], tagBytes[1])
+	}
+	if err := pf.Write(&bb84pb.PulseRange{Count: 1}, &Stats{}); err != nil {
+		t.Fatalf("new round: %v", err)
+	}
+	if pf.t.m != 40 {
+		t.Errorf("got %d bit tags for a new round's first message, want 40", pf.t.m)
+	}
+}
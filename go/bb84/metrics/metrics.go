@@ -0,0 +1,293 @@
+// Package metrics exports the results of BB84 key negotiations as Prometheus
+// metrics, so that the health of long-running links can be tracked over time.
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/alan-christopher/bb84/go/bb84"
+	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+	"github.com/alan-christopher/bb84/go/bb84/entropy"
+)
+
+// Failure reasons, as reported in the "reason" label of bb84_failures_total.
+const (
+	ReasonKeyTooShort        = "key_too_short"
+	ReasonAborted            = "aborted"
+	ReasonVerificationFailed = "verification_failed"
+	ReasonInvalidMAC         = "invalid_mac"
+	ReasonDesync             = "desync"
+	ReasonEntropy            = "entropy"
+	ReasonChannelClosed      = "channel_closed"
+	ReasonOther              = "other"
+)
+
+var reasons = []string{
+	ReasonKeyTooShort,
+	ReasonAborted,
+	ReasonVerificationFailed,
+	ReasonInvalidMAC,
+	ReasonDesync,
+	ReasonEntropy,
+	ReasonChannelClosed,
+	ReasonOther,
+}
+
+// Reason classifies the error returned from a failed key negotiation into one
+// of a small, fixed set of failure reasons.
+func Reason(err error) string {
+	switch {
+	case errors.Is(err, bb84.ErrKeyTooShort):
+		return ReasonKeyTooShort
+	case errors.Is(err, bb84.ErrAborted):
+		return ReasonAborted
+	case errors.Is(err, bb84.ErrVerificationFailed):
+		return ReasonVerificationFailed
+	case errors.Is(err, bb84.ErrInvalidMAC):
+		return ReasonInvalidMAC
+	case errors.Is(err, bb84.ErrDesync):
+		return ReasonDesync
+	case errors.Is(err, entropy.ErrEntropy):
+		return ReasonEntropy
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.ErrClosedPipe):
+		return ReasonChannelClosed
+	}
+	return ReasonOther
+}
+
+// An Exporter collects metrics for a set of named links and serves them in the
+// Prometheus text exposition format.
+type Exporter struct {
+	mu    sync.Mutex
+	links map[string]*Link
+}
+
+// NewExporter returns a new Exporter with no links.
+func NewExporter() *Exporter {
+	return &Exporter{links: map[string]*Link{}}
+}
+
+// Link returns the Link with the given name, creating it if necessary.
+func (e *Exporter) Link(name string) *Link {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	l, ok := e.links[name]
+	if !ok {
+		l = &Link{s: snapshot{name: name, failures: map[string]int64{}}}
+		e.links[name] = l
+	}
+	return l
+}
+
+// ServeHTTP implements http.Handler.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if _, err := e.WriteTo(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ListenAndServe serves the metrics collected by e at /metrics on addr, e.g.
+// "localhost:9184". It blocks until the underlying server fails.
+func (e *Exporter) ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	return http.ListenAndServe(addr, mux)
+}
+
+// WriteTo writes every metric collected by e to w in the Prometheus text
+// exposition format.
+func (e *Exporter) WriteTo(w io.Writer) (int64, error) {
+	e.mu.Lock()
+	var names []string
+	for n := range e.links {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var snaps []snapshot
+	for _, n := range names {
+		snaps = append(snaps, e.links[n].snapshot())
+	}
+	e.mu.Unlock()
+
+	var b strings.Builder
+	for _, m := range families {
+		fmt.Fprintf(&b, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(&b, "# TYPE %s %s\n", m.name, m.kind)
+		for _, s := range snaps {
+			if m.name == "bb84_secret_pool_remaining_bytes" && !s.secretTracked {
+				continue
+			}
+			fmt.Fprintf(&b, "%s{link=%q} %s\n", m.name, escape(s.name), format(m.value(s)))
+		}
+	}
+	fmt.Fprintf(&b, "# HELP bb84_failures_total Key negotiations which failed, by reason.\n")
+	fmt.Fprintf(&b, "# TYPE bb84_failures_total counter\n")
+	for _, s := range snaps {
+		for _, r := range reasons {
+			fmt.Fprintf(&b, "bb84_failures_total{link=%q,reason=%q} %d\n", escape(s.name), r, s.failures[r])
+		}
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// A Link accumulates metrics for the key negotiations performed over a single
+// Alice/Bob pair. It is safe for concurrent use.
+type Link struct {
+	mu sync.Mutex
+	s  snapshot
+}
+
+// Observe records the outcome of one round of key negotiation. Its arguments
+// mirror the return values of bb84.Peer.NegotiateKey, e.g.
+//
+//	link.Observe(peer.NegotiateKey())
+func (l *Link) Observe(key bitmap.Dense, s bb84.Stats, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.s.rounds++
+	l.s.pulses += int64(s.Pulses)
+	l.s.detections += int64(s.QBits)
+	l.s.bitsLeaked += int64(s.BitsLeaked)
+	l.s.messagesSent += int64(s.MessagesSent)
+	l.s.messagesRecv += int64(s.MessagesReceived)
+	l.s.bytesSent += int64(s.BytesSent)
+	l.s.bytesRead += int64(s.BytesRead)
+	if err != nil {
+		l.s.failures[Reason(err)]++
+		return
+	}
+	l.s.keyBits += int64(key.Size())
+	l.s.qber = s.QBER
+	l.s.keyRate = 0
+	if s.Pulses > 0 {
+		l.s.keyRate = float64(key.Size()) / float64(s.Pulses)
+	}
+}
+
+// TrackSecret wraps the shared secret used to authenticate this link, so that
+// the number of bytes left in it can be exported. poolBytes specifies how many
+// bytes secret holds before any have been read. The returned reader should be
+// used in place of secret, e.g. as bb84.PeerOpts.Secret.
+func (l *Link) TrackSecret(secret io.Reader, poolBytes int) io.Reader {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.s.secretPool = int64(poolBytes)
+	l.s.secretTracked = true
+	return &secretReader{r: secret, l: l}
+}
+
+type secretReader struct {
+	r io.Reader
+	l *Link
+}
+
+func (sr *secretReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	sr.l.mu.Lock()
+	sr.l.s.secretPool -= int64(n)
+	sr.l.mu.Unlock()
+	return n, err
+}
+
+// Instrument returns a Peer which behaves like p, but reports the outcome of
+// every call to NegotiateKey to l.
+func Instrument(p bb84.Peer, l *Link) bb84.Peer {
+	return instrumented{p, l}
+}
+
+type instrumented struct {
+	p bb84.Peer
+	l *Link
+}
+
+func (i instrumented) NegotiateKey() (bitmap.Dense, bb84.Stats, error) {
+	key, stats, err := i.p.NegotiateKey()
+	i.l.Observe(key, stats, err)
+	return key, stats, err
+}
+
+// A snapshot holds the metrics accumulated by a Link.
+type snapshot struct {
+	name          string
+	rounds        int64
+	failures      map[string]int64
+	keyBits       int64
+	pulses        int64
+	detections    int64
+	bitsLeaked    int64
+	messagesSent  int64
+	messagesRecv  int64
+	bytesSent     int64
+	bytesRead     int64
+	keyRate       float64
+	qber          float64
+	secretPool    int64
+	secretTracked bool
+}
+
+func (l *Link) snapshot() snapshot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r := l.s
+	r.failures = map[string]int64{}
+	for reason, n := range l.s.failures {
+		r.failures[reason] = n
+	}
+	return r
+}
+
+type family struct {
+	name, help, kind string
+	value            func(snapshot) float64
+}
+
+var families = []family{
+	{"bb84_rounds_total", "Key negotiations attempted.", "counter",
+		func(s snapshot) float64 { return float64(s.rounds) }},
+	{"bb84_key_bits_total", "Secret key bits produced.", "counter",
+		func(s snapshot) float64 { return float64(s.keyBits) }},
+	{"bb84_key_rate_bits_per_pulse", "Secret key bits per pulse in the most recent successful round.", "gauge",
+		func(s snapshot) float64 { return s.keyRate }},
+	{"bb84_qber", "Quantum bit error rate observed in the most recent successful round.", "gauge",
+		func(s snapshot) float64 { return s.qber }},
+	{"bb84_pulses_total", "Photon pulses exchanged.", "counter",
+		func(s snapshot) float64 { return float64(s.pulses) }},
+	{"bb84_detections_total", "Sifted photon detections.", "counter",
+		func(s snapshot) float64 { return float64(s.detections) }},
+	{"bb84_reconciliation_leaked_bits_total", "Bits of key information disclosed during reconciliation.", "counter",
+		func(s snapshot) float64 { return float64(s.bitsLeaked) }},
+	{"bb84_messages_sent_total", "Classical messages sent.", "counter",
+		func(s snapshot) float64 { return float64(s.messagesSent) }},
+	{"bb84_messages_received_total", "Classical messages received.", "counter",
+		func(s snapshot) float64 { return float64(s.messagesRecv) }},
+	{"bb84_bytes_sent_total", "Classical bytes sent.", "counter",
+		func(s snapshot) float64 { return float64(s.bytesSent) }},
+	{"bb84_bytes_received_total", "Classical bytes received.", "counter",
+		func(s snapshot) float64 { return float64(s.bytesRead) }},
+	{"bb84_secret_pool_remaining_bytes", "Bytes of authentication secret not yet consumed.", "gauge",
+		func(s snapshot) float64 { return float64(s.secretPool) }},
+}
+
+func format(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escape prepares a label value for use with %q. Go's quoting is a superset of
+// what the exposition format allows, so we only pass through printable ASCII.
+func escape(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return '_'
+		}
+		return r
+	}, s)
+}
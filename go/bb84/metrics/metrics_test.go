@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alan-christopher/bb84/go/bb84"
+	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+	"github.com/alan-christopher/bb84/go/bb84/entropy"
+)
+
+func TestReason(t *testing.T) {
+	tcs := []struct {
+		err  error
+		want string
+	}{
+		{fmt.Errorf("%w: safe len == 1", bb84.ErrKeyTooShort), ReasonKeyTooShort},
+		{fmt.Errorf("%w by peer: QBER 0.3 exceeds 0.11", bb84.ErrAborted), ReasonAborted},
+		{bb84.ErrVerificationFailed, ReasonVerificationFailed},
+		{fmt.Errorf("receiving: %w", bb84.ErrInvalidMAC), ReasonInvalidMAC},
+		{fmt.Errorf("%w: batches differ", bb84.ErrDesync), ReasonDesync},
+		{fmt.Errorf("drawing seed: %w", entropy.ErrEntropy), ReasonEntropy},
+		{fmt.Errorf("receiving: %w", io.EOF), ReasonChannelClosed},
+		{fmt.Errorf("something else"), ReasonOther},
+	}
+	for _, tc := range tcs {
+		if got := Reason(tc.err); got != tc.want {
+			t.Errorf("Reason(%v) == %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	e := NewExporter()
+	l := e.Link("alice-bob")
+	secret := l.TrackSecret(bytes.NewReader(make([]byte, 100)), 100)
+	if _, err := io.ReadFull(secret, make([]byte, 30)); err != nil {
+		t.Fatalf("reading secret: %v", err)
+	}
+	l.Observe(bitmap.NewDense(nil, 50), bb84.Stats{
+		Pulses:       1000,
+		QBits:        200,
+		QBER:         0.02,
+		MessagesSent: 3,
+		BytesSent:    120,
+		BitsLeaked:   40,
+	}, nil)
+	l.Observe(bitmap.Empty(), bb84.Stats{Pulses: 1000}, bb84.ErrVerificationFailed)
+
+	var b strings.Builder
+	if _, err := e.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		`bb84_rounds_total{link="alice-bob"} 2`,
+		`bb84_key_bits_total{link="alice-bob"} 50`,
+		`bb84_key_rate_bits_per_pulse{link="alice-bob"} 0.05`,
+		`bb84_qber{link="alice-bob"} 0.02`,
+		`bb84_pulses_total{link="alice-bob"} 2000`,
+		`bb84_detections_total{link="alice-bob"} 200`,
+		`bb84_reconciliation_leaked_bits_total{link="alice-bob"} 40`,
+		`bb84_messages_sent_total{link="alice-bob"} 3`,
+		`bb84_bytes_sent_total{link="alice-bob"} 120`,
+		`bb84_secret_pool_remaining_bytes{link="alice-bob"} 70`,
+		`bb84_failures_total{link="alice-bob",reason="verification_failed"} 1`,
+		`bb84_failures_total{link="alice-bob",reason="key_too_short"} 0`,
+		"# TYPE bb84_pulses_total counter",
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	e := NewExporter()
+	e.Link(`quoted"link`).Observe(bitmap.NewDense(nil, 8), bb84.Stats{Pulses: 16}, nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(rec.Body)
+	if want := `bb84_key_bits_total{link="quoted\"link"} 8`; !strings.Contains(string(body), want) {
+		t.Errorf("response missing %q:\n%s", want, body)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("got Content-Type %q, want text/plain", ct)
+	}
+	if strings.Contains(string(body), "bb84_secret_pool_remaining_bytes{") {
+		t.Errorf("untracked secret pool should not be exported:\n%s", body)
+	}
+}
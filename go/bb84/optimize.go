@@ -0,0 +1,139 @@
+package bb84
+
+import (
+	"errors"
+	"math"
+
+	"gonum.org/v1/gonum/optimize"
+)
+
+// optimizeEvaluations caps the plans evaluated by Optimize.
+const optimizeEvaluations = 3000
+
+// Optimize searches for the intensities, intensity probabilities and basis bias
+// which maximize the expected secret key rate, in bits per pulse, of the link
+// described by opts, starting from opts.Peer.PulseAttrs and opts.PMain. Each
+// candidate is evaluated with Plan, and the search is by the Nelder-Mead
+// method, over a parameterization which only admits candidates satisfying the
+// constraints NewPeer enforces. The number of intensity levels and their
+// tolerances are held fixed, as is a vacuum lowest level. For a single-photon
+// source, only PMain is searched over.
+//
+// The main block size is held fixed too, and the test block size is chosen so
+// that both blocks fill at the same time. Otherwise the rate would only improve
+// as PMain approached 1, at the cost of ever longer rounds.
+//
+// It returns opts updated with the best parameters found, expressed as
+// PulseAttrs.Intensities, PMain and Peer.TestBlockSize, along with their plan.
+func Optimize(opts PlanOpts) (PlanOpts, KeyPlan, error) {
+	if _, err := Plan(opts); err != nil {
+		return opts, KeyPlan{}, err
+	}
+	nX := opts.Peer.MainBlockSize
+	if nX == 0 {
+		nX = DefaultMainBlockSize
+	}
+	var s decoySpace
+	if !opts.Peer.SinglePhotonSource {
+		s.levels = append(s.levels, opts.Peer.PulseAttrs.levels()...)
+	}
+	candidate := func(x []float64) PlanOpts {
+		o := opts
+		if len(s.levels) > 0 {
+			o.Peer.PulseAttrs = PulseAttrs{Intensities: s.intensities(x)}
+		}
+		o.PMain = logistic(x[0])
+		o.Peer.MainBlockSize = nX
+		pX, pZ := o.PMain*o.PMain, (1-o.PMain)*(1-o.PMain)
+		o.Peer.TestBlockSize = int(math.Max(math.Round(float64(nX)*pZ/pX), 1))
+		return o
+	}
+	problem := optimize.Problem{
+		Func: func(x []float64) float64 {
+			p, err := Plan(candidate(x))
+			if r := keyRate(p); err == nil && !math.IsNaN(r) {
+				return -r
+			}
+			return math.Inf(1)
+		},
+	}
+	settings := &optimize.Settings{FuncEvaluations: optimizeEvaluations}
+	res, err := optimize.Minimize(problem, s.encode(opts.PMain), settings, &optimize.NelderMead{})
+	if err != nil && res == nil {
+		return opts, KeyPlan{}, err
+	}
+	if math.IsInf(res.F, 1) {
+		return opts, KeyPlan{}, errors.New("no feasible parameters found")
+	}
+	best := candidate(res.X)
+	p, err := Plan(best)
+	return best, p, err
+}
+
+// keyRate returns the expected key rate of p, in bits per pulse. Plans which
+// yield no key are scored by how far short they fall, so that the search can
+// make progress towards ones that do.
+func keyRate(p KeyPlan) float64 {
+	bits := float64(p.Estimates.KeyLen)
+	if bits == 0 {
+		bits = math.Min(float64(p.Estimates.SafeKeyLen-p.BitsLeaked), 0)
+	}
+	return bits / float64(p.Pulses)
+}
+
+// A decoySpace maps unconstrained vectors onto intensity levels. A vector holds
+// the logit of the main basis probability, then the log of each level's
+// probability, up to normalization, and then the log of the lowest mean
+// photon number, unless it is zero, followed by the logs of the gaps between
+// successive levels' tolerance intervals.
+type decoySpace struct {
+	levels []Intensity
+}
+
+func (s decoySpace) vacuum() bool {
+	return s.levels[0].Mu == 0
+}
+
+func (s decoySpace) encode(pMain float64) []float64 {
+	x := []float64{math.Log(pMain / (1 - pMain))}
+	for _, l := range s.levels {
+		x = append(x, math.Log(l.Prob))
+	}
+	if len(s.levels) > 0 && !s.vacuum() {
+		x = append(x, math.Log(s.levels[0].Mu))
+	}
+	for k := 1; k < len(s.levels); k++ {
+		x = append(x, math.Log(s.gap(k, s.levels[k].Mu, s.levels[k-1].Mu)))
+	}
+	return x
+}
+
+// gap returns the space between the tolerance intervals of level k, at mu, and
+// level k-1, at prev.
+func (s decoySpace) gap(k int, mu, prev float64) float64 {
+	return mu - s.levels[k].Tolerance - prev - s.levels[k-1].Tolerance
+}
+
+func (s decoySpace) intensities(x []float64) []Intensity {
+	n := len(s.levels)
+	probs, mus := x[1:1+n], x[1+n:]
+	levels := make([]Intensity, n)
+	total := 0.0
+	for k := range levels {
+		total += math.Exp(probs[k])
+	}
+	for k := range levels {
+		levels[k] = Intensity{Tolerance: s.levels[k].Tolerance, Prob: math.Exp(probs[k]) / total}
+	}
+	if !s.vacuum() {
+		levels[0].Mu, mus = math.Exp(mus[0]), mus[1:]
+	}
+	for k := 1; k < n; k++ {
+		levels[k].Mu = levels[k-1].Mu + s.levels[k-1].Tolerance + s.levels[k].Tolerance + math.Exp(mus[k-1])
+	}
+	return levels
+}
+
+func logistic(x float64) float64 {
+	return 1 / (1 + math.Exp(-x))
+}
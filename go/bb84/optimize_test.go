@@ -0,0 +1,61 @@
+package bb84
+
+import (
+	"testing"
+
+	"github.com/alan-christopher/bb84/go/bb84/photon"
+)
+
+func TestOptimize(t *testing.T) {
+	pa := PulseAttrs{}
+	pa.MuLo, pa.MuMed, pa.MuHi = 0.05, 0.1, 0.3
+	pa.ProbLo, pa.ProbMed, pa.ProbHi = 0.34, 0.33, 0.33
+	opts := PlanOpts{
+		Peer:  PeerOpts{PulseAttrs: pa},
+		PMain: 0.5,
+		Link: photon.FiberLink{
+			LengthKm:           25,
+			AttenuationDBPerKm: 0.2,
+			DetectorEfficiency: 0.6,
+			DarkCountProb:      1e-6,
+			Misalignment:       0.01,
+		},
+	}
+	before, err := Plan(opts)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	best, after, err := Optimize(opts)
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	t.Logf("%+v, PMain %f: %+v", best.Peer.PulseAttrs.Intensities, best.PMain, after)
+	if err := checkEstimationOpts(best.Peer); err != nil {
+		t.Errorf("optimized parameters are invalid: %v", err)
+	}
+	if keyRate(after) <= keyRate(before) {
+		t.Errorf("got key rate %g after optimizing, want more than %g", keyRate(after), keyRate(before))
+	}
+}
+
+func TestOptimizeSinglePhoton(t *testing.T) {
+	opts := PlanOpts{
+		Peer:  PeerOpts{SinglePhotonSource: true},
+		PMain: 0.5,
+		Link:  photon.FiberLink{DetectorEfficiency: 0.6, DarkCountProb: 1e-6, Misalignment: 0.02},
+	}
+	before, err := Plan(opts)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	best, after, err := Optimize(opts)
+	if err != nil {
+		t.Fatalf("Optimize: %v", err)
+	}
+	if !best.Peer.SinglePhotonSource || !best.Peer.PulseAttrs.zero() {
+		t.Errorf("got pulse attributes %+v, want a single-photon source", best.Peer.PulseAttrs)
+	}
+	if best.PMain <= opts.PMain || keyRate(after) <= keyRate(before) {
+		t.Errorf("got PMain %f and key rate %g, want more biased and more than %g", best.PMain, keyRate(after), keyRate(before))
+	}
+}
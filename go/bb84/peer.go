@@ -1,83 +1,143 @@
 package bb84
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
-	"math/rand"
+	"math/bits"
+	"time"
 
 	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+	"github.com/alan-christopher/bb84/go/bb84/entropy"
 	"github.com/alan-christopher/bb84/go/bb84/photon"
 	"github.com/alan-christopher/bb84/go/generated/bb84pb"
-	"gonum.org/v1/gonum/stat/distuv"
 )
 
 // An alice represents the first BB84 participant.
 type alice struct {
-	sender         photon.Sender
+	sender         photon.BatchSender
 	sideChannel    *protoFramer
-	rand           *rand.Rand
+	rand           entropy.Source
 	reconciler     reconciler
 	measBatchBytes int
-	epsPriv        float64
-	epsCorrect     float64
+	eps            epsilonBudget
 	sampleProp     float64
 	pulseAttrs     PulseAttrs
+	bound          ConcentrationBound
+	limits         abortLimits
+	peerLimits     bool
+	alignSearch    int
 	nX             int
 	nZ             int
 }
 
 // A bob represents the second BB84 participant.
 type bob struct {
-	receiver       photon.Receiver
+	receiver       photon.BatchReceiver
 	sideChannel    *protoFramer
-	rand           *rand.Rand
+	rand           entropy.Source
 	reconciler     reconciler
 	measBatchBytes int
-	epsPriv        float64
-	epsCorrect     float64
+	eps            epsilonBudget
 	sampleProp     float64
 	pulseAttrs     PulseAttrs
+	bound          ConcentrationBound
+	limits         abortLimits
+	peerLimits     bool
 	nX             int
 	nZ             int
 }
 
+// measurements holds a set of measured bits, both in their entirety and broken
+// down by the intensity level of the pulses they were measured from.
 type measurements struct {
-	all, lo, med, hi bitmap.Dense
+	all     bitmap.Dense
+	byLevel []bitmap.Dense
+}
+
+// A siftedBasis holds the sifted measurements in one basis: those kept secret
+// for the key, those disclosed for parameter estimation, and the errors among
+// the latter.
+type siftedBasis struct {
+	key, sample, errors measurements
+}
+
+func (s *siftedBasis) Append(o siftedBasis) {
+	s.key.Append(o.key)
+	s.sample.Append(o.sample)
+	s.errors.Append(o.errors)
+}
+
+// size returns the number of measurements in s, disclosed or not.
+func (s siftedBasis) size() int {
+	return s.key.all.Size() + s.sample.all.Size()
+}
+
+// rawKey returns the undisclosed measurements of both bases, main basis first.
+func rawKey(main, test siftedBasis) bitmap.Dense {
+	var k bitmap.Dense
+	k.Append(main.key.all)
+	k.Append(test.key.all)
+	return k
 }
 
 func (m *measurements) Append(o measurements) {
 	m.all.Append(o.all)
-	m.lo.Append(o.lo)
-	m.med.Append(o.med)
-	m.hi.Append(o.hi)
+	if m.byLevel == nil {
+		m.byLevel = make([]bitmap.Dense, len(o.byLevel))
+	}
+	for k := range o.byLevel {
+		m.byLevel[k].Append(o.byLevel[k])
+	}
 }
 
 // NegotiateKey implements the Peer interface.
 func (a *alice) NegotiateKey() (key bitmap.Dense, stats Stats, err error) {
-	var main, test, errors measurements
-	for main.all.Size() < a.nX || test.all.Size() < a.nZ {
-		bits, bases, lo, med, hi, err := a.sendQBits()
-		stats.Pulses += bits.Size()
+	defer func() {
+		stats.Epsilons.Auth = a.eps.authSpent(float64(stats.MessagesSent + stats.MessagesReceived))
+	}()
+	a.sideChannel.reportSetup(&stats)
+	stats.Insecure = isAsymptotic(a.bound)
+	var main, test siftedBasis
+	mon := intensityMonitor{levels: len(a.pulseAttrs.levels())}
+	for main.size() < a.nX || test.size() < a.nZ {
+		start := time.Now()
+		batch, err := a.sendQBits()
+		stats.Timings.Transmission += time.Since(start)
+		stats.Pulses += batch.Bits.Size()
 		if err != nil {
 			return bitmap.Empty(), stats, err
 		}
-		m, t, e, err := a.sift(bits, bases, lo, med, hi, &stats)
+		start = time.Now()
+		m, t, err := a.sift(batch, &mon, &stats)
+		stats.Timings.Sifting += time.Since(start)
 		if err != nil {
 			return bitmap.Empty(), stats, err
 		}
-		stats.QBits += m.all.Size() + t.all.Size()
+		stats.QBits += m.size() + t.size()
 		main.Append(m)
 		test.Append(t)
-		errors.Append(e)
 	}
-	keyLen := calcSafeKeyLen(main, test, errors, a.pulseAttrs, a.epsPriv, a.epsCorrect, &stats)
-	recRes, err := a.reconciler.Reconcile(main.all, &stats)
+	start := time.Now()
+	levels, err := a.pulseAttrs.decoyLevels(mon.monitored())
+	if err != nil {
+		return bitmap.Empty(), stats, err
+	}
+	keyLen := calcSafeKeyLen(main, test, levels, a.bound, a.eps, &stats)
+	stats.Timings.Estimation = time.Since(start)
+	if err = a.estimationFinished(&stats); err != nil {
+		return
+	}
+	start = time.Now()
+	recRes, err := a.reconciler.Reconcile(rawKey(main, test), &stats)
+	stats.Timings.Reconciliation = time.Since(start)
 	if err != nil {
 		return
 	}
+	stats.BitsLeaked = recRes.bitsLeaked
 	if keyLen < recRes.bitsLeaked {
-		err = fmt.Errorf("cannot make safe key: safe len == %d, ec loss == %d", keyLen, recRes.bitsLeaked)
+		err = fmt.Errorf("%w: safe len == %d, ec loss == %d", ErrKeyTooShort, keyLen, recRes.bitsLeaked)
 		return
 	}
 	keyLen -= recRes.bitsLeaked
@@ -86,11 +146,16 @@ func (a *alice) NegotiateKey() (key bitmap.Dense, stats Stats, err error) {
 	if keyLen > recRes.xHat.Size() {
 		keyLen = recRes.xHat.Size()
 	}
+	stats.Estimates.KeyLen = keyLen
+	start = time.Now()
 	seed, err := a.ecFinished(recRes.xHat, keyLen, &stats)
+	stats.Timings.Verification = time.Since(start)
 	if err != nil {
 		return
 	}
+	start = time.Now()
 	key, err = hash(seed, recRes.xHat, keyLen)
+	stats.Timings.Extraction = time.Since(start)
 	if err != nil {
 		return
 	}
@@ -99,32 +164,54 @@ func (a *alice) NegotiateKey() (key bitmap.Dense, stats Stats, err error) {
 
 // NegotiateKey implements the Peer interface.
 func (b *bob) NegotiateKey() (key bitmap.Dense, stats Stats, err error) {
-	var main, test, errors measurements
-	for main.all.Size() < b.nX || test.all.Size() < b.nZ {
+	defer func() {
+		stats.Epsilons.Auth = b.eps.authSpent(float64(stats.MessagesSent + stats.MessagesReceived))
+	}()
+	b.sideChannel.reportSetup(&stats)
+	stats.Insecure = isAsymptotic(b.bound)
+	var main, test siftedBasis
+	mon := intensityMonitor{levels: len(b.pulseAttrs.levels())}
+	for main.size() < b.nX || test.size() < b.nZ {
 		// TODO: In a realistic setup with non-ideal photon sources the vast
 		//   majority of our pulses will be dropped, so we can reduce bandwidth
 		//   by encoding dropped a sparse matrix of detected pulses.
-		bits, bases, dropped, err := b.receiveQBits()
-		stats.Pulses += bits.Size()
+		start := time.Now()
+		batch, err := b.receiveQBits()
+		stats.Timings.Transmission += time.Since(start)
+		stats.Pulses += batch.Bits.Size()
 		if err != nil {
 			return bitmap.Empty(), stats, err
 		}
-		m, t, e, err := b.sift(bits, bases, dropped, &stats)
+		doubles := recordDetectorEvents(batch, &stats)
+		start = time.Now()
+		m, t, err := b.sift(batch, doubles, &mon, &stats)
+		stats.Timings.Sifting += time.Since(start)
 		if err != nil {
 			return bitmap.Empty(), stats, err
 		}
-		stats.QBits += m.all.Size() + t.all.Size()
+		stats.QBits += m.size() + t.size()
 		main.Append(m)
 		test.Append(t)
-		errors.Append(e)
 	}
-	keyLen := calcSafeKeyLen(main, test, errors, b.pulseAttrs, b.epsPriv, b.epsCorrect, &stats)
-	recRes, err := b.reconciler.Reconcile(main.all, &stats)
+	start := time.Now()
+	levels, err := b.pulseAttrs.decoyLevels(mon.monitored())
+	if err != nil {
+		return bitmap.Empty(), stats, err
+	}
+	keyLen := calcSafeKeyLen(main, test, levels, b.bound, b.eps, &stats)
+	stats.Timings.Estimation = time.Since(start)
+	if err = b.estimationFinished(&stats); err != nil {
+		return
+	}
+	start = time.Now()
+	recRes, err := b.reconciler.Reconcile(rawKey(main, test), &stats)
+	stats.Timings.Reconciliation = time.Since(start)
 	if err != nil {
 		return
 	}
+	stats.BitsLeaked = recRes.bitsLeaked
 	if keyLen < recRes.bitsLeaked {
-		err = fmt.Errorf("cannot make safe key: safe len == %d, ec loss == %d", keyLen, recRes.bitsLeaked)
+		err = fmt.Errorf("%w: safe len == %d, ec loss == %d", ErrKeyTooShort, keyLen, recRes.bitsLeaked)
 		return
 	}
 	keyLen -= recRes.bitsLeaked
@@ -133,83 +220,189 @@ func (b *bob) NegotiateKey() (key bitmap.Dense, stats Stats, err error) {
 	if keyLen > recRes.xHat.Size() {
 		keyLen = recRes.xHat.Size()
 	}
+	stats.Estimates.KeyLen = keyLen
+	start = time.Now()
 	seed, err := b.ecFinished(recRes.xHat, &stats)
+	stats.Timings.Verification = time.Since(start)
 	if err != nil {
 		return
 	}
+	start = time.Now()
 	key, err = hash(seed, recRes.xHat, keyLen)
+	stats.Timings.Extraction = time.Since(start)
 	if err != nil {
 		return
 	}
 	return
 }
 
-func (a *alice) sendQBits() (bits, bases, lo, med, hi bitmap.Dense, err error) {
-	bitsArr, basesArr, loArr, medArr, hiArr, err := a.sender.Next(a.measBatchBytes)
+func (a *alice) sendQBits() (photon.SentBatch, error) {
+	batch, err := a.sender.NextBatch(a.measBatchBytes)
 	if err != nil {
-		err = fmt.Errorf("sending qubits: %w", err)
-		return
+		return batch, fmt.Errorf("sending qubits: %w", err)
 	}
-	bits = bitmap.NewDense(bitsArr, -1)
-	bases = bitmap.NewDense(basesArr, -1)
-	lo = bitmap.NewDense(loArr, -1)
-	med = bitmap.NewDense(medArr, -1)
-	hi = bitmap.NewDense(hiArr, -1)
-	return
+	return batch, nil
 }
 
-func (b *bob) receiveQBits() (bits, bases, dropped bitmap.Dense, err error) {
-	bitsArr, basesArr, droppedArr, err := b.receiver.Next(b.measBatchBytes)
+func (b *bob) receiveQBits() (photon.ReceivedBatch, error) {
+	batch, err := b.receiver.NextBatch(b.measBatchBytes)
 	if err != nil {
-		err = fmt.Errorf("receiving qubits: %w", err)
-		return
+		return batch, fmt.Errorf("receiving qubits: %w", err)
 	}
-	bits = bitmap.NewDense(bitsArr, -1)
-	bases = bitmap.NewDense(basesArr, -1)
-	dropped = bitmap.NewDense(droppedArr, -1)
-	return
+	return batch, nil
 }
 
-func (a *alice) sift(bits, bases, lo, med, hi bitmap.Dense, s *Stats) (main, test, errors measurements, err error) {
+func (a *alice) sift(batch photon.SentBatch, mon *intensityMonitor, s *Stats) (main, test siftedBasis, err error) {
+	bits, bases, intensities := batch.Bits, batch.Bases, batch.Intensities
+	monitored := batch.MonitoredIntensities
+	if a.pulseAttrs.singlePhoton() {
+		// Every pulse from a single-photon source is alike, so there are no
+		// intensities to rely on the Sender for, or to announce.
+		intensities, monitored = make([]uint8, bits.Size()), nil
+	}
 	bba := new(bb84pb.BasisAnnouncement)
 	if err = a.sideChannel.Read(bba, s); err != nil {
 		err = fmt.Errorf("receiving basis announcement: %w", err)
 		return
 	}
+	a.peerLimits = bba.AbortLimits
+	pulses := pulseRange(batch.Sequenced, batch.FirstPulse, bits.Size())
+	if err = checkPulseRanges(pulses, bba.Pulses); err != nil {
+		return
+	}
 	bBases := bitmap.DenseFromProto(bba.Bases)
 	bTest := bitmap.DenseFromProto(bba.TestBits)
-	received := bitmap.Not(bitmap.DenseFromProto(bba.Dropped))
+	bDropped := bitmap.DenseFromProto(bba.Dropped)
+	// Bob chooses which measurements to disclose, if only a sample.
+	sampled := bBases
+	if bba.Sampled != nil {
+		sampled = bitmap.DenseFromProto(bba.Sampled)
+	}
+	if (a.sampleProp > 0) != (bba.Sampled != nil) {
+		err = errors.New("Alice and Bob disagree on whether to sample measurements")
+		return
+	}
+	offset, spent := 0, bitmap.Empty()
+	if a.alignSearch > 0 {
+		if spent, err = alignmentSample(a.rand, bitmap.And(bBases, sampled)); err != nil {
+			return
+		}
+		if offset, err = align(bits, bases, bDropped, spent, bTest, a.alignSearch); err != nil {
+			return
+		}
+	}
+	var aligned *bb84pb.DenseBitArray
+	if a.alignSearch > 0 {
+		aligned = spent.ToProto()
+	}
+	if offset != 0 {
+		s.Realignments++
+		start, end := overlap(bits.Size(), offset)
+		if bBases, err = realignReceived(bBases, bDropped, start, end); err != nil {
+			return
+		}
+		if bTest, err = realignReceived(bTest, bDropped, start, end); err != nil {
+			return
+		}
+		if sampled, err = realignReceived(sampled, bDropped, start, end); err != nil {
+			return
+		}
+		if spent, err = realignReceived(spent, bDropped, start, end); err != nil {
+			return
+		}
+		if bDropped, err = bitmap.Slice(bDropped, start, end); err != nil {
+			return
+		}
+		for _, d := range []*bitmap.Dense{&bits, &bases} {
+			if *d, err = bitmap.Slice(*d, start+offset, end+offset); err != nil {
+				return
+			}
+		}
+		intensities = intensities[start+offset : end+offset]
+	}
+	received := bitmap.Not(bDropped)
 	bits = bitmap.Select(bits, received)
 	bases = bitmap.Select(bases, received)
-	lo = bitmap.Select(lo, received)
-	med = bitmap.Select(med, received)
-	hi = bitmap.Select(hi, received)
-	z := bitmap.And(bits, bases)
+	var receivedIntensities []uint8
+	for i, level := range intensities {
+		if received.Get(i) {
+			receivedIntensities = append(receivedIntensities, level)
+		}
+	}
+	levels, err := intensityMasks(receivedIntensities, len(a.pulseAttrs.levels()))
+	if err != nil {
+		return
+	}
+	if a.sampleProp == 0 {
+		sampled = bases
+	}
+	z := bitmap.And(bits, sampled)
+	if err = mon.add(monitored); err != nil {
+		return
+	}
+	var packed *bb84pb.DenseBitArray
+	if !a.pulseAttrs.singlePhoton() {
+		p := packIntensities(receivedIntensities, len(a.pulseAttrs.levels()))
+		packed = p.ToProto()
+	}
 	aba := &bb84pb.BasisAnnouncement{
-		Bases:    bases.ToProto(),
-		TestBits: z.ToProto(),
-		Lo:       lo.ToProto(),
-		Med:      med.ToProto(),
-		Hi:       hi.ToProto(),
+		Bases:                bases.ToProto(),
+		TestBits:             z.ToProto(),
+		Intensities:          packed,
+		MonitoredIntensities: intensityRangesToProto(monitored),
+		Pulses:               pulses,
+		Offset:               int32(offset),
+		Aligned:              aligned,
+		AbortLimits:          a.limits.set(),
 	}
 	if err = a.sideChannel.Write(aba, s); err != nil {
 		err = fmt.Errorf("announcing bases: %w", err)
 		return
 	}
-	main, test, errors = sift(bits, bTest, bases, bBases, lo, med, hi)
+	main, test = sift(bits, bTest, bases, bBases, sampled, spent, levels)
 	return
 }
 
-func (b *bob) sift(bits, bases, dropped bitmap.Dense,
-	s *Stats) (main, test, errors measurements, err error) {
+// recordDetectorEvents tallies any detector-level events reported for batch,
+// and returns a bitmask of which of its pulses were double clicks.
+func recordDetectorEvents(batch photon.ReceivedBatch, s *Stats) bitmap.Dense {
+	n := batch.Bits.Size()
+	if batch.Detector == nil {
+		return bitmap.NewDense(nil, n)
+	}
+	events := *batch.Detector
+	doubles := bitmap.NewDense(events.DoubleClicks, n)
+	s.DoubleClicks += bitmap.CountOnes(doubles)
+	s.DarkCounts += events.DarkCounts
+	s.Afterpulses += events.Afterpulses
+	s.DeadTimeLosses += events.DeadTimeLosses
+	return doubles
+}
+
+func (b *bob) sift(batch photon.ReceivedBatch, doubles bitmap.Dense,
+	mon *intensityMonitor, s *Stats) (main, test siftedBasis, err error) {
+	bits, bases, dropped := batch.Bits, batch.Bases, batch.Dropped
 	received := bitmap.Not(dropped)
 	bits = bitmap.Select(bits, received)
 	bases = bitmap.Select(bases, received)
-	z := bitmap.And(bits, bases)
+	doubles = bitmap.Select(doubles, received)
+	sampled := bases
+	if b.sampleProp > 0 {
+		if sampled, err = sampleMask(b.rand, bits.Size(), b.sampleProp); err != nil {
+			return
+		}
+	}
+	z := bitmap.And(bits, sampled)
+	pulses := pulseRange(batch.Sequenced, batch.FirstPulse, dropped.Size())
 	bba := &bb84pb.BasisAnnouncement{
-		Bases:    bases.ToProto(),
-		Dropped:  dropped.ToProto(),
-		TestBits: z.ToProto(),
+		Bases:       bases.ToProto(),
+		Dropped:     dropped.ToProto(),
+		TestBits:    z.ToProto(),
+		Pulses:      pulses,
+		AbortLimits: b.limits.set(),
+	}
+	if b.sampleProp > 0 {
+		bba.Sampled = sampled.ToProto()
 	}
 	if err = b.sideChannel.Write(bba, s); err != nil {
 		err = fmt.Errorf("sending basis announcement: %w", err)
@@ -220,31 +413,142 @@ func (b *bob) sift(bits, bases, dropped bitmap.Dense,
 		err = fmt.Errorf("receiving basis announcement: %w", err)
 		return
 	}
+	b.peerLimits = aba.AbortLimits
+	if err = checkPulseRanges(pulses, aba.Pulses); err != nil {
+		return
+	}
+	spent := bitmap.Empty()
+	if aba.Aligned != nil {
+		if spent = bitmap.DenseFromProto(aba.Aligned); spent.Size() != bits.Size() {
+			err = fmt.Errorf("got alignment sample of %d measurements, want %d", spent.Size(), bits.Size())
+			return
+		}
+	}
+	if offset := int(aba.Offset); offset != 0 {
+		s.Realignments++
+		start, end := overlap(dropped.Size(), offset)
+		for _, d := range []*bitmap.Dense{&bits, &bases, &doubles, &sampled, &spent} {
+			if *d, err = realignReceived(*d, dropped, start, end); err != nil {
+				return
+			}
+		}
+	}
 	aBasis := bitmap.DenseFromProto(aba.Bases)
 	aTest := bitmap.DenseFromProto(aba.TestBits)
-	lo := bitmap.DenseFromProto(aba.Lo)
-	med := bitmap.DenseFromProto(aba.Med)
-	hi := bitmap.DenseFromProto(aba.Hi)
-	main, test, errors = sift(bits, aTest, bases, aBasis, lo, med, hi)
-	return main, test, errors, nil
+	s.SiftedDoubleClicks += bitmap.CountOnes(bitmap.And(doubles, bitmap.XNor(bases, aBasis)))
+	var packed bitmap.Dense
+	if aba.Intensities != nil {
+		packed = bitmap.DenseFromProto(aba.Intensities)
+	}
+	// A single-photon source's only level takes no bits to announce.
+	nLevels := len(b.pulseAttrs.levels())
+	intensities, err := unpackIntensities(packed, aBasis.Size(), nLevels)
+	if err != nil {
+		return
+	}
+	levels, err := intensityMasks(intensities, nLevels)
+	if err != nil {
+		return
+	}
+	if err = mon.add(intensityRangesFromProto(aba.MonitoredIntensities)); err != nil {
+		return
+	}
+	main, test = sift(bits, aTest, bases, aBasis, sampled, spent, levels)
+	return main, test, nil
+}
+
+// An abortLimits holds the thresholds beyond which a peer aborts a round after
+// parameter estimation. Zero disables a threshold.
+type abortLimits struct {
+	qber, phaseError float64
+}
+
+// set reports whether any limits are set, so that the round may be aborted.
+func (l abortLimits) set() bool {
+	return l.qber > 0 || l.phaseError > 0
+}
+
+// check returns why a round with the estimates in s should be aborted, or the
+// empty string if it should not.
+func (l abortLimits) check(s *Stats) string {
+	if l.qber > 0 && !(s.QBER <= l.qber) {
+		return fmt.Sprintf("QBER %f exceeds %f", s.QBER, l.qber)
+	}
+	if pe := s.Estimates.PhaseError; l.phaseError > 0 && !(pe <= l.phaseError) {
+		return fmt.Sprintf("phase error rate bound %f exceeds %f", pe, l.phaseError)
+	}
+	return ""
+}
+
+// estimationFinished announces whether Alice is aborting the round, and learns
+// whether Bob is, returning an error wrapping ErrAborted if either is. If
+// neither has set any limits, there is nothing to announce.
+func (a *alice) estimationFinished(s *Stats) error {
+	if !a.limits.set() && !a.peerLimits {
+		return nil
+	}
+	reason := a.limits.check(s)
+	if err := a.sideChannel.Write(&bb84pb.EstimationFinished{AbortReason: reason}, s); err != nil {
+		return fmt.Errorf("sending estimation finished: %w", err)
+	}
+	if reason != "" {
+		return fmt.Errorf("%w: %s", ErrAborted, reason)
+	}
+	m := &bb84pb.EstimationFinished{}
+	if err := a.sideChannel.Read(m, s); err != nil {
+		return fmt.Errorf("receiving estimation finished: %w", err)
+	}
+	if m.AbortReason != "" {
+		return fmt.Errorf("%w by peer: %s", ErrAborted, m.AbortReason)
+	}
+	return nil
+}
+
+// estimationFinished learns whether Alice is aborting the round, and if not
+// announces whether Bob is, returning an error wrapping ErrAborted if either
+// is. If neither has set any limits, there is nothing to announce.
+func (b *bob) estimationFinished(s *Stats) error {
+	if !b.limits.set() && !b.peerLimits {
+		return nil
+	}
+	m := &bb84pb.EstimationFinished{}
+	if err := b.sideChannel.Read(m, s); err != nil {
+		return fmt.Errorf("receiving estimation finished: %w", err)
+	}
+	if m.AbortReason != "" {
+		return fmt.Errorf("%w by peer: %s", ErrAborted, m.AbortReason)
+	}
+	reason := b.limits.check(s)
+	if err := b.sideChannel.Write(&bb84pb.EstimationFinished{AbortReason: reason}, s); err != nil {
+		return fmt.Errorf("sending estimation finished: %w", err)
+	}
+	if reason != "" {
+		return fmt.Errorf("%w: %s", ErrAborted, reason)
+	}
+	return nil
 }
 
 func (a *alice) ecFinished(k bitmap.Dense, targetLen int, s *Stats) (bitmap.Dense, error) {
-	verLen := int(math.Ceil(math.Log2(1 / a.epsCorrect)))
+	verLen := int(math.Ceil(math.Log2(1 / s.Epsilons.Correct)))
 	needed := k.Size() + verLen - 1
 	verSeed := make([]byte, bitmap.BytesFor(needed))
-	a.rand.Read(verSeed)
+	if _, err := a.rand.Read(verSeed); err != nil {
+		return bitmap.Empty(), fmt.Errorf("drawing verification seed: %w", err)
+	}
 	ver, err := hash(bitmap.NewDense(verSeed, -1), k, verLen)
 	if err != nil {
 		return bitmap.Empty(), err
 	}
 	needed = k.Size() + targetLen - 1
 	extractSeed := make([]byte, bitmap.BytesFor(needed))
-	a.rand.Read(extractSeed)
+	if _, err := a.rand.Read(extractSeed); err != nil {
+		return bitmap.Empty(), fmt.Errorf("drawing extraction seed: %w", err)
+	}
 	err = a.sideChannel.Write(&bb84pb.ErrorCorrectionFinished{
 		ExtractSeed: extractSeed,
 		VerifySeed:  verSeed,
 		VerifyHash:  ver.ToProto(),
+		Estimates:   s.Estimates.toProto(),
 	}, s)
 	if err != nil {
 		return bitmap.Empty(), err
@@ -253,8 +557,9 @@ func (a *alice) ecFinished(k bitmap.Dense, targetLen int, s *Stats) (bitmap.Dens
 	if err := a.sideChannel.Read(m, s); err != nil {
 		return bitmap.Empty(), err
 	}
+	s.PeerEstimates = estimatesFromProto(m.Estimates)
 	if !bitmap.Equal(ver, bitmap.DenseFromProto(m.VerifyHash)) {
-		return bitmap.Empty(), errors.New("error correction failed verification")
+		return bitmap.Empty(), ErrVerificationFailed
 	}
 	return bitmap.NewDense(extractSeed, -1), nil
 }
@@ -264,6 +569,7 @@ func (b *bob) ecFinished(k bitmap.Dense, s *Stats) (bitmap.Dense, error) {
 	if err := b.sideChannel.Read(m, s); err != nil {
 		return bitmap.Empty(), fmt.Errorf("receiving ec finished: %w", err)
 	}
+	s.PeerEstimates = estimatesFromProto(m.Estimates)
 	aVerHash := bitmap.DenseFromProto(m.VerifyHash)
 	ver, err := hash(bitmap.NewDense(m.VerifySeed, -1), k, aVerHash.Size())
 	if err != nil {
@@ -271,42 +577,117 @@ func (b *bob) ecFinished(k bitmap.Dense, s *Stats) (bitmap.Dense, error) {
 	}
 	err = b.sideChannel.Write(&bb84pb.ErrorCorrectionFinished{
 		VerifyHash: ver.ToProto(),
+		Estimates:  s.Estimates.toProto(),
 	}, s)
 	if err != nil {
 		return bitmap.Empty(), fmt.Errorf("sending ec finished message: %w", err)
 	}
 	if !bitmap.Equal(ver, aVerHash) {
-		return bitmap.Empty(), errors.New("error correction failed verification")
+		return bitmap.Empty(), ErrVerificationFailed
 	}
 	return bitmap.NewDense(m.ExtractSeed, -1), nil
 }
 
-func sift(bits, otherTest, basis, otherBasis, lo, med, hi bitmap.Dense) (main, test, errors measurements) {
-	mainMask := bitmap.And(bitmap.Not(basis), bitmap.Not(otherBasis))
-	testMask := bitmap.And(basis, otherBasis)
-	main = measurements{
-		all: bitmap.Select(bits, mainMask),
-		lo:  bitmap.Select(bits, bitmap.And(mainMask, lo)),
-		med: bitmap.Select(bits, bitmap.And(mainMask, med)),
-		hi:  bitmap.Select(bits, bitmap.And(mainMask, hi)),
-	}
-	test = measurements{
-		all: bitmap.Select(bits, testMask),
-		lo:  bitmap.Select(bits, bitmap.And(testMask, lo)),
-		med: bitmap.Select(bits, bitmap.And(testMask, med)),
-		hi:  bitmap.Select(bits, bitmap.And(testMask, hi)),
+// announcementBytes bounds the size of either peer's basis announcement for a
+// batch of batchBytes, with nLevels intensity levels: up to four bitmaps of the
+// batch's pulses, e.g. Alice's bases, test bits, sample and alignment, and her
+// packed intensities, each with a few bytes of framing, plus the monitored
+// range of each level.
+func announcementBytes(batchBytes, nLevels int) int {
+	return (4+intensityBits(nLevels))*(batchBytes+4) + 24*nLevels
+}
+
+// intensityBits returns the number of bits each pulse's intensity level is
+// announced in, given nLevels levels.
+func intensityBits(nLevels int) int {
+	return bits.Len(uint(nLevels - 1))
+}
+
+// packIntensities packs per-pulse intensity levels into intensityBits bits
+// each, least significant first.
+func packIntensities(levels []uint8, nLevels int) bitmap.Dense {
+	w := intensityBits(nLevels)
+	packed := bitmap.Empty()
+	for _, level := range levels {
+		for k := 0; k < w; k++ {
+			packed.AppendBit(level>>k&1 == 1)
+		}
 	}
-	other := measurements{
-		lo:  bitmap.Select(otherTest, bitmap.And(testMask, lo)),
-		med: bitmap.Select(otherTest, bitmap.And(testMask, med)),
-		hi:  bitmap.Select(otherTest, bitmap.And(testMask, hi)),
+	return packed
+}
+
+// unpackIntensities reverses packIntensities for n pulses.
+func unpackIntensities(packed bitmap.Dense, n, nLevels int) ([]uint8, error) {
+	w := intensityBits(nLevels)
+	if packed.Size() != n*w {
+		return nil, fmt.Errorf("got %d bits of intensities for %d pulses, want %d", packed.Size(), n, n*w)
+	}
+	levels := make([]uint8, n)
+	for i := range levels {
+		for k := 0; k < w; k++ {
+			if packed.Get(i*w + k) {
+				levels[i] |= 1 << k
+			}
+		}
 	}
-	errors = measurements{
-		lo:  bitmap.XOr(test.lo, other.lo),
-		med: bitmap.XOr(test.med, other.med),
-		hi:  bitmap.XOr(test.hi, other.hi),
+	return levels, nil
+}
+
+// intensityMasks converts per-pulse intensity levels into a bitmask per level,
+// of the pulses sent at that intensity.
+func intensityMasks(levels []uint8, nLevels int) ([]bitmap.Dense, error) {
+	masks := make([]bitmap.Dense, nLevels)
+	for i, level := range levels {
+		if int(level) >= nLevels {
+			return nil, fmt.Errorf("pulse %d has intensity level %d, but only %d levels are configured",
+				i, level, nLevels)
+		}
+		for l := range masks {
+			masks[l].AppendBit(int(level) == l)
+		}
 	}
-	return main, test, errors
+	return masks, nil
+}
+
+// sift splits the measurements in which both parties chose the same basis by
+// that basis. Those in sampled were disclosed, with the other party's values in
+// otherSample. Those in spent were disclosed to align the batch, and are
+// discarded.
+func sift(bits, otherSample, basis, otherBasis, sampled, spent bitmap.Dense, levels []bitmap.Dense) (main, test siftedBasis) {
+	unspent := bitmap.Not(spent)
+	mainMask := bitmap.And(bitmap.And(bitmap.Not(basis), bitmap.Not(otherBasis)), unspent)
+	testMask := bitmap.And(bitmap.And(basis, otherBasis), unspent)
+	return siftBasis(bits, otherSample, mainMask, sampled, levels), siftBasis(bits, otherSample, testMask, sampled, levels)
+}
+
+// siftBasis collects the measurements selected by mask.
+func siftBasis(bits, otherSample, mask, sampled bitmap.Dense, levels []bitmap.Dense) (s siftedBasis) {
+	keyMask := bitmap.And(mask, bitmap.Not(sampled))
+	sampleMask := bitmap.And(mask, sampled)
+	s.key.all = bitmap.Select(bits, keyMask)
+	s.sample.all = bitmap.Select(bits, sampleMask)
+	for _, l := range levels {
+		lSample := bitmap.And(sampleMask, l)
+		s.key.byLevel = append(s.key.byLevel, bitmap.Select(bits, bitmap.And(keyMask, l)))
+		s.sample.byLevel = append(s.sample.byLevel, bitmap.Select(bits, lSample))
+		s.errors.byLevel = append(s.errors.byLevel, bitmap.XOr(bitmap.Select(bits, lSample), bitmap.Select(otherSample, lSample)))
+	}
+	return s
+}
+
+// sampleMask returns a mask of n bits, each set independently with probability
+// p, to a resolution of 2^-16.
+func sampleMask(src entropy.Source, n int, p float64) (bitmap.Dense, error) {
+	buf := make([]byte, 2*n)
+	if _, err := src.Read(buf); err != nil {
+		return bitmap.Empty(), fmt.Errorf("drawing sample: %w", err)
+	}
+	threshold := int(math.Round(p * (1 << 16)))
+	var mask bitmap.Dense
+	for i := 0; i < n; i++ {
+		mask.AppendBit(int(binary.LittleEndian.Uint16(buf[2*i:])) < threshold)
+	}
+	return mask, nil
 }
 
 func hash(seed bitmap.Dense, x bitmap.Dense, outlen int) (bitmap.Dense, error) {
@@ -319,89 +700,169 @@ func hash(seed bitmap.Dense, x bitmap.Dense, outlen int) (bitmap.Dense, error) {
 }
 
 // Computes $l + \lambda_{EC}$, as per
-// https://journals.aps.org/pra/abstract/10.1103/PhysRevA.89.022307
-func calcSafeKeyLen(main, test, errors measurements,
-	pulseAttrs PulseAttrs,
-	epsPriv, epsCorrect float64,
+// https://journals.aps.org/pra/abstract/10.1103/PhysRevA.89.022307, spending
+// failure probabilities according to budget.
+func calcSafeKeyLen(main, test siftedBasis,
+	levels []decoyLevel,
+	cb ConcentrationBound,
+	budget epsilonBudget,
 	stats *Stats) int {
-	sX0 := estimateVacuumCount(main, pulseAttrs, epsPriv)
-	sX1 := estimateSinglePhotonCount(main, pulseAttrs, epsPriv, sX0)
-	phiX, mZ := estimatePhaseErrorRate(main, test, errors, pulseAttrs, epsPriv, sX1)
-	l := sX0 + sX1 - sX1*binaryEntropy(phiX) - 6*math.Log2(21/epsPriv) - math.Log2(2/epsCorrect)
-	stats.QBER = float64(mZ) / float64(test.all.Size())
-	return int(math.Floor(l))
-}
-
-func estimatePhaseErrorRate(main, test, errors measurements,
-	pa PulseAttrs, eps, sX1 float64) (phi float64, mZ int) {
-	mu2, mu3 := pa.MuMed, pa.MuLo
-	p2, p3 := pa.ProbMed, pa.ProbLo
-	sZ0 := estimateVacuumCount(test, pa, eps)
-	sZ1 := estimateSinglePhotonCount(test, pa, eps, sZ0)
-	mZ1 := bitmap.CountOnes(errors.hi)
-	mZ2 := bitmap.CountOnes(errors.med)
-	mZ3 := bitmap.CountOnes(errors.lo)
-	mZ = mZ1 + mZ2 + mZ3
-	mZ2Plus := hoeffding(mu2, p2, eps, float64(mZ), float64(mZ2), 1)
-	mZ3Minus := hoeffding(mu3, p3, eps, float64(mZ), float64(mZ3), -1)
-	tau1 := probNPhotons(pa, 1)
-	nuZ1 := tau1 * (mZ2Plus - mZ3Minus) / (mu2 - mu3)
-	return nuZ1/sZ1 + gamma(eps, nuZ1/sZ1, sZ1, sX1), mZ
-}
-
-func estimateVacuumCount(meas measurements, pa PulseAttrs, eps float64) float64 {
-	mu2, mu3 := pa.MuMed, pa.MuLo
-	p2, p3 := pa.ProbMed, pa.ProbLo
-	tau0 := probNPhotons(pa, 0)
-	nX1 := meas.hi.Size()
-	nX2 := meas.med.Size()
-	nX3 := meas.lo.Size()
-	nX := float64(nX1 + nX2 + nX3)
-	nX3Minus := hoeffding(mu3, p3, eps, nX, float64(nX3), -1)
-	nX2Plus := hoeffding(mu2, p2, eps, nX, float64(nX2), 1)
-	ret := tau0 * (mu2*nX3Minus - mu3*nX2Plus) / (mu2 - mu3)
-	// The preceding calculation gives a lower bound on the number of detection
-	// events we had in response to 0-photon pulses, and negative values are
-	// technically valid. However, 0 is also a valid bound, and in such cases,
-	// it's tighter.
-	if ret < 0 {
-		return 0
+	blocks := tallyBlocks(main, test)
+	ests, eps := estimate(blocks, levels, cb, budget)
+	stats.Estimates = ests[0]
+	if len(ests) > 1 {
+		stats.TestEstimates = ests[1]
+	}
+	stats.Epsilons = eps
+	stats.QBER = sampleQBER(blocks)
+	return ests[0].SafeKeyLen
+}
+
+// estimate computes the estimates supported by each block of key, spending
+// failure probabilities according to budget. The first block's SafeKeyLen is
+// that of the whole key.
+func estimate(blocks []tally, levels []decoyLevel, cb ConcentrationBound, budget epsilonBudget) ([]Estimates, Epsilons) {
+	eps := budget.split()
+	ests, l := estimateBlocks(blocks, levels, cb, eps)
+	ests[0].SafeKeyLen = int(math.Floor(l))
+	return ests, eps
+}
+
+// estimateBlocks estimates the parameters of each block of key, returning them
+// along with the unrounded length of the whole key they support. The blocks
+// share parameter estimation and privacy amplification's failure probabilities
+// equally, each paying for its own smoothing and hashing terms, which can only
+// overcount. Where there are several, a block which supports no key contributes
+// none, rather than detracting from the others, since appending its bits to
+// theirs cannot decrease their smooth min-entropy. Asymptotic key lengths pay
+// for no failure probabilities.
+func estimateBlocks(blocks []tally, levels []decoyLevel, cb ConcentrationBound, eps Epsilons) (ests []Estimates, l float64) {
+	share := eps
+	share.Estimation /= float64(len(blocks))
+	share.Amplification /= float64(len(blocks))
+	if !isAsymptotic(cb) {
+		l = -math.Log2(2 / eps.Correct)
+	}
+	for _, t := range blocks {
+		est, bl := estimateKeyLen(t, levels, cb, share)
+		if len(levels) > 1 || isAsymptotic(cb) {
+			est.Bound = cb.Name()
+		}
+		est.SafeKeyLen = int(math.Floor(bl))
+		ests = append(ests, est)
+		if len(blocks) > 1 && !(bl > 0) {
+			continue
+		}
+		l += bl
 	}
-	return ret
+	return ests, l
 }
 
-func estimateSinglePhotonCount(meas measurements, pa PulseAttrs, eps, s0 float64) float64 {
-	mu1, mu2, mu3 := pa.MuHi, pa.MuMed, pa.MuLo
-	p1, p2, p3 := pa.ProbHi, pa.ProbMed, pa.ProbLo
-	tau0 := probNPhotons(pa, 0)
-	tau1 := probNPhotons(pa, 1)
-	n1, n2, n3 := meas.hi.Size(), meas.med.Size(), meas.lo.Size()
-	n := n1 + n2 + n3
-	n2Minus := hoeffding(mu2, p2, eps, float64(n), float64(n2), -1)
-	n3Plus := hoeffding(mu3, p3, eps, float64(n), float64(n3), 1)
-	n1Plus := hoeffding(mu1, p1, eps, float64(n), float64(n1), 1)
-	num := tau1 * mu1 * (n2Minus - n3Plus - (mu2*mu2-mu3*mu3)*(n1Plus-s0/tau0)/mu1/mu1)
-	denom := mu1*(mu2-mu3) - mu2*mu2 + mu3*mu3
-	return num / denom
+// estimateKeyLen estimates the parameters of a tally of measurements,
+// returning them along with the unrounded length of key they support, before
+// paying for verifying error correction.
+func estimateKeyLen(t tally, levels []decoyLevel, cb ConcentrationBound, eps Epsilons) (est Estimates, l float64) {
+	if len(levels) == 1 {
+		return estimateSinglePhotonKeyLen(t, cb, eps)
+	}
+	epsPE := eps.Estimation
+	est.VacuumX = estimateVacuumCount(t.main, levels, cb, epsPE)
+	est.SinglePhotonX = estimateSinglePhotonCount(t.main, levels, cb, epsPE)
+	est.VacuumZ = estimateVacuumCount(t.test, levels, cb, epsPE)
+	est.SinglePhotonZ = estimateSinglePhotonCount(t.test, levels, cb, epsPE)
+	phiX := estimatePhaseErrorRate(t.errors, levels, cb, epsPE, est.SinglePhotonZ, est.SinglePhotonX)
+	est.PhaseError = phiX
+	sX0, sX1 := est.VacuumX, est.SinglePhotonX
+	// Binary entropy is symmetric about 1/2, but a phase error rate bound
+	// beyond it means Eve may know everything, not that she knows less.
+	if phiX > 0.5 {
+		phiX = 0.5
+	}
+	l = sX0 + sX1 - sX1*binaryEntropy(phiX)
+	if !isAsymptotic(cb) {
+		// The leftover hash lemma's smoothing and hashing terms together cost
+		// six shares' worth of privacy amplification's budget.
+		l -= 6 * math.Log2(limShares/eps.Amplification)
+	}
+	return est, l
 }
 
-func probNPhotons(pa PulseAttrs, n float64) float64 {
-	hi := distuv.Poisson{Lambda: pa.MuHi}
-	med := distuv.Poisson{Lambda: pa.MuMed}
-	lo := distuv.Poisson{Lambda: pa.MuLo}
-	return pa.ProbHi*hi.Prob(n) + pa.ProbMed*med.Prob(n) + pa.ProbLo*lo.Prob(n)
+// estimateSinglePhotonKeyLen is estimateKeyLen for an ideal single-photon
+// source, whose every detection is a single-photon detection. The test basis is
+// then a random sample of the same states as the main basis, so its error rate
+// bounds the main basis's phase error rate, up to the sampling correction of
+// https://www.nature.com/articles/ncomms1631, which the asymptotic key length
+// does without.
+func estimateSinglePhotonKeyLen(t tally, cb ConcentrationBound, eps Epsilons) (est Estimates, l float64) {
+	nX, nZ, mZ := float64(t.main[0]), float64(t.test[0]), float64(t.errors[0])
+	est.SinglePhotonX, est.SinglePhotonZ = nX, nZ
+	asymptotic := isAsymptotic(cb)
+	phiX := mZ / nZ
+	if !asymptotic {
+		phiX += math.Sqrt((nX + nZ) / (nX * nZ) * (nZ + 1) / nZ * math.Log(limShares/eps.Estimation))
+	}
+	est.PhaseError = phiX
+	if phiX > 0.5 {
+		phiX = 0.5
+	}
+	l = nX - nX*binaryEntropy(phiX)
+	if !asymptotic {
+		l -= 6 * math.Log2(limShares/eps.Amplification)
+	}
+	return est, l
 }
 
 func binaryEntropy(x float64) float64 {
+	if x <= 0 || x >= 1 {
+		return 0
+	}
 	return -x*math.Log2(x) - (1-x)*math.Log2(1-x)
 }
 
-func hoeffding(mu, p, eps, nX, nXk, sign float64) float64 {
-	return (math.Exp(mu) / p) * (nXk + sign*math.Sqrt(nX*math.Log(21/eps)/2))
-}
-
 func gamma(a, b, c, d float64) float64 {
 	term1 := (c + d) * (1 - b) * b / c / d / math.Log(2)
-	term2 := (21 / a) * (21 / a) * (c + d) / (c * d * (1 - b) * b)
+	term2 := (limShares / a) * (limShares / a) * (c + d) / (c * d * (1 - b) * b)
 	return math.Sqrt(term1 * math.Log2(term2))
 }
+
+func (e Estimates) toProto() *bb84pb.Estimates {
+	return &bb84pb.Estimates{
+		VacuumX:       e.VacuumX,
+		VacuumZ:       e.VacuumZ,
+		SinglePhotonX: e.SinglePhotonX,
+		SinglePhotonZ: e.SinglePhotonZ,
+		PhaseError:    e.PhaseError,
+		SafeKeyLen:    int64(e.SafeKeyLen),
+		KeyLen:        int64(e.KeyLen),
+		Bound:         e.Bound,
+	}
+}
+
+func intensityRangesToProto(rs []photon.IntensityRange) []*bb84pb.IntensityRange {
+	var pbs []*bb84pb.IntensityRange
+	for _, r := range rs {
+		pbs = append(pbs, &bb84pb.IntensityRange{Min: r.Min, Max: r.Max})
+	}
+	return pbs
+}
+
+func intensityRangesFromProto(pbs []*bb84pb.IntensityRange) []photon.IntensityRange {
+	var rs []photon.IntensityRange
+	for _, pb := range pbs {
+		rs = append(rs, photon.IntensityRange{Min: pb.Min, Max: pb.Max})
+	}
+	return rs
+}
+
+func estimatesFromProto(pb *bb84pb.Estimates) Estimates {
+	return Estimates{
+		VacuumX:       pb.GetVacuumX(),
+		VacuumZ:       pb.GetVacuumZ(),
+		SinglePhotonX: pb.GetSinglePhotonX(),
+		SinglePhotonZ: pb.GetSinglePhotonZ(),
+		PhaseError:    pb.GetPhaseError(),
+		SafeKeyLen:    int(pb.GetSafeKeyLen()),
+		KeyLen:        int(pb.GetKeyLen()),
+		Bound:         pb.GetBound(),
+	}
+}
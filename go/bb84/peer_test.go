@@ -2,11 +2,13 @@ package bb84
 
 import (
 	"bytes"
+	"errors"
 	"math/rand"
 	"net"
 	"testing"
 
 	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+	"github.com/alan-christopher/bb84/go/bb84/entropy"
 	"github.com/alan-christopher/bb84/go/bb84/photon"
 )
 
@@ -19,24 +21,158 @@ type negotiationResult struct {
 }
 
 func TestWinnowedNegotation(t *testing.T) {
-	l, r := net.Pipe()
 	pa := PulseAttrs{}
 	pa.MuLo, pa.MuMed, pa.MuHi = 0.05, 0.1, 0.3
 	pa.ProbLo, pa.ProbMed, pa.ProbHi = 0.4, 0.3, 0.3
-	sender, receiver := photon.NewSimulatedChannel(
-		0.5,                            // pMain
-		pa.MuLo,                        // muLo
-		pa.MuMed,                       // muMed
-		pa.MuHi,                        // muHi
-		pa.ProbLo,                      // pLo
-		pa.ProbMed,                     // pMed
-		pa.ProbHi,                      // pHi
-		rand.New(rand.NewSource(1234)), // sendrand
-		rand.New(rand.NewSource(5678)), // receiveRand
-	)
+	sender, receiver := photon.NewSimulatedChannel(photon.SimulatedChannelOpts{
+		PMain:       0.5,
+		MuLo:        pa.MuLo,
+		MuMed:       pa.MuMed,
+		MuHi:        pa.MuHi,
+		PLo:         pa.ProbLo,
+		PMed:        pa.ProbMed,
+		PHi:         pa.ProbHi,
+		SendSeed:    1234,
+		ReceiveSeed: 5678,
+	})
+	batchBits := DefaultMeasurementBatchBytes * 8
+	legitErrs := bitmap.NewDense(nil, batchBits)
+	for i := 0; i < batchBits/20; i++ {
+		legitErrs.Flip(i)
+	}
+	legitErrs.Shuffle(rand.New(rand.NewSource(99)))
+	receiver.Errors = legitErrs.Data()
+
+	var aSecret *bytes.Buffer
+	aRes, bRes := negotiate(t, sender, receiver, pa, func(o *PeerOpts) {
+		if o.Sender != nil {
+			aSecret = o.Secret.(*bytes.Buffer)
+		}
+	})
+	if aRes.err != nil {
+		t.Fatalf("Alice error: %v", aRes.err)
+	}
+	if bRes.err != nil {
+		t.Fatalf("Bob error: %v", bRes.err)
+	}
+	if !bytes.Equal(aRes.key.Data(), bRes.key.Data()) {
+		t.Errorf("Alice and Bob disagree on keys: (%v, %v)", aRes.key, bRes.key)
+	}
+	if aRes.key.Size() != bRes.key.Size() {
+		t.Errorf("Alice and Bob have different key lengths: %d != %d", aRes.key.Size(), bRes.key.Size())
+	}
+	if aRes.key.Size() == 0 {
+		t.Errorf("Alice arrived at an empty key")
+	}
+	if bRes.key.Size() == 0 {
+		t.Errorf("Bob arrived at an empty key")
+	}
+	if aRes.stats.Estimates != bRes.stats.PeerEstimates {
+		t.Errorf("Bob's view of Alice's estimates is wrong: got %+v, want %+v",
+			bRes.stats.PeerEstimates, aRes.stats.Estimates)
+	}
+	if bRes.stats.Estimates != aRes.stats.PeerEstimates {
+		t.Errorf("Alice's view of Bob's estimates is wrong: got %+v, want %+v",
+			aRes.stats.PeerEstimates, bRes.stats.Estimates)
+	}
+	if est := aRes.stats.Estimates; est.KeyLen != aRes.key.Size() || est.SafeKeyLen < est.KeyLen {
+		t.Errorf("Inconsistent key lengths: %+v, final key len %d", est, aRes.key.Size())
+	}
+	if consumed := 1<<23 - aSecret.Len(); aRes.stats.SecretBytes != consumed {
+		t.Errorf("Alice reports consuming %d bytes of secret, but consumed %d", aRes.stats.SecretBytes, consumed)
+	}
+}
+
+// TestNegotiationUnderAttack checks that parameter estimation charges every
+// eavesdropping strategy at least what it actually learned, either by
+// shrinking the key or refusing to produce one at all.
+func TestNegotiationUnderAttack(t *testing.T) {
+	pa := threeLevelAttrs()
+	run := func(eve photon.Eavesdropper) (negotiationResult, negotiationResult, photon.EveReport) {
+		chOpts := decoyChannelOpts(pa.levels())
+		chOpts.Eve = eve
+		sender, receiver := photon.NewSimulatedChannel(chOpts)
+		aRes, bRes := negotiate(t, sender, receiver, pa, nil)
+		return aRes, bRes, receiver.EveReport()
+	}
+	baseline, _, _ := run(nil)
+	if baseline.err != nil {
+		t.Fatalf("Negotiating without Eve: %v", baseline.err)
+	}
+
+	tcs := []struct {
+		name      string
+		eve       photon.Eavesdropper
+		wantAbort bool
+	}{
+		{
+			name: "partial intercept-resend",
+			eve:  photon.InterceptResend{Fraction: 0.05},
+		}, {
+			name:      "full intercept-resend",
+			eve:       photon.InterceptResend{Fraction: 1},
+			wantAbort: true,
+		}, {
+			name:      "photon number splitting",
+			eve:       photon.PhotonNumberSplitting{BlockFraction: 1},
+			wantAbort: true,
+		}, {
+			name: "beam splitting",
+			eve:  photon.BeamSplitting{Tap: 0.5},
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			aRes, bRes, report := run(tc.eve)
+			if report.Known == 0 {
+				t.Fatalf("Eve learned nothing, test is vacuous")
+			}
+			if tc.wantAbort {
+				// Reconciliation may well fail before the estimated key length
+				// is acted upon, but the estimate should forbid a key regardless.
+				res := aRes
+				if res.err == nil {
+					res = bRes
+				}
+				if res.err == nil {
+					t.Fatalf("got a key of %d bits, want an abort", aRes.key.Size())
+				}
+				if est := res.stats.Estimates; est.SafeKeyLen > 0 {
+					t.Errorf("got safe key len %d, want <= 0 (%+v)", est.SafeKeyLen, est)
+				}
+				return
+			}
+			if aRes.err != nil {
+				t.Fatalf("Alice error: %v", aRes.err)
+			}
+			if bRes.err != nil {
+				t.Fatalf("Bob error: %v", bRes.err)
+			}
+			if got, base := aRes.key.Size(), baseline.key.Size(); got >= base {
+				t.Errorf("got key of %d bits under attack, want fewer than the %d without", got, base)
+			}
+			if secret := report.RawKey - report.RawKeyKnown; aRes.key.Size() > secret {
+				t.Errorf("got key of %d bits, but only %d bits of the raw key are unknown to Eve",
+					aRes.key.Size(), secret)
+			}
+		})
+	}
+}
+
+// negotiate runs a key negotiation between an Alice and Bob communicating over
+// the given quantum channel, and an in-memory classical channel. If non-nil,
+// tweak is applied to both peers' options before they are built. Both peers'
+// results are returned, though once one fails the other will likely fail for
+// want of a classical channel.
+func negotiate(t *testing.T, sender photon.Sender, receiver photon.Receiver,
+	pa PulseAttrs, tweak func(*PeerOpts)) (aRes, bRes negotiationResult) {
+	t.Helper()
+	l, r := net.Pipe()
 	otp := make([]byte, 1<<23)
 	rand.Read(otp)
-	a, err := NewPeer(PeerOpts{
+	aOpts := PeerOpts{
 		Sender:           sender,
 		ClassicalChannel: l,
 		Rand:             rand.New(rand.NewSource(42)),
@@ -46,11 +182,8 @@ func TestWinnowedNegotation(t *testing.T) {
 			SyncRand: rand.New(rand.NewSource(17)),
 		},
 		PulseAttrs: pa,
-	})
-	if err != nil {
-		t.Fatalf("Building Alice: %v", err)
 	}
-	b, err := NewPeer(PeerOpts{
+	bOpts := PeerOpts{
 		Receiver:         receiver,
 		ClassicalChannel: r,
 		Rand:             rand.New(rand.NewSource(1337)),
@@ -60,17 +193,19 @@ func TestWinnowedNegotation(t *testing.T) {
 			SyncRand: rand.New(rand.NewSource(17)),
 		},
 		PulseAttrs: pa,
-	})
+	}
+	if tweak != nil {
+		tweak(&aOpts)
+		tweak(&bOpts)
+	}
+	a, err := NewPeer(aOpts)
 	if err != nil {
-		t.Fatalf("Building Bob: %v", err)
+		t.Fatalf("Building Alice: %v", err)
 	}
-	batchBits := DefaultMeasurementBatchBytes * 8
-	legitErrs := bitmap.NewDense(nil, batchBits)
-	for i := 0; i < batchBits/20; i++ {
-		legitErrs.Flip(i)
+	b, err := NewPeer(bOpts)
+	if err != nil {
+		t.Fatalf("Building Bob: %v", err)
 	}
-	legitErrs.Shuffle(rand.New(rand.NewSource(99)))
-	receiver.Errors = legitErrs.Data()
 
 	aResCh := make(chan negotiationResult, 1)
 	bResCh := make(chan negotiationResult, 1)
@@ -83,36 +218,479 @@ func TestWinnowedNegotation(t *testing.T) {
 		bResCh <- negotiationResult{k, s, err}
 	}()
 
-	var aRes, bRes negotiationResult
+	// Once either peer fails, close the classical channel so that the other
+	// can't block on it forever.
 	select {
-	case res := <-aResCh:
-		aRes = res
-		if aRes.err == nil {
-			bRes = <-bResCh
+	case aRes = <-aResCh:
+		if aRes.err != nil {
+			l.Close()
+			r.Close()
 		}
-	case res := <-bResCh:
-		bRes = res
-		if bRes.err == nil {
-			aRes = <-aResCh
+		bRes = <-bResCh
+	case bRes = <-bResCh:
+		if bRes.err != nil {
+			l.Close()
+			r.Close()
 		}
+		aRes = <-aResCh
 	}
+	return aRes, bRes
+}
 
+// decoyChannelOpts configures a simulated quantum channel preparing pulses at
+// the given intensity levels, over a lossless link with a little
+// misalignment. Callers may add an eavesdropper, who is seeded already, or a
+// slip.
+func decoyChannelOpts(levels []Intensity) photon.SimulatedChannelOpts {
+	opts := photon.SimulatedChannelOpts{
+		PMain:       0.5,
+		SendSeed:    1234,
+		ReceiveSeed: 5678,
+		Link:        &photon.FiberLink{DetectorEfficiency: 1, Misalignment: 0.02},
+		EveSeed:     4321,
+	}
+	for _, l := range levels {
+		opts.Intensities = append(opts.Intensities, l.Mu)
+		opts.IntensityProbs = append(opts.IntensityProbs, l.Prob)
+	}
+	return opts
+}
+
+// threeLevelAttrs returns the three-level decoy setup shared by many
+// negotiation tests, described by MuLo, MuMed and MuHi.
+func threeLevelAttrs() PulseAttrs {
+	return PulseAttrs{MuLo: 0.05, MuMed: 0.1, MuHi: 0.3, ProbLo: 0.4, ProbMed: 0.3, ProbHi: 0.3}
+}
+
+// checkKeysAgree fails the test unless both peers negotiated the same,
+// non-empty key.
+func checkKeysAgree(t *testing.T, aRes, bRes negotiationResult) {
+	t.Helper()
 	if aRes.err != nil {
 		t.Fatalf("Alice error: %v", aRes.err)
 	}
 	if bRes.err != nil {
 		t.Fatalf("Bob error: %v", bRes.err)
 	}
-	if !bytes.Equal(aRes.key.Data(), bRes.key.Data()) {
+	if !bytes.Equal(aRes.key.Data(), bRes.key.Data()) || aRes.key.Size() == 0 {
 		t.Errorf("Alice and Bob disagree on keys: (%v, %v)", aRes.key, bRes.key)
 	}
-	if aRes.key.Size() != bRes.key.Size() {
-		t.Errorf("Alice and Bob have different key lengths: %d != %d", aRes.key.Size(), bRes.key.Size())
+}
+
+// skewedSender reports sequence numbers offset from those of the underlying
+// SimulatedSender.
+type skewedSender struct {
+	*photon.SimulatedSender
+}
+
+func (s skewedSender) NextBatch(bytes int) (photon.SentBatch, error) {
+	b, err := s.SimulatedSender.NextBatch(bytes)
+	b.FirstPulse += 8
+	return b, err
+}
+
+func TestNegotiationDesync(t *testing.T) {
+	pa := threeLevelAttrs()
+	chOpts := decoyChannelOpts(pa.levels())
+	chOpts.Slip, chOpts.SlipAfter = -5, 2
+
+	t.Run("realigned", func(t *testing.T) {
+		sender, receiver := photon.NewSimulatedChannel(chOpts)
+		aRes, bRes := negotiate(t, sender, receiver, pa, func(o *PeerOpts) {
+			o.AlignmentSearch = 8
+		})
+		checkKeysAgree(t, aRes, bRes)
+		batches := aRes.stats.Pulses / (DefaultMeasurementBatchBytes * 8)
+		if got, want := aRes.stats.Realignments, batches-chOpts.SlipAfter; got != want {
+			t.Errorf("Alice realigned %d batches, want %d", got, want)
+		}
+		if aRes.stats.Realignments != bRes.stats.Realignments {
+			t.Errorf("Alice realigned %d batches, but Bob %d", aRes.stats.Realignments, bRes.stats.Realignments)
+		}
+	})
+
+	t.Run("slip out of range", func(t *testing.T) {
+		opts := chOpts
+		opts.Slip = 100
+		sender, receiver := photon.NewSimulatedChannel(opts)
+		aRes, _ := negotiate(t, sender, receiver, pa, func(o *PeerOpts) {
+			o.AlignmentSearch = 8
+		})
+		if !errors.Is(aRes.err, ErrDesync) {
+			t.Errorf("got Alice error %v, want %v", aRes.err, ErrDesync)
+		}
+	})
+
+	t.Run("sequence mismatch", func(t *testing.T) {
+		opts := chOpts
+		opts.Slip = 0
+		sender, receiver := photon.NewSimulatedChannel(opts)
+		aRes, _ := negotiate(t, skewedSender{sender}, receiver, pa, nil)
+		if !errors.Is(aRes.err, ErrDesync) {
+			t.Errorf("got Alice error %v, want %v", aRes.err, ErrDesync)
+		}
+	})
+}
+
+// stuckSource is an entropy.Source which only ever produces zeros.
+type stuckSource struct{}
+
+func (stuckSource) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
 	}
-	if aRes.key.Size() == 0 {
-		t.Errorf("Alice arrived at an empty key")
+	return len(p), nil
+}
+
+func TestNegotiationEntropyFailure(t *testing.T) {
+	pa := threeLevelAttrs()
+	sender, receiver := photon.NewSimulatedChannel(decoyChannelOpts(pa.levels()))
+	aRes, _ := negotiate(t, sender, receiver, pa, func(o *PeerOpts) {
+		if o.Sender != nil {
+			o.Rand = stuckSource{}
+		}
+	})
+	if !errors.Is(aRes.err, entropy.ErrEntropy) {
+		t.Errorf("got Alice error %v, want %v", aRes.err, entropy.ErrEntropy)
 	}
-	if bRes.key.Size() == 0 {
-		t.Errorf("Bob arrived at an empty key")
+}
+
+func TestNegotiationIntensities(t *testing.T) {
+	tcs := []struct {
+		name       string
+		levels     []Intensity
+		batchBytes int
+	}{
+		{
+			name:   "vacuum decoy",
+			levels: []Intensity{{Mu: 0, Prob: 0.2}, {Mu: 0.05, Prob: 0.2}, {Mu: 0.3, Prob: 0.6}},
+		},
+		{
+			name:   "four",
+			levels: []Intensity{{Mu: 0, Prob: 0.1}, {Mu: 0.05, Prob: 0.2}, {Mu: 0.1, Prob: 0.2}, {Mu: 0.3, Prob: 0.5}},
+		},
+		{
+			name: "five",
+			levels: []Intensity{
+				{Mu: 0, Prob: 0.1}, {Mu: 0.02, Prob: 0.1}, {Mu: 0.05, Prob: 0.2}, {Mu: 0.1, Prob: 0.2}, {Mu: 0.3, Prob: 0.4},
+			},
+		},
+		{
+			// Most pulses are detected, in large batches, so that Alice's
+			// announcements of their intensities are as long as they get.
+			name:       "low loss",
+			levels:     []Intensity{{Mu: 0, Prob: 0.05}, {Mu: 0.3, Prob: 0.15}, {Mu: 1, Prob: 0.8}},
+			batchBytes: 1 << 16,
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			chOpts := decoyChannelOpts(tc.levels)
+			sender, receiver := photon.NewSimulatedChannel(chOpts)
+			aRes, bRes := negotiate(t, sender, receiver, PulseAttrs{Intensities: tc.levels}, func(o *PeerOpts) {
+				o.MeasurementBatchBytes = tc.batchBytes
+			})
+			checkKeysAgree(t, aRes, bRes)
+		})
+	}
+}
+
+// monitoringSender reports fixed monitored intensities for every batch of the
+// underlying SimulatedSender.
+type monitoringSender struct {
+	*photon.SimulatedSender
+	monitored []photon.IntensityRange
+}
+
+func (s monitoringSender) NextBatch(bytes int) (photon.SentBatch, error) {
+	b, err := s.SimulatedSender.NextBatch(bytes)
+	b.MonitoredIntensities = s.monitored
+	return b, err
+}
+
+func TestNegotiationIntensityTolerance(t *testing.T) {
+	levels := []Intensity{
+		{Mu: 0, Prob: 0.2},
+		{Mu: 0.05, Tolerance: 0.005, Prob: 0.2},
+		{Mu: 0.3, Tolerance: 0.02, Prob: 0.6},
+	}
+	chOpts := decoyChannelOpts(levels)
+	exact := []photon.IntensityRange{{Min: 0, Max: 0}, {Min: 0.05, Max: 0.05}, {Min: 0.3, Max: 0.3}}
+	pa := PulseAttrs{Intensities: levels}
+
+	keyLen := func(monitored []photon.IntensityRange) int {
+		sender, receiver := photon.NewSimulatedChannel(chOpts)
+		aRes, bRes := negotiate(t, monitoringSender{sender, monitored}, receiver, pa, nil)
+		checkKeysAgree(t, aRes, bRes)
+		if aRes.stats.Estimates != bRes.stats.Estimates {
+			t.Errorf("Alice and Bob disagree on estimates: (%+v, %+v)", aRes.stats.Estimates, bRes.stats.Estimates)
+		}
+		return aRes.stats.Estimates.SafeKeyLen
+	}
+	tolerant, monitored := keyLen(nil), keyLen(exact)
+	if tolerant >= monitored {
+		t.Errorf("got safe key length %d with monitored intensities, want more than the %d without", monitored, tolerant)
+	}
+
+	sender, receiver := photon.NewSimulatedChannel(chOpts)
+	inconsistent := []photon.IntensityRange{{Min: 0, Max: 0}, {Min: 0.1, Max: 0.1}, {Min: 0.3, Max: 0.3}}
+	aRes, bRes := negotiate(t, monitoringSender{sender, inconsistent}, receiver, pa, nil)
+	if aRes.err == nil && bRes.err == nil {
+		t.Errorf("negotiation succeeded despite monitored intensities outside their tolerance")
+	}
+}
+
+func TestNegotiationConcentrationBounds(t *testing.T) {
+	levels := []Intensity{{Mu: 0, Prob: 0.2}, {Mu: 0.05, Prob: 0.2}, {Mu: 0.3, Prob: 0.6}}
+	chOpts := decoyChannelOpts(levels)
+	keyLens := map[string]int{}
+	for _, cb := range []ConcentrationBound{nil, Chernoff{}, Kato{}} {
+		sender, receiver := photon.NewSimulatedChannel(chOpts)
+		aRes, bRes := negotiate(t, sender, receiver, PulseAttrs{Intensities: levels}, func(o *PeerOpts) {
+			o.ConcentrationBound = cb
+		})
+		checkKeysAgree(t, aRes, bRes)
+		est := aRes.stats.Estimates
+		if est != bRes.stats.Estimates {
+			t.Errorf("Alice and Bob disagree on estimates: (%+v, %+v)", est, bRes.stats.Estimates)
+		}
+		keyLens[est.Bound] = est.SafeKeyLen
+	}
+	for _, name := range []string{"chernoff", "kato"} {
+		if keyLens[name] <= keyLens["hoeffding"] {
+			t.Errorf("got safe key lengths %v, want %s's to beat hoeffding's", keyLens, name)
+		}
+	}
+}
+
+func TestNegotiationAsymptotic(t *testing.T) {
+	levels := []Intensity{{Mu: 0, Prob: 0.2}, {Mu: 0.05, Prob: 0.2}, {Mu: 0.3, Prob: 0.6}}
+	chOpts := decoyChannelOpts(levels)
+	var ests []Estimates
+	for _, asymptotic := range []bool{false, true} {
+		sender, receiver := photon.NewSimulatedChannel(chOpts)
+		aRes, bRes := negotiate(t, sender, receiver, PulseAttrs{Intensities: levels}, func(o *PeerOpts) {
+			o.InsecureAsymptotic = asymptotic
+		})
+		checkKeysAgree(t, aRes, bRes)
+		if aRes.stats.Estimates != bRes.stats.Estimates {
+			t.Errorf("Alice and Bob disagree on estimates: (%+v, %+v)", aRes.stats.Estimates, bRes.stats.Estimates)
+		}
+		if aRes.stats.Insecure != asymptotic || bRes.stats.Insecure != asymptotic {
+			t.Errorf("got keys flagged insecure (%v, %v), want %v", aRes.stats.Insecure, bRes.stats.Insecure, asymptotic)
+		}
+		ests = append(ests, aRes.stats.Estimates)
+	}
+	finite, asymptotic := ests[0], ests[1]
+	if asymptotic.Bound != "insecure-asymptotic" {
+		t.Errorf("got asymptotic estimates bound %q, want them flagged insecure", asymptotic.Bound)
+	}
+	if asymptotic.SafeKeyLen <= finite.SafeKeyLen || asymptotic.PhaseError >= finite.PhaseError {
+		t.Errorf("got asymptotic estimates %+v, want a longer key and lower phase error than finite %+v", asymptotic, finite)
+	}
+
+	sender, _ := photon.NewSimulatedChannel(chOpts)
+	l, _ := net.Pipe()
+	defer l.Close()
+	_, err := NewPeer(PeerOpts{
+		Sender:             sender,
+		ClassicalChannel:   l,
+		Rand:               rand.New(rand.NewSource(42)),
+		Secret:             bytes.NewBuffer(make([]byte, 1<<20)),
+		WinnowOpts:         &WinnowOpts{Iters: []int{3}, SyncRand: rand.New(rand.NewSource(17))},
+		PulseAttrs:         PulseAttrs{Intensities: levels},
+		InsecureAsymptotic: true,
+		ConcentrationBound: Kato{},
+	})
+	if err == nil {
+		t.Errorf("InsecureAsymptotic combined with a ConcentrationBound was accepted")
+	}
+}
+
+func TestNegotiationEpsilonSecurity(t *testing.T) {
+	const security = 1e-10
+	pa := PulseAttrs{Intensities: []Intensity{{Mu: 0, Prob: 0.2}, {Mu: 0.05, Prob: 0.2}, {Mu: 0.3, Prob: 0.6}}}
+	chOpts := decoyChannelOpts(pa.Intensities)
+	sender, receiver := photon.NewSimulatedChannel(chOpts)
+	aRes, bRes := negotiate(t, sender, receiver, pa, func(o *PeerOpts) {
+		o.EpsilonSecurity = security
+	})
+	checkKeysAgree(t, aRes, bRes)
+	for _, eps := range []Epsilons{aRes.stats.Epsilons, bRes.stats.Epsilons} {
+		if eps.Auth <= 0 || eps.Correct <= 0 || eps.Estimation <= 0 || eps.Amplification <= 0 || eps.Total() > security {
+			t.Errorf("got allocation %+v, want every part positive and a total of at most %g", eps, float64(security))
+		}
+	}
+	if aRes.stats.Epsilons != bRes.stats.Epsilons {
+		t.Errorf("Alice and Bob disagree on allocations: (%+v, %+v)", aRes.stats.Epsilons, bRes.stats.Epsilons)
+	}
+
+	l, _ := net.Pipe()
+	defer l.Close()
+	_, err := NewPeer(PeerOpts{
+		Sender:           sender,
+		ClassicalChannel: l,
+		Rand:             rand.New(rand.NewSource(42)),
+		Secret:           bytes.NewBuffer(make([]byte, 1<<20)),
+		WinnowOpts:       &WinnowOpts{Iters: []int{3}, SyncRand: rand.New(rand.NewSource(17))},
+		PulseAttrs:       pa,
+		EpsilonSecurity:  security,
+		EpsilonAuth:      1e-6,
+	})
+	if err == nil {
+		t.Errorf("EpsilonSecurity combined with EpsilonAuth was accepted")
+	}
+}
+
+func TestNegotiationAbortThresholds(t *testing.T) {
+	pa := threeLevelAttrs()
+	tcs := []struct {
+		name      string
+		eve       photon.Eavesdropper
+		alice     abortLimits
+		bob       abortLimits
+		wantAbort bool
+	}{
+		{
+			name:  "quiet channel",
+			alice: abortLimits{qber: 0.11, phaseError: 0.2},
+			bob:   abortLimits{qber: 0.11, phaseError: 0.2},
+		}, {
+			name:      "alice's qber",
+			eve:       photon.InterceptResend{Fraction: 1},
+			alice:     abortLimits{qber: 0.11},
+			wantAbort: true,
+		}, {
+			name:      "bob's qber",
+			eve:       photon.InterceptResend{Fraction: 1},
+			bob:       abortLimits{qber: 0.11},
+			wantAbort: true,
+		}, {
+			name:      "bob's phase error",
+			eve:       photon.InterceptResend{Fraction: 1},
+			bob:       abortLimits{phaseError: 0.2},
+			wantAbort: true,
+		},
+	}
+	for _, tc := range tcs {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			chOpts := decoyChannelOpts(pa.levels())
+			chOpts.Eve = tc.eve
+			sender, receiver := photon.NewSimulatedChannel(chOpts)
+			aRes, bRes := negotiate(t, sender, receiver, pa, func(o *PeerOpts) {
+				l := tc.bob
+				if o.Sender != nil {
+					l = tc.alice
+				}
+				o.MaxQBER, o.MaxPhaseError = l.qber, l.phaseError
+			})
+			if !tc.wantAbort {
+				if aRes.err != nil || bRes.err != nil {
+					t.Fatalf("got errors (%v, %v), want a key", aRes.err, bRes.err)
+				}
+				return
+			}
+			for _, res := range []negotiationResult{aRes, bRes} {
+				if !errors.Is(res.err, ErrAborted) {
+					t.Errorf("got error %v, want %v", res.err, ErrAborted)
+				}
+				if res.stats.BitsLeaked != 0 || res.stats.Timings.Reconciliation != 0 {
+					t.Errorf("reconciliation ran before aborting: %+v", res.stats)
+				}
+			}
+		})
+	}
+}
+
+func TestNegotiationSinglePhoton(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		eve       photon.Eavesdropper
+		monitored []photon.IntensityRange
+		wantAbort bool
+	}{
+		{name: "quiet channel"},
+		// Any intensities the Sender monitors are ignored, as for those it
+		// reports preparing pulses at.
+		{name: "monitored intensities", monitored: []photon.IntensityRange{{Min: 0, Max: 0}, {Min: 0.3, Max: 0.3}}},
+		{name: "intercept-resend", eve: photon.InterceptResend{Fraction: 1}, wantAbort: true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			sender, receiver := photon.NewSimulatedChannel(photon.SimulatedChannelOpts{
+				PMain:        0.5,
+				SinglePhoton: true,
+				SendSeed:     1234,
+				ReceiveSeed:  5678,
+				Link:         &photon.FiberLink{DetectorEfficiency: 0.5, Misalignment: 0.02},
+				Eve:          tc.eve,
+				EveSeed:      4321,
+			})
+			var s photon.Sender = sender
+			if tc.monitored != nil {
+				s = monitoringSender{sender, tc.monitored}
+			}
+			aRes, bRes := negotiate(t, s, receiver, PulseAttrs{}, func(o *PeerOpts) {
+				o.SinglePhotonSource = true
+			})
+			if tc.wantAbort {
+				res := aRes
+				if res.err == nil {
+					res = bRes
+				}
+				if res.err == nil {
+					t.Fatalf("got a key of %d bits, want an abort", aRes.key.Size())
+				}
+				return
+			}
+			checkKeysAgree(t, aRes, bRes)
+			if est := aRes.stats.Estimates; est != bRes.stats.Estimates || est.VacuumX != 0 || est.PhaseError < aRes.stats.QBER {
+				t.Errorf("got estimates (%+v, %+v), want equal ones, with no vacuum detections and a phase error bound of at least the QBER %f",
+					est, bRes.stats.Estimates, aRes.stats.QBER)
+			}
+		})
+	}
+}
+
+func TestNegotiationSampling(t *testing.T) {
+	pa := PulseAttrs{Intensities: []Intensity{{Mu: 0, Prob: 0.2}, {Mu: 0.05, Prob: 0.2}, {Mu: 0.3, Prob: 0.6}}}
+	run := func(aProp, bProp float64) (negotiationResult, negotiationResult) {
+		chOpts := decoyChannelOpts(pa.Intensities)
+		sender, receiver := photon.NewSimulatedChannel(chOpts)
+		// Sampling only pays off once blocks are large enough for parameter
+		// estimation to get by on a sample, which Kato's bound helps with.
+		return negotiate(t, sender, receiver, pa, func(o *PeerOpts) {
+			o.ConcentrationBound = Kato{}
+			o.SampleProp = bProp
+			if o.Sender != nil {
+				o.SampleProp = aProp
+			}
+		})
+	}
+	baseline, _ := run(0, 0)
+	if baseline.err != nil {
+		t.Fatalf("Negotiating without sampling: %v", baseline.err)
+	}
+
+	aRes, bRes := run(0.1, 0.1)
+	checkKeysAgree(t, aRes, bRes)
+	if got, base := aRes.key.Size(), baseline.key.Size(); got < base*6/5 {
+		t.Errorf("got key of %d bits with sampling, want at least a fifth more than the %d without", got, base)
+	}
+	if est := aRes.stats.TestEstimates; est != bRes.stats.TestEstimates || est.SafeKeyLen <= 0 {
+		t.Errorf("got test basis estimates (%+v, %+v), want equal ones supporting some key", est, bRes.stats.TestEstimates)
+	}
+	if aRes.stats.Estimates.SafeKeyLen <= aRes.stats.TestEstimates.SafeKeyLen {
+		t.Errorf("got safe key len %d, want more than the test basis's share of %d",
+			aRes.stats.Estimates.SafeKeyLen, aRes.stats.TestEstimates.SafeKeyLen)
+	}
+
+	if aRes, bRes := run(0.1, 0); aRes.err == nil && bRes.err == nil {
+		t.Errorf("negotiation succeeded with only Alice sampling")
 	}
 }
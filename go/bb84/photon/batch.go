@@ -0,0 +1,231 @@
+package photon
+
+import (
+	"fmt"
+
+	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+)
+
+// A SentBatch describes a batch of pulses sent by a BatchSender.
+type SentBatch struct {
+	// Bits and Bases hold the logical bit value and basis of each pulse. A
+	// zero basis denotes the "main" basis, and a one the "test" basis, as in
+	// basis-biased BB84.
+	Bits, Bases bitmap.Dense
+
+	// Intensities holds, for each pulse, the index of the intensity level it
+	// was prepared at. Levels are numbered in increasing order of mean photon
+	// number, e.g. 0, 1, and 2 for low, medium, and high.
+	Intensities []uint8
+
+	// MonitoredIntensities optionally holds, for each intensity level, the
+	// range of mean photon numbers measured by an intensity monitor over the
+	// batch. A DeviceSender fills it in from sources with an intensity
+	// monitor, a RemoteSender passes on whatever the served sender reported,
+	// and a ReplaySender whatever was recorded. Simulated senders leave it
+	// empty.
+	MonitoredIntensities []IntensityRange
+
+	// Timestamps optionally holds the emission time of each pulse, in
+	// picoseconds on the sender's clock.
+	Timestamps []int64
+
+	// FirstPulse is the index of the batch's first pulse within the overall
+	// stream of pulses. It is only meaningful if Sequenced is set.
+	FirstPulse uint64
+	Sequenced  bool
+
+	// Metadata optionally holds free-form, implementation specific details
+	// about the batch, e.g. hardware settings or health readings.
+	Metadata map[string]string
+}
+
+// An IntensityRange bounds the mean photon number of pulses prepared at some
+// intensity level.
+type IntensityRange struct {
+	Min, Max float64
+}
+
+// A ReceivedBatch describes a batch of pulses received by a BatchReceiver.
+type ReceivedBatch struct {
+	// Bits and Bases hold the logical bit value measured for each pulse, and
+	// the basis it was measured in. Dropped indicates which pulses we failed
+	// to detect at all.
+	Bits, Bases, Dropped bitmap.Dense
+
+	// Timestamps optionally holds the detection time of each pulse, in
+	// picoseconds on the receiver's clock.
+	Timestamps []int64
+
+	// Detector optionally reports detector-level events which occurred while
+	// receiving the batch.
+	Detector *DetectorEvents
+
+	// FirstPulse is the index of the batch's first pulse within the overall
+	// stream of pulses. It is only meaningful if Sequenced is set.
+	FirstPulse uint64
+	Sequenced  bool
+
+	// Metadata optionally holds free-form, implementation specific details
+	// about the batch.
+	Metadata map[string]string
+}
+
+// A BatchSender sends qubits encoded as photons to a BatchReceiver.
+type BatchSender interface {
+	// NextBatch returns the results of sending the next batch of bytes*8
+	// pulses.
+	NextBatch(bytes int) (SentBatch, error)
+}
+
+// A BatchReceiver receives photons and decodes them in a given measurement
+// basis.
+type BatchReceiver interface {
+	// NextBatch returns the results of receiving the next batch of bytes*8
+	// pulses.
+	NextBatch(bytes int) (ReceivedBatch, error)
+}
+
+// NewBatchSender adapts a Sender to the BatchSender interface. If s also
+// implements Sequencer, batches are sequenced accordingly. If s already
+// implements BatchSender, or was itself adapted from one by NewLegacySender,
+// then that BatchSender is returned. Batches in which any pulse is not flagged
+// as exactly one of lo, med, or hi fail with an error.
+func NewBatchSender(s Sender) BatchSender {
+	switch s := s.(type) {
+	case BatchSender:
+		return s
+	case *legacySender:
+		return s.bs
+	}
+	return &senderAdapter{s}
+}
+
+type senderAdapter struct {
+	s Sender
+}
+
+func (sa *senderAdapter) NextBatch(bytes int) (SentBatch, error) {
+	bits, bases, lo, med, hi, err := sa.s.Next(bytes)
+	if err != nil {
+		return SentBatch{}, err
+	}
+	b := SentBatch{
+		Bits:  bitmap.NewDense(bits, -1),
+		Bases: bitmap.NewDense(bases, -1),
+	}
+	if b.Intensities, err = intensitiesFromMasks(len(bits)*8, lo, med, hi); err != nil {
+		return SentBatch{}, err
+	}
+	if seq, ok := sa.s.(Sequencer); ok {
+		b.FirstPulse, b.Sequenced = seq.FirstPulse(), true
+	}
+	return b, nil
+}
+
+// intensitiesFromMasks returns the intensity level of each of n pulses, given
+// the lo, med, and hi bitmasks of a Sender. It is an error for any pulse not to
+// be flagged as exactly one of them.
+func intensitiesFromMasks(n int, lo, med, hi []byte) ([]uint8, error) {
+	intensities := make([]uint8, n)
+	masks := []bitmap.Dense{bitmap.NewDense(lo, -1), bitmap.NewDense(med, -1), bitmap.NewDense(hi, -1)}
+	for i := range intensities {
+		flags := 0
+		for level, m := range masks {
+			if m.Get(i) {
+				intensities[i] = uint8(level)
+				flags++
+			}
+		}
+		if flags != 1 {
+			return nil, fmt.Errorf("pulse %d is flagged as %d of lo, med, and hi, want exactly 1", i, flags)
+		}
+	}
+	return intensities, nil
+}
+
+// NewBatchReceiver adapts a Receiver to the BatchReceiver interface. If r also
+// implements Sequencer or DetectorReporter, batches are sequenced and report
+// detector events accordingly. If r already implements BatchReceiver, or was
+// itself adapted from one by NewLegacyReceiver, then that BatchReceiver is
+// returned.
+func NewBatchReceiver(r Receiver) BatchReceiver {
+	switch r := r.(type) {
+	case BatchReceiver:
+		return r
+	case *legacyReceiver:
+		return r.br
+	}
+	return &receiverAdapter{r}
+}
+
+type receiverAdapter struct {
+	r Receiver
+}
+
+func (ra *receiverAdapter) NextBatch(bytes int) (ReceivedBatch, error) {
+	bits, bases, dropped, err := ra.r.Next(bytes)
+	if err != nil {
+		return ReceivedBatch{}, err
+	}
+	b := ReceivedBatch{
+		Bits:    bitmap.NewDense(bits, -1),
+		Bases:   bitmap.NewDense(bases, -1),
+		Dropped: bitmap.NewDense(dropped, -1),
+	}
+	if seq, ok := ra.r.(Sequencer); ok {
+		b.FirstPulse, b.Sequenced = seq.FirstPulse(), true
+	}
+	if dr, ok := ra.r.(DetectorReporter); ok {
+		events := dr.DetectorEvents()
+		b.Detector = &events
+	}
+	return b, nil
+}
+
+// NewLegacySender adapts a BatchSender to the Sender interface. Since a Sender
+// can only describe three intensity levels, batches using more fail with an
+// error.
+func NewLegacySender(bs BatchSender) Sender {
+	return &legacySender{bs}
+}
+
+type legacySender struct {
+	bs BatchSender
+}
+
+func (ls *legacySender) Next(bytes int) (bits, bases, lo, med, hi []byte, err error) {
+	b, err := ls.bs.NextBatch(bytes)
+	if err != nil {
+		return
+	}
+	masks := make([]bitmap.Dense, 3)
+	for i, level := range b.Intensities {
+		if int(level) >= len(masks) {
+			err = fmt.Errorf("pulse %d has intensity level %d, but only 3 levels are supported", i, level)
+			return
+		}
+		for l := range masks {
+			masks[l].AppendBit(int(level) == l)
+		}
+	}
+	return b.Bits.Data(), b.Bases.Data(), masks[0].Data(), masks[1].Data(), masks[2].Data(), nil
+}
+
+// NewLegacyReceiver adapts a BatchReceiver to the Receiver interface. Any
+// additional information carried by the batches is discarded.
+func NewLegacyReceiver(br BatchReceiver) Receiver {
+	return &legacyReceiver{br}
+}
+
+type legacyReceiver struct {
+	br BatchReceiver
+}
+
+func (lr *legacyReceiver) Next(bytes int) (bits, bases, dropped []byte, err error) {
+	b, err := lr.br.NextBatch(bytes)
+	if err != nil {
+		return
+	}
+	return b.Bits.Data(), b.Bases.Data(), b.Dropped.Data(), nil
+}
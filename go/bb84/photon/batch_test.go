@@ -0,0 +1,131 @@
+package photon
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+)
+
+func TestBatchAdapters(t *testing.T) {
+	opts := SimulatedChannelOpts{
+		PMain:       0.5,
+		MuLo:        0.1,
+		MuMed:       0.2,
+		MuHi:        0.5,
+		PLo:         0.3,
+		PMed:        0.3,
+		PHi:         0.4,
+		SendSeed:    1,
+		ReceiveSeed: 2,
+		Detector:    &Detector{DeadTime: 1},
+	}
+	ss, sr := NewSimulatedChannel(opts)
+	wantSS, wantSR := NewSimulatedChannel(opts)
+	bs, br := NewBatchSender(ss), NewBatchReceiver(sr)
+	for i := 0; i < 2; i++ {
+		bits, bases, lo, med, hi, err := wantSS.Next(16)
+		if err != nil {
+			t.Fatalf("sending: %v", err)
+		}
+		rBits, rBases, dropped, err := wantSR.Next(16)
+		if err != nil {
+			t.Fatalf("receiving: %v", err)
+		}
+		sent, err := bs.NextBatch(16)
+		if err != nil {
+			t.Fatalf("sending batch: %v", err)
+		}
+		received, err := br.NextBatch(16)
+		if err != nil {
+			t.Fatalf("receiving batch: %v", err)
+		}
+
+		if !bytes.Equal(sent.Bits.Data(), bits) || !bytes.Equal(sent.Bases.Data(), bases) {
+			t.Errorf("batch %d: sent bits/bases differ from the underlying Sender's", i)
+		}
+		for j, level := range sent.Intensities {
+			masks := []bitmap.Dense{bitmap.NewDense(lo, -1), bitmap.NewDense(med, -1), bitmap.NewDense(hi, -1)}
+			if !masks[level].Get(j) {
+				t.Errorf("batch %d, pulse %d: got intensity level %d, which disagrees with the masks", i, j, level)
+			}
+		}
+		if !sent.Sequenced || sent.FirstPulse != uint64(i*128) {
+			t.Errorf("batch %d: got sent sequence (%v, %d), want (true, %d)", i, sent.Sequenced, sent.FirstPulse, i*128)
+		}
+		if !bytes.Equal(received.Bits.Data(), rBits) || !bytes.Equal(received.Bases.Data(), rBases) ||
+			!bytes.Equal(received.Dropped.Data(), dropped) {
+			t.Errorf("batch %d: received bits/bases/dropped differ from the underlying Receiver's", i)
+		}
+		if received.Detector == nil {
+			t.Errorf("batch %d: received batch is missing detector events", i)
+		}
+		if !received.Sequenced || received.FirstPulse != uint64(i*128) {
+			t.Errorf("batch %d: got received sequence (%v, %d), want (true, %d)",
+				i, received.Sequenced, received.FirstPulse, i*128)
+		}
+	}
+}
+
+// levelSender sends batches with every pulse at a fixed intensity level.
+type levelSender struct {
+	level uint8
+}
+
+func (ls levelSender) NextBatch(bytes int) (SentBatch, error) {
+	b := SentBatch{
+		Bits:        bitmap.NewDense(nil, bytes*8),
+		Bases:       bitmap.NewDense(nil, bytes*8),
+		Intensities: make([]uint8, bytes*8),
+	}
+	for i := range b.Intensities {
+		b.Intensities[i] = ls.level
+	}
+	return b, nil
+}
+
+func TestLegacyAdapters(t *testing.T) {
+	s := NewLegacySender(levelSender{2})
+	_, _, lo, med, hi, err := s.Next(2)
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if !bytes.Equal(lo, []byte{0, 0}) || !bytes.Equal(med, []byte{0, 0}) || !bytes.Equal(hi, []byte{0xFF, 0xFF}) {
+		t.Errorf("got masks (%v, %v, %v), want every pulse hi", lo, med, hi)
+	}
+	if _, _, _, _, _, err := NewLegacySender(levelSender{3}).Next(2); err == nil {
+		t.Errorf("legacy Sender accepted a fourth intensity level")
+	}
+	if _, ok := NewBatchSender(s).(levelSender); !ok {
+		t.Errorf("NewBatchSender did not unwrap a legacy Sender")
+	}
+}
+
+// maskSender is a Sender reporting fixed intensity masks.
+type maskSender struct {
+	lo, med, hi []byte
+}
+
+func (ms maskSender) Next(bytes int) (bits, bases, lo, med, hi []byte, err error) {
+	return make([]byte, bytes), make([]byte, bytes), ms.lo, ms.med, ms.hi, nil
+}
+
+func TestBatchSenderRejectsBadMasks(t *testing.T) {
+	tcs := []struct {
+		name        string
+		lo, med, hi byte
+		wantErr     bool
+	}{
+		{name: "valid", lo: 0x0F, med: 0x30, hi: 0xC0},
+		{name: "unflagged", lo: 0x0F, med: 0x30, hi: 0x40, wantErr: true},
+		{name: "doubly flagged", lo: 0x1F, med: 0x30, hi: 0xC0, wantErr: true},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			bs := NewBatchSender(maskSender{[]byte{tc.lo}, []byte{tc.med}, []byte{tc.hi}})
+			if _, err := bs.NextBatch(1); (err != nil) != tc.wantErr {
+				t.Errorf("NextBatch() error == %v, want error: %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package photon
+
+import (
+	"math/rand"
+
+	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+)
+
+// A DetectorReporter is a Receiver which can report on detector-level events
+// underlying the batch most recently returned from Next.
+type DetectorReporter interface {
+	Receiver
+
+	// DetectorEvents returns the detector-level events which occurred while
+	// receiving the most recent batch.
+	DetectorEvents() DetectorEvents
+}
+
+// DetectorEvents packages together detector-level events which are invisible
+// in the bits/bases/dropped representation of a batch.
+type DetectorEvents struct {
+	// DoubleClicks is a bitmask of the pulses on which both of the receiver's
+	// detectors clicked. Following the squashing model, such pulses are
+	// reported with a uniformly random bit rather than dropped.
+	DoubleClicks []byte
+
+	// DarkCounts is the number of clicks caused by dark counts alone.
+	DarkCounts int
+
+	// Afterpulses is the number of clicks caused by afterpulsing.
+	Afterpulses int
+
+	// DeadTimeLosses is the number of pulses on which a click would have
+	// occurred, had a detector not been recovering from a previous click. A
+	// pulse lost to both detectors counts once.
+	DeadTimeLosses int
+}
+
+// A Detector models the non-idealities of a receiver's pair of threshold
+// detectors, one per bit value, beyond the efficiency and dark count rate
+// described by a FiberLink.
+type Detector struct {
+	// DeadTime is the number of pulses following a click during which a
+	// detector cannot click again.
+	DeadTime int
+
+	// AfterpulseProb is the probability that a click is followed by a spurious
+	// click in the first pulse after the detector's dead time elapses.
+	AfterpulseProb float64
+}
+
+// detectorState tracks the evolution of a Detector's two detectors across
+// pulses, and batches.
+type detectorState struct {
+	Detector
+
+	// Indexed by the bit value each detector registers.
+	deadFor    [2]int
+	afterpulse [2]int
+}
+
+// detect simulates the detection of a batch of pulses. It returns the bits
+// registered and which pulses were dropped, along with a report of the
+// underlying detector events.
+func (d *detectorState) detect(link *FiberLink, sendBits, sendBases, receiveBases bitmap.Dense,
+	photons []int, r *rand.Rand) (bits, drops bitmap.Dense, events DetectorEvents) {
+	eta := link.Transmittance()
+	doubles := bitmap.Empty()
+	for i, n := range photons {
+		matched := sendBases.Get(i) == receiveBases.Get(i)
+		sent := 0
+		if sendBits.Get(i) {
+			sent = 1
+		}
+		var signal, dark, after [2]bool
+		for j := 0; j < n; j++ {
+			if r.Float64() >= eta {
+				continue
+			}
+			k := sent
+			if !matched && r.Float64() < 0.5 || matched && r.Float64() < link.Misalignment {
+				k = 1 - sent
+			}
+			signal[k] = true
+		}
+		var clicks, lost [2]bool
+		for k := 0; k < 2; k++ {
+			dark[k] = r.Float64() < link.DarkCountProb
+			if d.afterpulse[k] > 0 {
+				d.afterpulse[k]--
+				after[k] = d.afterpulse[k] == 0
+			}
+			wouldClick := signal[k] || dark[k] || after[k]
+			if d.deadFor[k] > 0 {
+				d.deadFor[k]--
+				lost[k] = wouldClick
+				continue
+			}
+			if !wouldClick {
+				continue
+			}
+			clicks[k] = true
+			switch {
+			case signal[k]:
+			case after[k]:
+				events.Afterpulses++
+			default:
+				events.DarkCounts++
+			}
+			d.deadFor[k] = d.DeadTime
+			if r.Float64() < d.AfterpulseProb {
+				d.afterpulse[k] = d.DeadTime + 1
+			}
+		}
+		if lost[0] || lost[1] {
+			events.DeadTimeLosses++
+		}
+		switch {
+		case clicks[0] && clicks[1]:
+			bits.AppendBit(r.Float64() < 0.5)
+			drops.AppendBit(false)
+			doubles.AppendBit(true)
+			continue
+		case clicks[0], clicks[1]:
+			bits.AppendBit(clicks[1])
+			drops.AppendBit(false)
+		default:
+			bits.AppendBit(false)
+			drops.AppendBit(true)
+		}
+		doubles.AppendBit(false)
+	}
+	events.DoubleClicks = doubles.Data()
+	return bits, drops, events
+}
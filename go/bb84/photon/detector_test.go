@@ -0,0 +1,111 @@
+package photon
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+)
+
+func TestDetect(t *testing.T) {
+	ideal := &FiberLink{DetectorEfficiency: 1}
+	tcs := []struct {
+		name      string
+		detector  Detector
+		link      *FiberLink
+		photons   []int
+		wantDrops string
+		wantEvts  DetectorEvents
+	}{
+		{
+			name:      "dead time",
+			detector:  Detector{DeadTime: 2},
+			link:      ideal,
+			photons:   []int{3, 3, 3, 3, 3, 0, 3},
+			wantDrops: "0110110",
+			wantEvts:  DetectorEvents{DeadTimeLosses: 3},
+		}, {
+			name:      "dead time, both detectors",
+			detector:  Detector{DeadTime: 1},
+			link:      &FiberLink{DetectorEfficiency: 1, DarkCountProb: 1},
+			photons:   []int{0, 0, 0},
+			wantDrops: "010",
+			wantEvts:  DetectorEvents{DarkCounts: 4, DeadTimeLosses: 1},
+		}, {
+			name:      "afterpulsing",
+			detector:  Detector{DeadTime: 1, AfterpulseProb: 1},
+			link:      ideal,
+			photons:   []int{1, 0, 0, 0, 0},
+			wantDrops: "01010",
+			wantEvts:  DetectorEvents{Afterpulses: 2},
+		}, {
+			name:      "double clicks",
+			detector:  Detector{},
+			link:      &FiberLink{DetectorEfficiency: 1, DarkCountProb: 1},
+			photons:   []int{0, 0, 1},
+			wantDrops: "000",
+			wantEvts:  DetectorEvents{DarkCounts: 5},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			n := len(tc.photons)
+			zeros := bitmap.NewDense(nil, n)
+			d := &detectorState{Detector: tc.detector}
+			bits, drops, evts := d.detect(tc.link, zeros, zeros, zeros, tc.photons, rand.New(rand.NewSource(1)))
+			if bits.Size() != n {
+				t.Errorf("got %d bits for %d pulses", bits.Size(), n)
+			}
+			want, err := bitmap.FromString(tc.wantDrops)
+			if err != nil {
+				t.Fatalf("bugged test setup: %v", err)
+			}
+			if !bitmap.Equal(drops, want) {
+				t.Errorf("got drops %v, want %v", drops.Data(), want.Data())
+			}
+			doubles := bitmap.NewDense(evts.DoubleClicks, n)
+			if tc.link.DarkCountProb == 1 && tc.detector.DeadTime == 0 && bitmap.CountOnes(doubles) != n {
+				t.Errorf("got %d double clicks, want %d", bitmap.CountOnes(doubles), n)
+			}
+			if evts.DarkCounts != tc.wantEvts.DarkCounts ||
+				evts.Afterpulses != tc.wantEvts.Afterpulses ||
+				evts.DeadTimeLosses != tc.wantEvts.DeadTimeLosses {
+				t.Errorf("got events %+v, want %+v", evts, tc.wantEvts)
+			}
+		})
+	}
+}
+
+func TestSimulatedReceiverReportsDetectorEvents(t *testing.T) {
+	ss, sr := NewSimulatedChannel(SimulatedChannelOpts{
+		PMain:       0.5,
+		MuLo:        0.1,
+		MuMed:       0.5,
+		MuHi:        2,
+		PLo:         0.3,
+		PMed:        0.3,
+		PHi:         0.4,
+		SendSeed:    5,
+		ReceiveSeed: 6,
+		Link:        &FiberLink{DetectorEfficiency: 0.8, DarkCountProb: 0.05},
+		Detector:    &Detector{DeadTime: 3, AfterpulseProb: 0.1},
+	})
+	var r Receiver = sr
+	dr, ok := r.(DetectorReporter)
+	if !ok {
+		t.Fatalf("SimulatedReceiver does not implement DetectorReporter")
+	}
+	if _, _, _, _, _, err := ss.Next(128); err != nil {
+		t.Fatalf("sending: %v", err)
+	}
+	if _, _, _, err := sr.Next(128); err != nil {
+		t.Fatalf("receiving: %v", err)
+	}
+	evts := dr.DetectorEvents()
+	if evts.DeadTimeLosses == 0 || evts.Afterpulses == 0 || evts.DarkCounts == 0 {
+		t.Errorf("expected some of each kind of detector event, got %+v", evts)
+	}
+	if bitmap.CountOnes(bitmap.NewDense(evts.DoubleClicks, -1)) == 0 {
+		t.Errorf("expected some double clicks")
+	}
+}
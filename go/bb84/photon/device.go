@@ -0,0 +1,332 @@
+package photon
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+)
+
+// Hardware sources and detectors are driven over a byte stream, e.g. a serial
+// device, TCP connection, or unix socket, using a simple line-based protocol.
+// Each command is a line of ASCII text, answered by a line which is either
+// "OK", optionally followed by a space and a result, or "ERR" followed by a
+// space and an error message. Lines end with "\n", and any preceding "\r" is
+// ignored. The commands are:
+//
+//	*IDN?              OK <role> <model>, where role is "source" or "detector"
+//	BIAS <p>           set the probability of choosing the main basis
+//	INT <mu>...        set the mean photon number of each intensity level, in
+//	                   increasing order (sources only)
+//	PROB <p>...        set the probability of each intensity level (sources
+//	                   only)
+//	ARM                start emitting or detecting pulses
+//	DISARM             stop emitting or detecting pulses
+//	READ <bytes>       OK <first>, where first is the index of the batch's first
+//	                   pulse within the overall stream of pulses
+//	HEALTH?            OK <key>=<value>...
+//
+// A successful READ reply is immediately followed by the batch of bytes*8
+// pulses, in the recording format. Devices reject READs of more than
+// MaxBatchBytes. A source's batch holds the fields bits, bases, intensities,
+// and monitored intensities. Intensities holds one byte per pulse giving its
+// intensity level. Monitored intensities is empty if the source has no
+// intensity monitor, and otherwise holds the range of mean photon numbers it
+// measured at each level over the batch, as pairs of little-endian float64s.
+// A detector's batch holds bits, bases, dropped, and double clicks.
+const (
+	RoleSource   = "source"
+	RoleDetector = "detector"
+)
+
+// ErrDevice is returned when a device rejects a command.
+var ErrDevice = errors.New("device error")
+
+// A DeviceConfig describes how a device should prepare or measure pulses.
+type DeviceConfig struct {
+	// PMain is the probability with which the device chooses the main basis
+	// for any given pulse.
+	PMain float64
+
+	// Intensities and Probs specify the mean photons per pulse of each
+	// intensity level, in increasing order, and the probability with which
+	// each level is chosen. They only apply to sources.
+	Intensities, Probs []float64
+}
+
+func (c DeviceConfig) validate(role string) error {
+	if c.PMain <= 0 || c.PMain >= 1 {
+		return fmt.Errorf("main basis probability %f must lie in (0, 1)", c.PMain)
+	}
+	if role == RoleDetector {
+		if len(c.Intensities) != 0 || len(c.Probs) != 0 {
+			return errors.New("detectors cannot be configured with intensities")
+		}
+		return nil
+	}
+	if len(c.Intensities) == 0 || len(c.Intensities) != len(c.Probs) {
+		return fmt.Errorf("got %d intensities and %d probabilities, want an equal, non-zero number",
+			len(c.Intensities), len(c.Probs))
+	}
+	if len(c.Intensities) > 256 {
+		return fmt.Errorf("got %d intensity levels, but at most 256 are supported", len(c.Intensities))
+	}
+	if c.Intensities[0] < 0 {
+		return fmt.Errorf("intensity %f must be non-negative", c.Intensities[0])
+	}
+	for i := 1; i < len(c.Intensities); i++ {
+		if c.Intensities[i] <= c.Intensities[i-1] {
+			return errors.New("intensities must be strictly increasing")
+		}
+	}
+	pSum := 0.0
+	for _, p := range c.Probs {
+		if p < 0 || p > 1 {
+			return fmt.Errorf("intensity probability %f must lie in [0, 1]", p)
+		}
+		pSum += p
+	}
+	if math.Abs(pSum-1) > 1e-9 {
+		return fmt.Errorf("intensity probabilities must sum to one, got %v", c.Probs)
+	}
+	return nil
+}
+
+// A Device is a connection to a hardware source or detector. Most users will
+// want to use a DeviceSender or DeviceReceiver, which wrap a Device.
+type Device struct {
+	conn io.Writer
+	r    *bufio.Reader
+}
+
+// NewDevice returns a Device communicating over conn.
+func NewDevice(conn io.ReadWriter) *Device {
+	return &Device{conn: conn, r: bufio.NewReader(conn)}
+}
+
+// Identify returns the device's role, either RoleSource or RoleDetector, and
+// its model.
+func (d *Device) Identify() (role, model string, err error) {
+	reply, err := d.command("*IDN?")
+	if err != nil {
+		return "", "", err
+	}
+	parts := strings.SplitN(reply, " ", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("malformed identification %q", reply)
+	}
+	return parts[0], parts[1], nil
+}
+
+// Configure sends cfg to the device. Sources are sent the intensity levels and
+// their probabilities, while detectors are only sent the basis bias.
+func (d *Device) Configure(cfg DeviceConfig) error {
+	if _, err := d.command("BIAS " + formatFloats(cfg.PMain)); err != nil {
+		return err
+	}
+	if len(cfg.Intensities) == 0 {
+		return nil
+	}
+	if _, err := d.command("INT " + formatFloats(cfg.Intensities...)); err != nil {
+		return err
+	}
+	_, err := d.command("PROB " + formatFloats(cfg.Probs...))
+	return err
+}
+
+// Arm starts the device emitting or detecting pulses.
+func (d *Device) Arm() error {
+	_, err := d.command("ARM")
+	return err
+}
+
+// Disarm stops the device emitting or detecting pulses.
+func (d *Device) Disarm() error {
+	_, err := d.command("DISARM")
+	return err
+}
+
+// Health returns the device's self-reported health readings. Which readings
+// are reported, e.g. temperatures or count rates, varies between devices.
+func (d *Device) Health() (map[string]string, error) {
+	reply, err := d.command("HEALTH?")
+	if err != nil {
+		return nil, err
+	}
+	health := make(map[string]string)
+	for _, kv := range strings.Fields(reply) {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("malformed health reading %q", kv)
+		}
+		health[parts[0]] = parts[1]
+	}
+	return health, nil
+}
+
+// read reads the next batch of bytes*8 pulses, made up of fields of the given
+// lengths. Fields of negative length are left for the caller to check.
+func (d *Device) read(bytes int, lens ...int) (first uint64, fields [][]byte, err error) {
+	if err := checkBatchBytes(bytes); err != nil {
+		return 0, nil, err
+	}
+	reply, err := d.command("READ " + strconv.Itoa(bytes))
+	if err != nil {
+		return 0, nil, err
+	}
+	first, err = strconv.ParseUint(reply, 10, 64)
+	if err != nil {
+		return 0, nil, fmt.Errorf("parsing first pulse: %w", err)
+	}
+	fields, err = readRecord(d.r, len(lens), -1)
+	if err != nil {
+		return 0, nil, fmt.Errorf("reading batch: %w", err)
+	}
+	for i, f := range fields {
+		if lens[i] >= 0 && len(f) != lens[i] {
+			return 0, nil, fmt.Errorf("field %d of batch holds %d bytes, want %d", i, len(f), lens[i])
+		}
+	}
+	return first, fields, nil
+}
+
+func (d *Device) command(cmd string) (string, error) {
+	if _, err := io.WriteString(d.conn, cmd+"\n"); err != nil {
+		return "", fmt.Errorf("sending %q: %w", cmd, err)
+	}
+	line, err := d.r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("awaiting reply to %q: %w", cmd, err)
+	}
+	parts := strings.SplitN(strings.TrimRight(line, "\r\n"), " ", 2)
+	reply := ""
+	if len(parts) == 2 {
+		reply = parts[1]
+	}
+	switch parts[0] {
+	case "OK":
+		return reply, nil
+	case "ERR":
+		return "", fmt.Errorf("%w: %s: %s", ErrDevice, cmd, reply)
+	}
+	return "", fmt.Errorf("unrecognized reply %q to %q", line, cmd)
+}
+
+// setUp checks that d has the given role, and configures and arms it.
+func (d *Device) setUp(role string, cfg DeviceConfig) error {
+	if err := cfg.validate(role); err != nil {
+		return err
+	}
+	got, model, err := d.Identify()
+	if err != nil {
+		return err
+	}
+	if got != role {
+		return fmt.Errorf("device %s is a %s, want a %s", model, got, role)
+	}
+	if err := d.Configure(cfg); err != nil {
+		return err
+	}
+	return d.Arm()
+}
+
+// A DeviceSender is a BatchSender driving a hardware source. It also
+// implements Sender, provided the source is configured with at most three
+// intensity levels.
+type DeviceSender struct {
+	*Device
+	levels int
+}
+
+// NewDeviceSender returns a DeviceSender driving the source at the other end of
+// conn, after configuring it with cfg and arming it.
+func NewDeviceSender(conn io.ReadWriter, cfg DeviceConfig) (*DeviceSender, error) {
+	d := NewDevice(conn)
+	if err := d.setUp(RoleSource, cfg); err != nil {
+		return nil, err
+	}
+	return &DeviceSender{Device: d, levels: len(cfg.Intensities)}, nil
+}
+
+// NextBatch implements the BatchSender interface.
+func (ds *DeviceSender) NextBatch(bytes int) (SentBatch, error) {
+	first, fields, err := ds.read(bytes, bytes, bytes, bytes*8, -1)
+	if err != nil {
+		return SentBatch{}, err
+	}
+	for i, level := range fields[2] {
+		if int(level) >= ds.levels {
+			return SentBatch{}, fmt.Errorf("pulse %d has intensity level %d, but only %d are configured", i, level, ds.levels)
+		}
+	}
+	monitored, err := decodeIntensityRanges(fields[3])
+	if err != nil {
+		return SentBatch{}, fmt.Errorf("decoding monitored intensities: %w", err)
+	}
+	if monitored != nil && len(monitored) != ds.levels {
+		return SentBatch{}, fmt.Errorf("got monitored intensities for %d levels, but %d are configured", len(monitored), ds.levels)
+	}
+	return SentBatch{
+		Bits:                 bitmap.NewDense(fields[0], -1),
+		Bases:                bitmap.NewDense(fields[1], -1),
+		Intensities:          fields[2],
+		MonitoredIntensities: monitored,
+		FirstPulse:           first,
+		Sequenced:            true,
+	}, nil
+}
+
+// Next implements the Sender interface.
+func (ds *DeviceSender) Next(bytes int) (bits, bases, lo, med, hi []byte, err error) {
+	return (&legacySender{ds}).Next(bytes)
+}
+
+// A DeviceReceiver is a BatchReceiver driving a hardware detector. It also
+// implements Receiver.
+type DeviceReceiver struct {
+	*Device
+}
+
+// NewDeviceReceiver returns a DeviceReceiver driving the detector at the other
+// end of conn, after configuring it with cfg and arming it.
+func NewDeviceReceiver(conn io.ReadWriter, cfg DeviceConfig) (*DeviceReceiver, error) {
+	d := NewDevice(conn)
+	if err := d.setUp(RoleDetector, cfg); err != nil {
+		return nil, err
+	}
+	return &DeviceReceiver{Device: d}, nil
+}
+
+// NextBatch implements the BatchReceiver interface.
+func (dr *DeviceReceiver) NextBatch(bytes int) (ReceivedBatch, error) {
+	first, fields, err := dr.read(bytes, bytes, bytes, bytes, bytes)
+	if err != nil {
+		return ReceivedBatch{}, err
+	}
+	return ReceivedBatch{
+		Bits:       bitmap.NewDense(fields[0], -1),
+		Bases:      bitmap.NewDense(fields[1], -1),
+		Dropped:    bitmap.NewDense(fields[2], -1),
+		Detector:   &DetectorEvents{DoubleClicks: fields[3]},
+		FirstPulse: first,
+		Sequenced:  true,
+	}, nil
+}
+
+// Next implements the Receiver interface.
+func (dr *DeviceReceiver) Next(bytes int) (bits, bases, dropped []byte, err error) {
+	return (&legacyReceiver{dr}).Next(bytes)
+}
+
+func formatFloats(fs ...float64) string {
+	s := make([]string, len(fs))
+	for i, f := range fs {
+		s[i] = strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return strings.Join(s, " ")
+}
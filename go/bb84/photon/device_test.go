@@ -0,0 +1,185 @@
+package photon
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// emulate connects a DeviceSender and DeviceReceiver to an Emulator, closing
+// the connections once the test completes.
+func emulate(t *testing.T, e *Emulator, srcCfg, detCfg DeviceConfig) (*DeviceSender, *DeviceReceiver) {
+	t.Helper()
+	sClient, sServer := net.Pipe()
+	rClient, rServer := net.Pipe()
+	t.Cleanup(func() {
+		sClient.Close()
+		rClient.Close()
+	})
+	go e.ServeSource(sServer)
+	go e.ServeDetector(rServer)
+	ds, err := NewDeviceSender(sClient, srcCfg)
+	if err != nil {
+		t.Fatalf("NewDeviceSender: %v", err)
+	}
+	dr, err := NewDeviceReceiver(rClient, detCfg)
+	if err != nil {
+		t.Fatalf("NewDeviceReceiver: %v", err)
+	}
+	return ds, dr
+}
+
+func TestDeviceEmulator(t *testing.T) {
+	opts := SimulatedChannelOpts{
+		PMain:       0.5,
+		MuLo:        0.1,
+		MuMed:       0.2,
+		MuHi:        0.5,
+		PLo:         0.3,
+		PMed:        0.3,
+		PHi:         0.4,
+		SendSeed:    1,
+		ReceiveSeed: 2,
+		Link:        &FiberLink{DetectorEfficiency: 0.5, DarkCountProb: 0.01},
+		Detector:    &Detector{DeadTime: 2},
+	}
+	wantSS, wantSR := NewSimulatedChannel(opts)
+	ds, dr := emulate(t, NewEmulator(EmulatorOpts{Channel: opts}),
+		DeviceConfig{
+			PMain:       opts.PMain,
+			Intensities: []float64{opts.MuLo, opts.MuMed, opts.MuHi},
+			Probs:       []float64{opts.PLo, opts.PMed, opts.PHi},
+		},
+		DeviceConfig{PMain: opts.PMain})
+
+	darkCounts := 0
+	for i, n := range []int{4, 16, 7} {
+		want := make([][]byte, 9)
+		want[0], want[1], want[2], want[3], want[4], _ = wantSS.Next(n)
+		want[5], want[6], want[7], _ = wantSR.Next(n)
+		want[8] = wantSR.DetectorEvents().DoubleClicks
+		darkCounts += wantSR.DetectorEvents().DarkCounts
+
+		// The legacy interface must agree with the simulated channel exactly.
+		got := make([][]byte, 9)
+		var err error
+		got[0], got[1], got[2], got[3], got[4], err = ds.Next(n)
+		if err != nil {
+			t.Fatalf("sending: %v", err)
+		}
+		received, err := dr.NextBatch(n)
+		if err != nil {
+			t.Fatalf("receiving: %v", err)
+		}
+		got[5], got[6], got[7] = received.Bits.Data(), received.Bases.Data(), received.Dropped.Data()
+		got[8] = received.Detector.DoubleClicks
+		for j := range want {
+			if !bytes.Equal(got[j], want[j]) {
+				t.Errorf("batch %d, field %d: got %v, want %v", i, j, got[j], want[j])
+			}
+		}
+		if want := wantSR.FirstPulse(); !received.Sequenced || received.FirstPulse != want {
+			t.Errorf("batch %d: got sequence (%v, %d), want (true, %d)", i, received.Sequenced, received.FirstPulse, want)
+		}
+	}
+
+	health, err := dr.Health()
+	if err != nil {
+		t.Fatalf("Health: %v", err)
+	}
+	if want := strconv.Itoa(darkCounts); health["armed"] != "1" || health["pulses"] != "216" || health["dark_counts"] != want {
+		t.Errorf("got health %v, want armed=1 pulses=216 dark_counts=%s", health, want)
+	}
+	if err := dr.Disarm(); err != nil {
+		t.Fatalf("Disarm: %v", err)
+	}
+	if _, err := dr.NextBatch(1); !errors.Is(err, ErrDevice) {
+		t.Errorf("reading a disarmed device: got error %v, want ErrDevice", err)
+	}
+}
+
+func TestDeviceMonitoredIntensities(t *testing.T) {
+	cfg := DeviceConfig{PMain: 0.5, Intensities: []float64{0.1, 0.2, 0.5}, Probs: []float64{0.3, 0.3, 0.4}}
+	want := []IntensityRange{{Min: 0.1, Max: 0.1}, {Min: 0.2, Max: 0.2}, {Min: 0.5, Max: 0.5}}
+	for _, monitor := range []bool{false, true} {
+		e := NewEmulator(EmulatorOpts{Channel: SimulatedChannelOpts{SendSeed: 1, ReceiveSeed: 2}, MonitorIntensities: monitor})
+		ds, _ := emulate(t, e, cfg, DeviceConfig{PMain: 0.5})
+
+		// Monitored intensities should survive being passed on by the remote
+		// protocol, too.
+		client, server := net.Pipe()
+		defer client.Close()
+		go ServeSender(server, ds)
+		rs, err := NewRemoteSender(client)
+		if err != nil {
+			t.Fatalf("NewRemoteSender: %v", err)
+		}
+		b, err := rs.NextBatch(4)
+		if err != nil {
+			t.Fatalf("sending: %v", err)
+		}
+		if monitor && !reflect.DeepEqual(b.MonitoredIntensities, want) {
+			t.Errorf("got monitored intensities %v, want %v", b.MonitoredIntensities, want)
+		}
+		if !monitor && b.MonitoredIntensities != nil {
+			t.Errorf("got monitored intensities %v from an unmonitored source", b.MonitoredIntensities)
+		}
+	}
+}
+
+func TestDeviceErrors(t *testing.T) {
+	src := DeviceConfig{PMain: 0.5, Intensities: []float64{0.1, 0.2, 0.5}, Probs: []float64{0.3, 0.3, 0.4}}
+	det := DeviceConfig{PMain: 0.5}
+	tcs := []struct {
+		name string
+		src  DeviceConfig
+		det  DeviceConfig
+		swap bool
+	}{
+		{name: "swapped roles", src: src, det: det, swap: true},
+		{name: "bad bias", src: DeviceConfig{PMain: 1, Intensities: src.Intensities, Probs: src.Probs}, det: det},
+		{name: "detector intensities", src: src, det: src},
+		{name: "missing probs", src: DeviceConfig{PMain: 0.5, Intensities: src.Intensities}, det: det},
+		{name: "unsorted intensities", src: DeviceConfig{PMain: 0.5, Intensities: []float64{0.2, 0.1, 0.5}, Probs: src.Probs}, det: det},
+		{name: "negative intensity", src: DeviceConfig{PMain: 0.5, Intensities: []float64{-0.1, 0.2, 0.5}, Probs: src.Probs}, det: det},
+		{name: "probs sum below one", src: DeviceConfig{PMain: 0.5, Intensities: src.Intensities, Probs: []float64{0.3, 0.3, 0.3}}, det: det},
+		{name: "negative prob", src: DeviceConfig{PMain: 0.5, Intensities: src.Intensities, Probs: []float64{-0.1, 0.7, 0.4}}, det: det},
+		{name: "too many levels", src: DeviceConfig{PMain: 0.5, Intensities: make([]float64, 257), Probs: make([]float64, 257)}, det: det},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			e := NewEmulator(EmulatorOpts{})
+			sClient, sServer := net.Pipe()
+			rClient, rServer := net.Pipe()
+			defer sClient.Close()
+			defer rClient.Close()
+			if tc.swap {
+				sServer, rServer = rServer, sServer
+			}
+			go e.ServeSource(sServer)
+			go e.ServeDetector(rServer)
+			_, sErr := NewDeviceSender(sClient, tc.src)
+			_, rErr := NewDeviceReceiver(rClient, tc.det)
+			if sErr == nil && rErr == nil {
+				t.Errorf("devices set up without error")
+			}
+		})
+	}
+
+	t.Run("read before arming", func(t *testing.T) {
+		e := NewEmulator(EmulatorOpts{})
+		client, server := net.Pipe()
+		defer client.Close()
+		go e.ServeSource(server)
+		ds, err := NewDeviceSender(client, src)
+		if err != nil {
+			t.Fatalf("NewDeviceSender: %v", err)
+		}
+		if _, err := ds.NextBatch(1); !errors.Is(err, ErrDevice) {
+			t.Errorf("got error %v, want ErrDevice", err)
+		}
+	})
+}
@@ -0,0 +1,259 @@
+package photon
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// EmulatorOpts packages together the parameters of an Emulator.
+type EmulatorOpts struct {
+	// Model is the model the emulated devices identify themselves as. Defaults
+	// to "emulator".
+	Model string
+
+	// Channel describes the simulated channel between the emulated source and
+	// detector. Its basis bias, intensities, and their probabilities are
+	// ignored, and are instead taken from the devices' configuration.
+	Channel SimulatedChannelOpts
+
+	// MonitorIntensities, if set, has the emulated source report monitored
+	// intensities with every batch, exactly as configured.
+	MonitorIntensities bool
+}
+
+// An Emulator emulates the device side of a source and detector pair, as
+// driven by a DeviceSender and DeviceReceiver, on top of a simulated channel.
+// As with the simulated channel, the source must be read before the detector.
+//
+// The simulated channel is created on the first READ, and so both devices must
+// be armed before either is read. Devices cannot be reconfigured thereafter.
+type Emulator struct {
+	opts EmulatorOpts
+
+	mu               sync.Mutex
+	source, detector emulatedDevice
+	ss               *SimulatedSender
+	sr               *SimulatedReceiver
+}
+
+type emulatedDevice struct {
+	role   string
+	cfg    DeviceConfig
+	armed  bool
+	pulses uint64
+
+	// Only tracked by detectors.
+	darkCounts, afterpulses int
+}
+
+// NewEmulator returns an Emulator configured by opts.
+func NewEmulator(opts EmulatorOpts) *Emulator {
+	if opts.Model == "" {
+		opts.Model = "emulator"
+	}
+	return &Emulator{
+		opts:     opts,
+		source:   emulatedDevice{role: RoleSource},
+		detector: emulatedDevice{role: RoleDetector},
+	}
+}
+
+// ServeSource emulates the source to a DeviceSender at the other end of conn,
+// until conn is closed.
+func (e *Emulator) ServeSource(conn io.ReadWriter) error {
+	return e.serve(conn, &e.source)
+}
+
+// ServeDetector emulates the detector to a DeviceReceiver at the other end of
+// conn, until conn is closed.
+func (e *Emulator) ServeDetector(conn io.ReadWriter) error {
+	return e.serve(conn, &e.detector)
+}
+
+func (e *Emulator) serve(conn io.ReadWriter, d *emulatedDevice) error {
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("reading command: %w", err)
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		reply, batch, err := e.handle(d, args[0], args[1:])
+		if err != nil {
+			reply = "ERR " + err.Error()
+		} else if reply == "" {
+			reply = "OK"
+		} else {
+			reply = "OK " + reply
+		}
+		if _, err := io.WriteString(conn, reply+"\n"); err != nil {
+			return err
+		}
+		if batch != nil {
+			if err := writeRecord(conn, batch...); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handle executes a single command on behalf of d, returning the result to
+// reply with, and the fields of any batch to follow it.
+func (e *Emulator) handle(d *emulatedDevice, cmd string, args []string) (string, [][]byte, error) {
+	if cmd == "READ" {
+		return e.read(d, args)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch cmd {
+	case "*IDN?":
+		return d.role + " " + e.opts.Model, nil, nil
+	case "BIAS", "INT", "PROB":
+		if d.armed || e.ss != nil {
+			return "", nil, errors.New("cannot reconfigure an armed device")
+		}
+		if d.role == RoleDetector && cmd != "BIAS" {
+			return "", nil, fmt.Errorf("%s is not supported by detectors", cmd)
+		}
+		fs, err := parseFloats(args)
+		if err != nil {
+			return "", nil, err
+		}
+		switch cmd {
+		case "BIAS":
+			if len(fs) != 1 {
+				return "", nil, fmt.Errorf("got %d arguments, want 1", len(fs))
+			}
+			d.cfg.PMain = fs[0]
+		case "INT":
+			d.cfg.Intensities = fs
+		case "PROB":
+			d.cfg.Probs = fs
+		}
+		return "", nil, nil
+	case "ARM":
+		if err := d.cfg.validate(d.role); err != nil {
+			return "", nil, err
+		}
+		d.armed = true
+		return "", nil, nil
+	case "DISARM":
+		d.armed = false
+		return "", nil, nil
+	case "HEALTH?":
+		armed := 0
+		if d.armed {
+			armed = 1
+		}
+		health := fmt.Sprintf("armed=%d pulses=%d", armed, d.pulses)
+		if d.role == RoleDetector {
+			health += fmt.Sprintf(" dark_counts=%d afterpulses=%d", d.darkCounts, d.afterpulses)
+		}
+		return health, nil, nil
+	}
+	return "", nil, fmt.Errorf("unrecognized command %q", cmd)
+}
+
+func (e *Emulator) read(d *emulatedDevice, args []string) (string, [][]byte, error) {
+	if len(args) != 1 {
+		return "", nil, fmt.Errorf("got %d arguments, want 1", len(args))
+	}
+	bytes, err := strconv.Atoi(args[0])
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid batch size %q", args[0])
+	}
+	if err := checkBatchBytes(bytes); err != nil {
+		return "", nil, err
+	}
+	ss, sr, err := e.channel(d)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// The simulated receiver blocks until the sender has sent, so the channel
+	// must be used without holding the lock.
+	var first uint64
+	var fields [][]byte
+	var events DetectorEvents
+	if d.role == RoleSource {
+		b, err := ss.NextBatch(bytes)
+		if err != nil {
+			return "", nil, err
+		}
+		var monitored []IntensityRange
+		if e.opts.MonitorIntensities {
+			for _, mu := range d.cfg.Intensities {
+				monitored = append(monitored, IntensityRange{Min: mu, Max: mu})
+			}
+		}
+		first = b.FirstPulse
+		fields = [][]byte{b.Bits.Data(), b.Bases.Data(), b.Intensities, encodeIntensityRanges(monitored)}
+	} else {
+		bits, bases, dropped, err := sr.Next(bytes)
+		if err != nil {
+			return "", nil, err
+		}
+		events = sr.DetectorEvents()
+		doubles := events.DoubleClicks
+		if doubles == nil {
+			doubles = make([]byte, bytes)
+		}
+		first = sr.FirstPulse()
+		fields = [][]byte{bits, bases, dropped, doubles}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	d.pulses += uint64(bytes * 8)
+	d.darkCounts += events.DarkCounts
+	d.afterpulses += events.Afterpulses
+	return strconv.FormatUint(first, 10), fields, nil
+}
+
+// channel returns the simulated channel underlying the emulated devices,
+// creating it if need be.
+func (e *Emulator) channel(d *emulatedDevice) (*SimulatedSender, *SimulatedReceiver, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !d.armed {
+		return nil, nil, errors.New("device is not armed")
+	}
+	if e.ss != nil {
+		return e.ss, e.sr, nil
+	}
+	if !e.source.armed || !e.detector.armed {
+		return nil, nil, errors.New("both source and detector must be armed before the first read")
+	}
+	opts := e.opts.Channel
+	src := e.source.cfg
+	opts.PMain = src.PMain
+	opts.Intensities, opts.IntensityProbs = src.Intensities, src.Probs
+	e.ss, e.sr = NewSimulatedChannel(opts)
+	// The simulated channel assumes both ends share a basis bias, but real
+	// devices are configured independently.
+	e.sr.pMain = e.detector.cfg.PMain
+	return e.ss, e.sr, nil
+}
+
+func parseFloats(args []string) ([]float64, error) {
+	fs := make([]float64, len(args))
+	for i, a := range args {
+		f, err := strconv.ParseFloat(a, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid argument %q", a)
+		}
+		fs[i] = f
+	}
+	return fs, nil
+}
@@ -0,0 +1,132 @@
+package photon
+
+import (
+	"math/rand"
+
+	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+)
+
+// A Pulse describes the quantum state of a single pulse in transit between a
+// sender and a receiver.
+type Pulse struct {
+	// Bit and Basis are the bit value and basis the pulse's photons encode. As
+	// elsewhere in this package, a false Basis denotes the main basis.
+	Bit, Basis bool
+
+	// Photons is the number of photons in the pulse.
+	Photons int
+}
+
+// An Eavesdropper attacks a simulated quantum channel, sitting between the
+// sender and the physical link.
+type Eavesdropper interface {
+	// Intercept acts upon a batch of pulses in transit. It returns the pulses
+	// to forward on to the receiver, and a bitmask of the pulses whose bit
+	// value the eavesdropper has learned. Eavesdroppers are assumed able to
+	// store photons until the sender and receiver have announced their bases,
+	// so a bit is only counted as known if it would be after that announcement.
+	Intercept(pulses []Pulse, r *rand.Rand) (forward []Pulse, known bitmap.Dense)
+}
+
+// EveReport summarizes the information an Eavesdropper has obtained over the
+// lifetime of a simulated channel.
+type EveReport struct {
+	// Known is the number of pulses whose bit value the eavesdropper learned.
+	Known int
+
+	// RawKey is the number of pulses the receiver detected with both parties
+	// using the main basis, i.e. the size of the raw key before reconciliation
+	// and privacy amplification. RawKeyKnown is the number of those whose bit
+	// value the eavesdropper learned.
+	RawKey      int
+	RawKeyKnown int
+}
+
+// InterceptResend measures pulses in a uniformly random basis and resends her
+// result, in her basis, to the receiver. Measuring in the wrong basis yields a
+// random bit and disturbs the state, which is what makes the attack visible as
+// an increased error rate.
+type InterceptResend struct {
+	// Fraction is the probability that any given non-empty pulse is
+	// intercepted. Fraction 1 is a full intercept-resend attack.
+	Fraction float64
+}
+
+// Intercept implements the Eavesdropper interface.
+func (ir InterceptResend) Intercept(pulses []Pulse, r *rand.Rand) ([]Pulse, bitmap.Dense) {
+	forward := make([]Pulse, len(pulses))
+	known := bitmap.Empty()
+	for i, p := range pulses {
+		forward[i] = p
+		if p.Photons == 0 || r.Float64() >= ir.Fraction {
+			known.AppendBit(false)
+			continue
+		}
+		basis := r.Float64() < 0.5
+		bit := p.Bit
+		if basis != p.Basis {
+			bit = r.Float64() < 0.5
+		}
+		forward[i] = Pulse{Bit: bit, Basis: basis, Photons: p.Photons}
+		known.AppendBit(basis == p.Basis)
+	}
+	return forward, known
+}
+
+// PhotonNumberSplitting performs a photon-number splitting attack: she
+// measures the number of photons in each pulse without disturbing its
+// encoding, keeps one photon from every multi-photon pulse and forwards the
+// rest, learning their bits once bases are announced. Single-photon pulses
+// cannot be split, so to avoid introducing errors she blocks them instead.
+// This is precisely the attack decoy states defend against.
+type PhotonNumberSplitting struct {
+	// BlockFraction is the probability that any given single-photon pulse is
+	// blocked.
+	BlockFraction float64
+}
+
+// Intercept implements the Eavesdropper interface.
+func (pns PhotonNumberSplitting) Intercept(pulses []Pulse, r *rand.Rand) ([]Pulse, bitmap.Dense) {
+	forward := make([]Pulse, len(pulses))
+	known := bitmap.Empty()
+	for i, p := range pulses {
+		forward[i] = p
+		switch {
+		case p.Photons > 1:
+			forward[i].Photons--
+			known.AppendBit(true)
+			continue
+		case p.Photons == 1 && r.Float64() < pns.BlockFraction:
+			forward[i].Photons = 0
+		}
+		known.AppendBit(false)
+	}
+	return forward, known
+}
+
+// BeamSplitting diverts a fixed fraction of the channel's photons to herself,
+// e.g. in place of the fiber's own losses, and learns the bit of any pulse
+// from which she captured at least one photon once bases are announced. The
+// attack introduces no errors, and is only visible as loss.
+type BeamSplitting struct {
+	// Tap is the probability that any given photon is diverted.
+	Tap float64
+}
+
+// Intercept implements the Eavesdropper interface.
+func (bs BeamSplitting) Intercept(pulses []Pulse, r *rand.Rand) ([]Pulse, bitmap.Dense) {
+	forward := make([]Pulse, len(pulses))
+	known := bitmap.Empty()
+	for i, p := range pulses {
+		forward[i] = p
+		tapped := 0
+		for j := 0; j < p.Photons; j++ {
+			if r.Float64() < bs.Tap {
+				tapped++
+			}
+		}
+		forward[i].Photons -= tapped
+		known.AppendBit(tapped > 0)
+	}
+	return forward, known
+}
@@ -0,0 +1,113 @@
+package photon
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+)
+
+func TestEavesdroppers(t *testing.T) {
+	pulses := []Pulse{
+		{Bit: true, Basis: false, Photons: 0},
+		{Bit: false, Basis: true, Photons: 1},
+		{Bit: true, Basis: true, Photons: 2},
+		{Bit: false, Basis: false, Photons: 3},
+	}
+	tcs := []struct {
+		name        string
+		eve         Eavesdropper
+		wantPhotons []int
+		wantKnown   string
+	}{
+		{
+			name:        "photon number splitting",
+			eve:         PhotonNumberSplitting{BlockFraction: 1},
+			wantPhotons: []int{0, 0, 1, 2},
+			wantKnown:   "0011",
+		}, {
+			name:        "photon number splitting without blocking",
+			eve:         PhotonNumberSplitting{},
+			wantPhotons: []int{0, 1, 1, 2},
+			wantKnown:   "0011",
+		}, {
+			name:        "beam splitting everything",
+			eve:         BeamSplitting{Tap: 1},
+			wantPhotons: []int{0, 0, 0, 0},
+			wantKnown:   "0111",
+		}, {
+			name:        "beam splitting nothing",
+			eve:         BeamSplitting{},
+			wantPhotons: []int{0, 1, 2, 3},
+			wantKnown:   "0000",
+		}, {
+			name:        "intercepting nothing",
+			eve:         InterceptResend{},
+			wantPhotons: []int{0, 1, 2, 3},
+			wantKnown:   "0000",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			forward, known := tc.eve.Intercept(pulses, rand.New(rand.NewSource(1)))
+			if len(forward) != len(pulses) {
+				t.Fatalf("got %d forwarded pulses, want %d", len(forward), len(pulses))
+			}
+			for i, p := range forward {
+				if p.Photons != tc.wantPhotons[i] {
+					t.Errorf("pulse %d: got %d photons, want %d", i, p.Photons, tc.wantPhotons[i])
+				}
+				if p.Bit != pulses[i].Bit || p.Basis != pulses[i].Basis {
+					t.Errorf("pulse %d: encoding changed from %+v to %+v", i, pulses[i], p)
+				}
+			}
+			want, err := bitmap.FromString(tc.wantKnown)
+			if err != nil {
+				t.Fatalf("bugged test setup: %v", err)
+			}
+			if !bitmap.Equal(known, want) {
+				t.Errorf("got known %v, want %v", known.Data(), want.Data())
+			}
+		})
+	}
+}
+
+func TestInterceptResendDisturbsChannel(t *testing.T) {
+	ss, sr := NewSimulatedChannel(SimulatedChannelOpts{
+		PMain:       0.5,
+		MuLo:        5,
+		MuMed:       5,
+		MuHi:        5,
+		PLo:         0,
+		PMed:        0,
+		PHi:         1,
+		SendSeed:    1,
+		ReceiveSeed: 2,
+		Eve:         InterceptResend{Fraction: 1},
+		EveSeed:     3,
+	})
+	errs, sifted := 0, 0
+	for i := 0; i < 8; i++ {
+		sBits, sBases, _, _, _, err := ss.Next(1024)
+		if err != nil {
+			t.Fatalf("sending: %v", err)
+		}
+		rBits, rBases, dropped, err := sr.Next(1024)
+		if err != nil {
+			t.Fatalf("receiving: %v", err)
+		}
+		matched := bitmap.And(bitmap.Not(bitmap.NewDense(dropped, -1)),
+			bitmap.XNor(bitmap.NewDense(sBases, -1), bitmap.NewDense(rBases, -1)))
+		flips := bitmap.XOr(bitmap.NewDense(sBits, -1), bitmap.NewDense(rBits, -1))
+		errs += bitmap.CountOnes(bitmap.And(flips, matched))
+		sifted += bitmap.CountOnes(matched)
+	}
+	if qber := float64(errs) / float64(sifted); math.Abs(qber-0.25) > 0.02 {
+		t.Errorf("got QBER %f under full intercept-resend, want ~0.25", qber)
+	}
+	report := sr.EveReport()
+	if frac := float64(report.RawKeyKnown) / float64(report.RawKey); math.Abs(frac-0.5) > 0.02 {
+		t.Errorf("Eve knows %f of the raw key, want ~0.5", frac)
+	}
+}
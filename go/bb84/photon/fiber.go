@@ -0,0 +1,106 @@
+package photon
+
+import (
+	"math"
+	"math/rand"
+)
+
+// A FiberLink models the physical channel between a sender and a receiver: an
+// optical fiber terminating in a threshold detector. Its parameters follow the
+// usual decoy-state channel model, see e.g.
+// https://arxiv.org/abs/quant-ph/0411004.
+type FiberLink struct {
+	// LengthKm is the length of the fiber, in kilometers.
+	LengthKm float64
+
+	// AttenuationDBPerKm is the fiber's loss coefficient, e.g. 0.2 dB/km for
+	// standard single-mode fiber at 1550nm.
+	AttenuationDBPerKm float64
+
+	// InsertionLossDB accounts for any fixed losses not proportional to
+	// length, e.g. connectors and the receiver's optics.
+	InsertionLossDB float64
+
+	// DetectorEfficiency is the probability that a photon which reaches the
+	// detector causes a click.
+	DetectorEfficiency float64
+
+	// DarkCountProb is the probability that the detector clicks during any
+	// given pulse in the absence of any photons.
+	DarkCountProb float64
+
+	// Misalignment is the probability that a photon detected in the correct
+	// basis nonetheless yields the wrong bit, e.g. due to polarization drift.
+	Misalignment float64
+}
+
+// Transmittance returns the overall probability that a single photon emitted by
+// the sender causes a click at the receiver, accounting for fiber loss,
+// insertion loss and detector efficiency.
+func (f FiberLink) Transmittance() float64 {
+	lossDB := f.LengthKm*f.AttenuationDBPerKm + f.InsertionLossDB
+	return math.Pow(10, -lossDB/10) * f.DetectorEfficiency
+}
+
+// DetectionProb returns the probability that a pulse with mean photon number mu
+// causes a click, i.e. the gain Q_mu.
+func (f FiberLink) DetectionProb(mu float64) float64 {
+	return 1 - (1-f.DarkCountProb)*math.Exp(-f.Transmittance()*mu)
+}
+
+// SinglePhotonDetectionProb returns the probability that a pulse of exactly one
+// photon causes a click, i.e. the single-photon yield Y_1.
+func (f FiberLink) SinglePhotonDetectionProb() float64 {
+	return 1 - (1-f.DarkCountProb)*(1-f.Transmittance())
+}
+
+// SinglePhotonErrorProb returns the probability that a click caused by a pulse
+// of exactly one photon yields the wrong bit when both parties used the same
+// basis, i.e. e_1.
+func (f FiberLink) SinglePhotonErrorProb() float64 {
+	eta := f.Transmittance()
+	pErr := f.Misalignment*eta + 0.5*f.DarkCountProb*(1-eta)
+	return pErr / f.SinglePhotonDetectionProb()
+}
+
+// ErrorProb returns the probability that a click caused by a pulse with mean
+// photon number mu yields the wrong bit when both parties used the same basis,
+// i.e. the QBER E_mu. Clicks caused purely by dark counts yield random bits.
+func (f FiberLink) ErrorProb(mu float64) float64 {
+	pNoPhotons := math.Exp(-f.Transmittance() * mu)
+	pErr := f.Misalignment*(1-pNoPhotons) + 0.5*f.DarkCountProb*pNoPhotons
+	return pErr / f.DetectionProb(mu)
+}
+
+// transmit simulates sending a pulse of the given number of photons down f,
+// returning whether the detector clicked, and if so whether it registered the
+// wrong bit.
+func (f FiberLink) transmit(photons int, r *rand.Rand) (click, flip bool) {
+	eta := f.Transmittance()
+	arrived := 0
+	for i := 0; i < photons; i++ {
+		if r.Float64() < eta {
+			arrived++
+		}
+	}
+	dark := r.Float64() < f.DarkCountProb
+	switch {
+	case arrived > 0:
+		return true, r.Float64() < f.Misalignment
+	case dark:
+		return true, r.Float64() < 0.5
+	}
+	return false, false
+}
+
+// poisson draws a Poisson-distributed value with mean mu from r, via Knuth's
+// algorithm. That is only efficient for small mu, which for attenuated laser
+// pulses is the case of interest.
+func poisson(r *rand.Rand, mu float64) int {
+	l := math.Exp(-mu)
+	k := 0
+	for p := r.Float64(); p > l; p *= r.Float64() {
+		k++
+	}
+	return k
+}
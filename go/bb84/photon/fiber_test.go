@@ -0,0 +1,88 @@
+package photon
+
+import (
+	"math"
+	"testing"
+
+	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+)
+
+func TestFiberLinkTransmittance(t *testing.T) {
+	f := FiberLink{
+		LengthKm:           50,
+		AttenuationDBPerKm: 0.2,
+		InsertionLossDB:    2,
+		DetectorEfficiency: 0.5,
+	}
+	// 12dB of loss leaves 10^-1.2 of the light, half of which is detected.
+	if got, want := f.Transmittance(), 0.5*math.Pow(10, -1.2); math.Abs(got-want) > 1e-12 {
+		t.Errorf("Transmittance() == %g, want %g", got, want)
+	}
+	if got := f.DetectionProb(0); got != 0 {
+		t.Errorf("DetectionProb(0) == %g without dark counts, want 0", got)
+	}
+	f.DarkCountProb = 1e-3
+	if got := f.ErrorProb(0); math.Abs(got-0.5) > 1e-12 {
+		t.Errorf("ErrorProb(0) == %g, want 0.5 for pure dark counts", got)
+	}
+}
+
+func TestSimulatedFiberLink(t *testing.T) {
+	link := &FiberLink{
+		LengthKm:           10,
+		AttenuationDBPerKm: 0.2,
+		InsertionLossDB:    1,
+		DetectorEfficiency: 0.6,
+		DarkCountProb:      0.01,
+		Misalignment:       0.03,
+	}
+	opts := SimulatedChannelOpts{
+		PMain:       0.5,
+		MuLo:        0.1,
+		MuMed:       0.5,
+		MuHi:        0.9,
+		PLo:         0.3,
+		PMed:        0.3,
+		PHi:         0.4,
+		SendSeed:    3,
+		ReceiveSeed: 4,
+		Link:        link,
+	}
+	ss, sr := NewSimulatedChannel(opts)
+	var sent, received, matchedDet, errs [3]int
+	for i := 0; i < 20; i++ {
+		bits, bases, lo, med, hi, err := ss.Next(1 << 10)
+		if err != nil {
+			t.Fatalf("sending: %v", err)
+		}
+		rBits, rBases, dropped, err := sr.Next(1 << 10)
+		if err != nil {
+			t.Fatalf("receiving: %v", err)
+		}
+		masks := []bitmap.Dense{
+			bitmap.NewDense(lo, -1), bitmap.NewDense(med, -1), bitmap.NewDense(hi, -1)}
+		detected := bitmap.Not(bitmap.NewDense(dropped, -1))
+		matched := bitmap.XNor(bitmap.NewDense(bases, -1), bitmap.NewDense(rBases, -1))
+		wrong := bitmap.XOr(bitmap.NewDense(bits, -1), bitmap.NewDense(rBits, -1))
+		for k, m := range masks {
+			sent[k] += bitmap.CountOnes(m)
+			m = bitmap.And(m, detected)
+			received[k] += bitmap.CountOnes(m)
+			m = bitmap.And(m, matched)
+			matchedDet[k] += bitmap.CountOnes(m)
+			errs[k] += bitmap.CountOnes(bitmap.And(m, wrong))
+		}
+	}
+	for k, mu := range []float64{opts.MuLo, opts.MuMed, opts.MuHi} {
+		q := link.DetectionProb(mu)
+		n := float64(sent[k])
+		if got, sigma := float64(received[k]), math.Sqrt(n*q*(1-q)); math.Abs(got-n*q) > 5*sigma {
+			t.Errorf("mu=%g: detected %g of %g pulses, want %g +/- %g", mu, got, n, n*q, 5*sigma)
+		}
+		e := link.ErrorProb(mu)
+		m := float64(matchedDet[k])
+		if got, sigma := float64(errs[k]), math.Sqrt(m*e*(1-e)); math.Abs(got-m*e) > 5*sigma {
+			t.Errorf("mu=%g: %g errors in %g matched detections, want %g +/- %g", mu, got, m, m*e, 5*sigma)
+		}
+	}
+}
@@ -25,3 +25,12 @@ type Receiver interface {
 	//    at all.
 	Next(bytes int) (bits, bases, dropped []byte, err error)
 }
+
+// A Sequencer is a Sender or Receiver which tracks the position of each batch
+// within the overall stream of pulses, allowing peers to verify that their
+// batches are made up of the same pulses.
+type Sequencer interface {
+	// FirstPulse returns the index, within the overall stream of pulses, of
+	// the first pulse in the batch most recently returned from Next.
+	FirstPulse() uint64
+}
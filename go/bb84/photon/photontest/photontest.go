@@ -0,0 +1,280 @@
+// Package photontest provides a statistical conformance check for
+// photon.Sender/Receiver implementations.
+package photontest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/alan-christopher/bb84/go/bb84"
+	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+	"github.com/alan-christopher/bb84/go/bb84/photon"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+const (
+	DefaultBatches    = 16
+	DefaultBatchBytes = 1 << 12
+	DefaultAlpha      = 1e-3
+)
+
+// Opts describes how a Sender/Receiver pair is expected to behave, and how
+// thoroughly to check it.
+type Opts struct {
+	// PMain is the probability with which both the sender and receiver are
+	// expected to choose the main basis for any given pulse.
+	PMain float64
+
+	// PulseAttrs describes the intensities the sender is expected to prepare
+	// pulses at, and with what probabilities.
+	PulseAttrs bb84.PulseAttrs
+
+	// Link models the physical channel between sender and receiver, from which
+	// the expected drop and error rates of each intensity are derived. If nil,
+	// the channel is expected to be lossless and noiseless, i.e. a pulse is
+	// only dropped if it contains no photons. Note that detector effects beyond
+	// those Link models, e.g. dead time, will skew those rates.
+	Link *photon.FiberLink
+
+	// Batches and BatchBytes specify how many batches to pull from the pair,
+	// and of what size. They default to DefaultBatches and DefaultBatchBytes.
+	Batches, BatchBytes int
+
+	// Alpha is the family-wise significance level of the hypothesis tests, i.e.
+	// the probability that a conforming pair is nonetheless reported as
+	// failing. Defaults to DefaultAlpha.
+	Alpha float64
+}
+
+// A Result records the outcome of one hypothesis test, of whether Successes
+// out of Trials is consistent with a binomial distribution with success
+// probability Want.
+type Result struct {
+	Name      string
+	Trials    int
+	Successes int
+	Want      float64
+	PValue    float64
+}
+
+// A Report holds the results of every hypothesis test run by Check.
+type Report struct {
+	Results []Result
+
+	// Threshold is the p-value below which an individual test is deemed to
+	// have failed, i.e. Alpha after Bonferroni correction.
+	Threshold float64
+}
+
+// Check runs a Sender/Receiver pair through a battery of conformance checks.
+// It verifies that every batch has the requested size and well-formed
+// intensity masks, and uses two-sided binomial tests to check:
+//   - the basis bias of both sender and receiver against PMain
+//   - the proportion of pulses sent at each intensity against PulseAttrs
+//   - the drop rate of each intensity against the Poisson expectation
+//   - the error rate of each intensity when bases match, and of all pulses
+//     when they do not, which should be 1/2
+//
+// The returned error describes every failed check, and is nil if there were
+// none.
+func Check(s photon.Sender, r photon.Receiver, opts Opts) (*Report, error) {
+	if opts.Batches == 0 {
+		opts.Batches = DefaultBatches
+	}
+	if opts.BatchBytes == 0 {
+		opts.BatchBytes = DefaultBatchBytes
+	}
+	if opts.Alpha == 0 {
+		opts.Alpha = DefaultAlpha
+	}
+	link := opts.Link
+	if link == nil {
+		link = &photon.FiberLink{DetectorEfficiency: 1}
+	}
+
+	var failures []string
+	var t tally
+	for i := 0; i < opts.Batches; i++ {
+		b, err := nextBatch(s, r, opts.BatchBytes)
+		if err != nil {
+			return nil, fmt.Errorf("batch %d: %w", i, err)
+		}
+		if errs := b.validate(opts.BatchBytes); len(errs) > 0 {
+			for _, err := range errs {
+				failures = append(failures, fmt.Sprintf("batch %d: %v", i, err))
+			}
+			continue
+		}
+		t.add(b)
+	}
+
+	pa := opts.PulseAttrs
+	intensities := []struct {
+		name   string
+		mu, p  float64
+		counts *counts
+	}{
+		{"lo", pa.MuLo, pa.ProbLo, &t.lo},
+		{"med", pa.MuMed, pa.ProbMed, &t.med},
+		{"hi", pa.MuHi, pa.ProbHi, &t.hi},
+	}
+	report := &Report{}
+	test := func(name string, trials, successes int, want float64) {
+		report.Results = append(report.Results, Result{
+			Name:      name,
+			Trials:    trials,
+			Successes: successes,
+			Want:      want,
+			PValue:    binomialTest(trials, successes, want),
+		})
+	}
+	test("sender main basis", t.pulses, t.sendMain, opts.PMain)
+	test("receiver main basis", t.pulses, t.receiveMain, opts.PMain)
+	for _, in := range intensities {
+		c := in.counts
+		test(in.name+" intensity", t.pulses, c.pulses, in.p)
+		test(in.name+" drops", c.pulses, c.drops, 1-link.DetectionProb(in.mu))
+		test(in.name+" matched basis errors", c.matched, c.matchedErrs, link.ErrorProb(in.mu))
+	}
+	test("mismatched basis errors", t.mismatched, t.mismatchedErrs, 0.5)
+
+	report.Threshold = opts.Alpha / float64(len(report.Results))
+	for _, res := range report.Results {
+		if res.PValue < report.Threshold {
+			failures = append(failures, fmt.Sprintf("%s: got %d of %d, want rate %g (p == %g)",
+				res.Name, res.Successes, res.Trials, res.Want, res.PValue))
+		}
+	}
+	if len(failures) > 0 {
+		return report, errors.New(strings.Join(failures, "\n"))
+	}
+	return report, nil
+}
+
+// A batch holds the results of one call to each of a Sender and Receiver.
+type batch struct {
+	bits, bases, lo, med, hi []byte
+	rBits, rBases, rDropped  []byte
+}
+
+// nextBatch pulls a batch from s and r. Sending happens concurrently, in case
+// the pair is backed by hardware which expects that. If receiving fails,
+// nextBatch returns without waiting on a sender which may never return, and
+// the buffered channel lets the sending goroutine exit whenever it does.
+func nextBatch(s photon.Sender, r photon.Receiver, bytes int) (batch, error) {
+	type sent struct {
+		bits, bases, lo, med, hi []byte
+		err                      error
+	}
+	sendRes := make(chan sent, 1)
+	go func() {
+		var res sent
+		res.bits, res.bases, res.lo, res.med, res.hi, res.err = s.Next(bytes)
+		sendRes <- res
+	}()
+	var b batch
+	var err error
+	b.rBits, b.rBases, b.rDropped, err = r.Next(bytes)
+	if err != nil {
+		return b, fmt.Errorf("receiving: %w", err)
+	}
+	res := <-sendRes
+	if res.err != nil {
+		return b, fmt.Errorf("sending: %w", res.err)
+	}
+	b.bits, b.bases, b.lo, b.med, b.hi = res.bits, res.bases, res.lo, res.med, res.hi
+	return b, nil
+}
+
+// validate returns a description of every way b fails to be a well-formed
+// batch of the given size.
+func (b batch) validate(bytes int) []error {
+	var errs []error
+	fields := []struct {
+		name string
+		data []byte
+	}{
+		{"sent bits", b.bits},
+		{"sent bases", b.bases},
+		{"lo", b.lo},
+		{"med", b.med},
+		{"hi", b.hi},
+		{"received bits", b.rBits},
+		{"received bases", b.rBases},
+		{"dropped", b.rDropped},
+	}
+	for _, f := range fields {
+		if len(f.data) != bytes {
+			errs = append(errs, fmt.Errorf("%s has %d bytes, want %d", f.name, len(f.data), bytes))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	lo := bitmap.NewDense(b.lo, -1)
+	med := bitmap.NewDense(b.med, -1)
+	hi := bitmap.NewDense(b.hi, -1)
+	if n := bitmap.CountOnes(lo) + bitmap.CountOnes(med) + bitmap.CountOnes(hi); n != bytes*8 {
+		errs = append(errs, fmt.Errorf("intensity masks mark %d pulses, want %d", n, bytes*8))
+	}
+	if n := bitmap.CountOnes(bitmap.Or(lo, bitmap.Or(med, hi))); n != bytes*8 {
+		errs = append(errs, fmt.Errorf("intensity masks leave %d pulses unmarked", bytes*8-n))
+	}
+	return errs
+}
+
+// counts tallies the outcomes of pulses of a single intensity.
+type counts struct {
+	pulses      int
+	drops       int
+	matched     int
+	matchedErrs int
+}
+
+// tally accumulates the outcomes of every batch.
+type tally struct {
+	pulses         int
+	sendMain       int
+	receiveMain    int
+	mismatched     int
+	mismatchedErrs int
+	lo, med, hi    counts
+}
+
+func (t *tally) add(b batch) {
+	bases := bitmap.NewDense(b.bases, -1)
+	rBases := bitmap.NewDense(b.rBases, -1)
+	detected := bitmap.Not(bitmap.NewDense(b.rDropped, -1))
+	errs := bitmap.XOr(bitmap.NewDense(b.bits, -1), bitmap.NewDense(b.rBits, -1))
+	matched := bitmap.And(detected, bitmap.XNor(bases, rBases))
+	mismatched := bitmap.And(detected, bitmap.XOr(bases, rBases))
+
+	t.pulses += bases.Size()
+	t.sendMain += bases.Size() - bitmap.CountOnes(bases)
+	t.receiveMain += rBases.Size() - bitmap.CountOnes(rBases)
+	t.mismatched += bitmap.CountOnes(mismatched)
+	t.mismatchedErrs += bitmap.CountOnes(bitmap.And(mismatched, errs))
+	for _, in := range []struct {
+		mask []byte
+		c    *counts
+	}{{b.lo, &t.lo}, {b.med, &t.med}, {b.hi, &t.hi}} {
+		mask := bitmap.NewDense(in.mask, -1)
+		m := bitmap.And(matched, mask)
+		in.c.pulses += bitmap.CountOnes(mask)
+		in.c.drops += bitmap.CountOnes(mask) - bitmap.CountOnes(bitmap.And(detected, mask))
+		in.c.matched += bitmap.CountOnes(m)
+		in.c.matchedErrs += bitmap.CountOnes(bitmap.And(m, errs))
+	}
+}
+
+// binomialTest returns the two-sided p-value of observing successes out of
+// trials, given success probability p.
+func binomialTest(trials, successes int, p float64) float64 {
+	d := distuv.Binomial{N: float64(trials), P: p}
+	k := float64(successes)
+	lower := d.CDF(k)
+	upper := 1 - d.CDF(k-1)
+	return math.Min(1, 2*math.Min(lower, upper))
+}
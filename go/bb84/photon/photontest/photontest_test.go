@@ -0,0 +1,145 @@
+package photontest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/alan-christopher/bb84/go/bb84"
+	"github.com/alan-christopher/bb84/go/bb84/photon"
+)
+
+// shortSender wraps a Sender, truncating the bases it reports.
+type shortSender struct {
+	photon.Sender
+}
+
+func (s shortSender) Next(bytes int) (bits, bases, lo, med, hi []byte, err error) {
+	bits, bases, lo, med, hi, err = s.Sender.Next(bytes)
+	return bits, bases[:len(bases)-1], lo, med, hi, err
+}
+
+func TestCheckCatchesNonConformance(t *testing.T) {
+	pa := bb84.PulseAttrs{
+		MuLo: 0.1, MuMed: 0.2, MuHi: 0.5,
+		ProbLo: 0.3, ProbMed: 0.3, ProbHi: 0.4,
+	}
+	simOpts := photon.SimulatedChannelOpts{
+		PMain:       0.5,
+		MuLo:        pa.MuLo,
+		MuMed:       pa.MuMed,
+		MuHi:        pa.MuHi,
+		PLo:         pa.ProbLo,
+		PMed:        pa.ProbMed,
+		PHi:         pa.ProbHi,
+		SendSeed:    3,
+		ReceiveSeed: 5,
+	}
+	skewed := pa
+	skewed.ProbLo, skewed.ProbHi = 0.35, 0.35
+	tcs := []struct {
+		name    string
+		sim     photon.SimulatedChannelOpts
+		opts    Opts
+		short   bool
+		wantErr string
+	}{
+		{
+			name:    "basis bias",
+			sim:     simOpts,
+			opts:    Opts{PMain: 0.55, PulseAttrs: pa},
+			wantErr: "main basis",
+		}, {
+			name:    "intensity proportions",
+			sim:     simOpts,
+			opts:    Opts{PMain: 0.5, PulseAttrs: skewed},
+			wantErr: "lo intensity",
+		}, {
+			name:    "unexpected loss",
+			sim:     simOpts,
+			opts:    Opts{PMain: 0.5, PulseAttrs: pa, Link: &photon.FiberLink{DetectorEfficiency: 0.9}},
+			wantErr: "hi drops",
+		}, {
+			name:    "unexpected errors",
+			sim:     simOpts,
+			opts:    Opts{PMain: 0.5, PulseAttrs: pa, Link: &photon.FiberLink{DetectorEfficiency: 1, Misalignment: 0.05}},
+			wantErr: "matched basis errors",
+		}, {
+			name:    "batch size",
+			sim:     simOpts,
+			opts:    Opts{PMain: 0.5, PulseAttrs: pa, Batches: 1},
+			short:   true,
+			wantErr: "sent bases has",
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			ss, sr := photon.NewSimulatedChannel(tc.sim)
+			var s photon.Sender = ss
+			if tc.short {
+				s = shortSender{ss}
+			}
+			_, err := Check(s, sr, tc.opts)
+			if err == nil {
+				t.Fatalf("Check passed, want failure mentioning %q", tc.wantErr)
+			}
+			if !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("got error %q, want one mentioning %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// blockingSender never returns from Next until unblock is closed.
+type blockingSender struct {
+	photon.Sender
+	unblock chan struct{}
+}
+
+func (s blockingSender) Next(bytes int) (bits, bases, lo, med, hi []byte, err error) {
+	<-s.unblock
+	return nil, nil, nil, nil, nil, errors.New("unblocked")
+}
+
+// failingReceiver fails every call to Next.
+type failingReceiver struct {
+	photon.Receiver
+}
+
+func (failingReceiver) Next(bytes int) (bits, bases, dropped []byte, err error) {
+	return nil, nil, nil, errors.New("detector offline")
+}
+
+func TestCheckReceiverFailure(t *testing.T) {
+	s := blockingSender{unblock: make(chan struct{})}
+	defer close(s.unblock)
+	_, err := Check(s, failingReceiver{}, Opts{PMain: 0.5, PulseAttrs: bb84.PulseAttrs{
+		MuLo: 0.1, MuMed: 0.2, MuHi: 0.5,
+		ProbLo: 0.3, ProbMed: 0.3, ProbHi: 0.4,
+	}})
+	if err == nil || !strings.Contains(err.Error(), "detector offline") {
+		t.Errorf("Check() == %v, want the receiver's failure", err)
+	}
+}
+
+func TestBinomialTest(t *testing.T) {
+	tcs := []struct {
+		trials, successes int
+		p                 float64
+		wantLo, wantHi    float64
+	}{
+		{trials: 100, successes: 50, p: 0.5, wantLo: 1, wantHi: 1},
+		{trials: 100, successes: 0, p: 0, wantLo: 1, wantHi: 1},
+		{trials: 100, successes: 1, p: 0, wantLo: 0, wantHi: 0},
+		{trials: 0, successes: 0, p: 0.3, wantLo: 1, wantHi: 1},
+		// Two-sided p-value of 60/100 at p == 0.5 is ~0.057.
+		{trials: 100, successes: 60, p: 0.5, wantLo: 0.05, wantHi: 0.06},
+	}
+	for _, tc := range tcs {
+		got := binomialTest(tc.trials, tc.successes, tc.p)
+		if got < tc.wantLo || got > tc.wantHi {
+			t.Errorf("binomialTest(%d, %d, %g) == %g, want in [%g, %g]",
+				tc.trials, tc.successes, tc.p, got, tc.wantLo, tc.wantHi)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package photon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+)
+
+// Recordings are a short magic header identifying whether they hold sent or
+// received batches, and in which version of the format, followed by one record
+// per batch. Each record is a list of fields, each prefixed by its length in
+// bytes as a little-endian uint32.
+//
+// In the current version, a sent batch's record holds the fields bits, bases,
+// intensities, sequence, timestamps, metadata, and monitored intensities; a
+// received batch's holds bits, bases, dropped, sequence, timestamps, metadata,
+// double clicks, and detector counts. Bitmasks are densely packed, intensities
+// hold one byte per pulse, and sequence, monitored intensities, double clicks
+// and detector counts are encoded as in the remote protocol. Timestamps hold
+// each pulse's as a little-endian int64, or are empty if the batch has none.
+// Metadata holds a record of two fields, key and value, per entry, in
+// increasing order of key.
+//
+// Recordings in the first version, whose records hold only the fields the
+// Sender and Receiver interfaces' Next return, may still be replayed.
+var (
+	senderMagicV1   = []byte("bb84snd1")
+	receiverMagicV1 = []byte("bb84rcv1")
+	senderMagic     = []byte("bb84snd2")
+	receiverMagic   = []byte("bb84rcv2")
+)
+
+// A RecordingSender is a BatchSender which records every batch sent by an
+// underlying Sender. It also implements Sender.
+type RecordingSender struct {
+	bs BatchSender
+	w  io.Writer
+}
+
+// NewRecordingSender returns a RecordingSender which sends via s and records to
+// w. s is adapted to a BatchSender as by NewBatchSender, so that sequencing is
+// recorded too.
+func NewRecordingSender(s Sender, w io.Writer) (*RecordingSender, error) {
+	if _, err := w.Write(senderMagic); err != nil {
+		return nil, err
+	}
+	return &RecordingSender{bs: NewBatchSender(s), w: w}, nil
+}
+
+// NextBatch implements the BatchSender interface.
+func (rs *RecordingSender) NextBatch(bytes int) (SentBatch, error) {
+	b, err := rs.bs.NextBatch(bytes)
+	if err != nil {
+		return SentBatch{}, err
+	}
+	if err := writeRecord(rs.w,
+		b.Bits.Data(), b.Bases.Data(), b.Intensities,
+		encodeSequence(b.Sequenced, b.FirstPulse), encodeTimestamps(b.Timestamps), encodeMetadata(b.Metadata),
+		encodeIntensityRanges(b.MonitoredIntensities),
+	); err != nil {
+		return SentBatch{}, err
+	}
+	return b, nil
+}
+
+// Next implements the Sender interface.
+func (rs *RecordingSender) Next(bytes int) (bits, bases, lo, med, hi []byte, err error) {
+	return (&legacySender{rs}).Next(bytes)
+}
+
+// A RecordingReceiver is a BatchReceiver which records every batch received by
+// an underlying Receiver. It also implements Receiver.
+type RecordingReceiver struct {
+	br BatchReceiver
+	w  io.Writer
+}
+
+// NewRecordingReceiver returns a RecordingReceiver which receives via r and
+// records to w. r is adapted to a BatchReceiver as by NewBatchReceiver, so
+// that sequencing and detector events are recorded too.
+func NewRecordingReceiver(r Receiver, w io.Writer) (*RecordingReceiver, error) {
+	if _, err := w.Write(receiverMagic); err != nil {
+		return nil, err
+	}
+	return &RecordingReceiver{br: NewBatchReceiver(r), w: w}, nil
+}
+
+// NextBatch implements the BatchReceiver interface.
+func (rr *RecordingReceiver) NextBatch(bytes int) (ReceivedBatch, error) {
+	b, err := rr.br.NextBatch(bytes)
+	if err != nil {
+		return ReceivedBatch{}, err
+	}
+	doubles, counts := encodeDetectorEvents(b.Detector, bytes)
+	if err := writeRecord(rr.w,
+		b.Bits.Data(), b.Bases.Data(), b.Dropped.Data(),
+		encodeSequence(b.Sequenced, b.FirstPulse), encodeTimestamps(b.Timestamps), encodeMetadata(b.Metadata),
+		doubles, counts,
+	); err != nil {
+		return ReceivedBatch{}, err
+	}
+	return b, nil
+}
+
+// Next implements the Receiver interface.
+func (rr *RecordingReceiver) Next(bytes int) (bits, bases, dropped []byte, err error) {
+	return (&legacyReceiver{rr}).Next(bytes)
+}
+
+// A ReplaySender is a BatchSender which plays back the batches recorded by a
+// RecordingSender. It also implements Sender.
+type ReplaySender struct {
+	r  io.Reader
+	v1 bool
+}
+
+// NewReplaySender returns a ReplaySender reading a recording from r.
+func NewReplaySender(r io.Reader) (*ReplaySender, error) {
+	v, err := readMagic(r, senderMagicV1, senderMagic)
+	if err != nil {
+		return nil, err
+	}
+	return &ReplaySender{r: r, v1: v == 0}, nil
+}
+
+// NextBatch implements the BatchSender interface. It returns io.EOF once the
+// recording is exhausted, and an error if the next recorded batch does not
+// contain the requested number of bytes.
+func (rs *ReplaySender) NextBatch(bytes int) (SentBatch, error) {
+	if rs.v1 {
+		fields, err := readRecord(rs.r, 5, bytes)
+		if err != nil {
+			return SentBatch{}, err
+		}
+		intensities, err := intensitiesFromMasks(bytes*8, fields[2], fields[3], fields[4])
+		if err != nil {
+			return SentBatch{}, fmt.Errorf("replaying batch: %w", err)
+		}
+		return SentBatch{
+			Bits:        bitmap.NewDense(fields[0], -1),
+			Bases:       bitmap.NewDense(fields[1], -1),
+			Intensities: intensities,
+		}, nil
+	}
+	fields, err := readRecord(rs.r, 7, bytes)
+	if err != nil {
+		return SentBatch{}, err
+	}
+	b := SentBatch{
+		Bits:        bitmap.NewDense(fields[0], -1),
+		Bases:       bitmap.NewDense(fields[1], -1),
+		Intensities: fields[2],
+	}
+	if b.Sequenced, b.FirstPulse, err = decodeSequence(fields[3]); err != nil {
+		return SentBatch{}, fmt.Errorf("replaying batch: %w", err)
+	}
+	if b.Timestamps, err = decodeTimestamps(fields[4]); err != nil {
+		return SentBatch{}, fmt.Errorf("replaying batch: %w", err)
+	}
+	if b.Metadata, err = decodeMetadata(fields[5]); err != nil {
+		return SentBatch{}, fmt.Errorf("replaying batch: %w", err)
+	}
+	if b.MonitoredIntensities, err = decodeIntensityRanges(fields[6]); err != nil {
+		return SentBatch{}, fmt.Errorf("replaying batch: decoding monitored intensities: %w", err)
+	}
+	return b, nil
+}
+
+// Next implements the Sender interface, failing as NewLegacySender's Senders
+// do for batches recorded with more than three intensity levels.
+func (rs *ReplaySender) Next(bytes int) (bits, bases, lo, med, hi []byte, err error) {
+	return (&legacySender{rs}).Next(bytes)
+}
+
+// A ReplayReceiver is a BatchReceiver which plays back the batches recorded by
+// a RecordingReceiver. It also implements Receiver.
+type ReplayReceiver struct {
+	r  io.Reader
+	v1 bool
+}
+
+// NewReplayReceiver returns a ReplayReceiver reading a recording from r.
+func NewReplayReceiver(r io.Reader) (*ReplayReceiver, error) {
+	v, err := readMagic(r, receiverMagicV1, receiverMagic)
+	if err != nil {
+		return nil, err
+	}
+	return &ReplayReceiver{r: r, v1: v == 0}, nil
+}
+
+// NextBatch implements the BatchReceiver interface. It returns io.EOF once the
+// recording is exhausted, and an error if the next recorded batch does not
+// contain the requested number of bytes.
+func (rr *ReplayReceiver) NextBatch(bytes int) (ReceivedBatch, error) {
+	n := 8
+	if rr.v1 {
+		n = 3
+	}
+	fields, err := readRecord(rr.r, n, bytes)
+	if err != nil {
+		return ReceivedBatch{}, err
+	}
+	b := ReceivedBatch{
+		Bits:    bitmap.NewDense(fields[0], -1),
+		Bases:   bitmap.NewDense(fields[1], -1),
+		Dropped: bitmap.NewDense(fields[2], -1),
+	}
+	if rr.v1 {
+		return b, nil
+	}
+	if b.Sequenced, b.FirstPulse, err = decodeSequence(fields[3]); err != nil {
+		return ReceivedBatch{}, fmt.Errorf("replaying batch: %w", err)
+	}
+	if b.Timestamps, err = decodeTimestamps(fields[4]); err != nil {
+		return ReceivedBatch{}, fmt.Errorf("replaying batch: %w", err)
+	}
+	if b.Metadata, err = decodeMetadata(fields[5]); err != nil {
+		return ReceivedBatch{}, fmt.Errorf("replaying batch: %w", err)
+	}
+	if b.Detector, err = decodeDetectorEvents(fields[6], fields[7]); err != nil {
+		return ReceivedBatch{}, fmt.Errorf("replaying batch: %w", err)
+	}
+	return b, nil
+}
+
+// Next implements the Receiver interface.
+func (rr *ReplayReceiver) Next(bytes int) (bits, bases, dropped []byte, err error) {
+	return (&legacyReceiver{rr}).Next(bytes)
+}
+
+// writeRecord writes a record of the given fields to w in a single call, so
+// that an empty field never makes for an empty write, which synchronous
+// connections such as net.Pipe's block on until the other end next reads.
+func writeRecord(w io.Writer, fields ...[]byte) error {
+	var buf bytes.Buffer
+	for _, f := range fields {
+		binary.Write(&buf, binary.LittleEndian, uint32(len(f)))
+		buf.Write(f)
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("recording batch: %w", err)
+	}
+	return nil
+}
+
+// readRecord reads a record of n fields from r, checking that the first holds
+// the given number of bytes, unless that number is negative.
+func readRecord(r io.Reader, n, bytes int) ([][]byte, error) {
+	var fields [][]byte
+	for i := 0; i < n; i++ {
+		var fLen uint32
+		if err := binary.Read(r, binary.LittleEndian, &fLen); err != nil {
+			if i == 0 && errors.Is(err, io.EOF) {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("replaying batch: %w", err)
+		}
+		f := make([]byte, fLen)
+		if _, err := io.ReadFull(r, f); err != nil {
+			return nil, fmt.Errorf("replaying batch: %w", err)
+		}
+		fields = append(fields, f)
+	}
+	if bytes >= 0 && len(fields[0]) != bytes {
+		return nil, fmt.Errorf("replaying batch of %d bytes, but %d were requested", len(fields[0]), bytes)
+	}
+	return fields, nil
+}
+
+// readMagic reads a magic header from r, returning the index of the one of
+// want it matches.
+func readMagic(r io.Reader, want ...[]byte) (int, error) {
+	got := make([]byte, len(want[0]))
+	if _, err := io.ReadFull(r, got); err != nil {
+		return 0, fmt.Errorf("reading recording header: %w", err)
+	}
+	for i, w := range want {
+		if bytes.Equal(got, w) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unrecognized recording header %q, want %q", got, want[len(want)-1])
+}
+
+func encodeTimestamps(ts []int64) []byte {
+	vs := make([]uint64, len(ts))
+	for i, t := range ts {
+		vs[i] = uint64(t)
+	}
+	return encodeUint64s(vs...)
+}
+
+func decodeTimestamps(b []byte) ([]int64, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	if len(b)%8 != 0 {
+		return nil, fmt.Errorf("decoding timestamps: got %d bytes, want a multiple of 8", len(b))
+	}
+	vs, err := decodeUint64s(b, len(b)/8)
+	if err != nil {
+		return nil, fmt.Errorf("decoding timestamps: %w", err)
+	}
+	ts := make([]int64, len(vs))
+	for i, v := range vs {
+		ts[i] = int64(v)
+	}
+	return ts, nil
+}
+
+func encodeMetadata(md map[string]string) []byte {
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	for _, k := range keys {
+		writeRecord(&buf, []byte(k), []byte(md[k]))
+	}
+	return buf.Bytes()
+}
+
+func decodeMetadata(b []byte) (map[string]string, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	md := map[string]string{}
+	r := bytes.NewReader(b)
+	for {
+		kv, err := readRecord(r, 2, -1)
+		if errors.Is(err, io.EOF) {
+			return md, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decoding metadata: %w", err)
+		}
+		md[string(kv[0])] = string(kv[1])
+	}
+}
@@ -0,0 +1,244 @@
+package photon
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	ss, sr := NewSimulatedChannel(SimulatedChannelOpts{
+		PMain:       0.5,
+		MuLo:        0.1,
+		MuMed:       0.2,
+		MuHi:        0.5,
+		PLo:         0.3,
+		PMed:        0.3,
+		PHi:         0.4,
+		SendSeed:    1,
+		ReceiveSeed: 2,
+	})
+	var sBuf, rBuf bytes.Buffer
+	rs, err := NewRecordingSender(ss, &sBuf)
+	if err != nil {
+		t.Fatalf("NewRecordingSender: %v", err)
+	}
+	rr, err := NewRecordingReceiver(sr, &rBuf)
+	if err != nil {
+		t.Fatalf("NewRecordingReceiver: %v", err)
+	}
+	type sent struct{ bits, bases, lo, med, hi []byte }
+	type received struct{ bits, bases, dropped []byte }
+	var wantSent []sent
+	var wantReceived []received
+	for _, n := range []int{4, 16, 7} {
+		var s sent
+		var r received
+		s.bits, s.bases, s.lo, s.med, s.hi, err = rs.Next(n)
+		if err != nil {
+			t.Fatalf("sending: %v", err)
+		}
+		r.bits, r.bases, r.dropped, err = rr.Next(n)
+		if err != nil {
+			t.Fatalf("receiving: %v", err)
+		}
+		wantSent = append(wantSent, s)
+		wantReceived = append(wantReceived, r)
+	}
+
+	ps, err := NewReplaySender(&sBuf)
+	if err != nil {
+		t.Fatalf("NewReplaySender: %v", err)
+	}
+	pr, err := NewReplayReceiver(&rBuf)
+	if err != nil {
+		t.Fatalf("NewReplayReceiver: %v", err)
+	}
+	for i, want := range wantSent {
+		var got sent
+		got.bits, got.bases, got.lo, got.med, got.hi, err = ps.Next(len(want.bits))
+		if err != nil {
+			t.Fatalf("replaying sent batch %d: %v", i, err)
+		}
+		for j, pair := range [][2][]byte{{got.bits, want.bits}, {got.bases, want.bases},
+			{got.lo, want.lo}, {got.med, want.med}, {got.hi, want.hi}} {
+			if !bytes.Equal(pair[0], pair[1]) {
+				t.Errorf("sent batch %d, field %d: got %v, want %v", i, j, pair[0], pair[1])
+			}
+		}
+	}
+	for i, want := range wantReceived {
+		var got received
+		got.bits, got.bases, got.dropped, err = pr.Next(len(want.bits))
+		if err != nil {
+			t.Fatalf("replaying received batch %d: %v", i, err)
+		}
+		for j, pair := range [][2][]byte{{got.bits, want.bits}, {got.bases, want.bases},
+			{got.dropped, want.dropped}} {
+			if !bytes.Equal(pair[0], pair[1]) {
+				t.Errorf("received batch %d, field %d: got %v, want %v", i, j, pair[0], pair[1])
+			}
+		}
+	}
+	if _, _, _, _, _, err := ps.Next(4); !errors.Is(err, io.EOF) {
+		t.Errorf("exhausted ReplaySender returned %v, want EOF", err)
+	}
+	if _, _, _, err := pr.Next(4); !errors.Is(err, io.EOF) {
+		t.Errorf("exhausted ReplayReceiver returned %v, want EOF", err)
+	}
+}
+
+// stampedSender adds timestamps, metadata and monitored intensities to every
+// batch of the underlying SimulatedSender.
+type stampedSender struct {
+	*SimulatedSender
+}
+
+func (s stampedSender) NextBatch(bytes int) (SentBatch, error) {
+	b, err := s.SimulatedSender.NextBatch(bytes)
+	for i := range b.Intensities {
+		b.Timestamps = append(b.Timestamps, int64(b.FirstPulse)+int64(i)*1000)
+	}
+	b.Metadata = map[string]string{"laser": "on", "temperature": "21C"}
+	b.MonitoredIntensities = []IntensityRange{{0, 0}, {0.09, 0.11}, {0.19, 0.21}, {0.48, 0.52}}
+	return b, err
+}
+
+func TestRecordAndReplayBatches(t *testing.T) {
+	ss, sr := NewSimulatedChannel(SimulatedChannelOpts{
+		PMain:          0.5,
+		Intensities:    []float64{0, 0.1, 0.2, 0.5},
+		IntensityProbs: []float64{0.1, 0.2, 0.3, 0.4},
+		SendSeed:       1,
+		ReceiveSeed:    2,
+		Link:           &FiberLink{DetectorEfficiency: 0.8, DarkCountProb: 0.05},
+		Detector:       &Detector{DeadTime: 2, AfterpulseProb: 0.1},
+	})
+	var sBuf, rBuf bytes.Buffer
+	rs, err := NewRecordingSender(stampedSender{ss}, &sBuf)
+	if err != nil {
+		t.Fatalf("NewRecordingSender: %v", err)
+	}
+	rr, err := NewRecordingReceiver(sr, &rBuf)
+	if err != nil {
+		t.Fatalf("NewRecordingReceiver: %v", err)
+	}
+	var wantSent []SentBatch
+	var wantReceived []ReceivedBatch
+	for _, n := range []int{4, 16, 7} {
+		s, err := rs.NextBatch(n)
+		if err != nil {
+			t.Fatalf("sending: %v", err)
+		}
+		r, err := rr.NextBatch(n)
+		if err != nil {
+			t.Fatalf("receiving: %v", err)
+		}
+		wantSent = append(wantSent, s)
+		wantReceived = append(wantReceived, r)
+	}
+	if last := wantSent[len(wantSent)-1]; !last.Sequenced || last.FirstPulse == 0 {
+		t.Fatalf("got sent batch sequence (%v, %d), want a sequenced batch after the first", last.Sequenced, last.FirstPulse)
+	}
+
+	ps, err := NewReplaySender(&sBuf)
+	if err != nil {
+		t.Fatalf("NewReplaySender: %v", err)
+	}
+	pr, err := NewReplayReceiver(&rBuf)
+	if err != nil {
+		t.Fatalf("NewReplayReceiver: %v", err)
+	}
+	for i, want := range wantSent {
+		got, err := ps.NextBatch(want.Bits.Size() / 8)
+		if err != nil {
+			t.Fatalf("replaying sent batch %d: %v", i, err)
+		}
+		if !bitmap.Equal(got.Bits, want.Bits) || !bitmap.Equal(got.Bases, want.Bases) ||
+			!bytes.Equal(got.Intensities, want.Intensities) {
+			t.Errorf("sent batch %d: replayed bits/bases/intensities differ from the recorded ones", i)
+		}
+		if got.Sequenced != want.Sequenced || got.FirstPulse != want.FirstPulse {
+			t.Errorf("sent batch %d: got sequence (%v, %d), want (%v, %d)",
+				i, got.Sequenced, got.FirstPulse, want.Sequenced, want.FirstPulse)
+		}
+		if !reflect.DeepEqual(got.Timestamps, want.Timestamps) || !reflect.DeepEqual(got.Metadata, want.Metadata) {
+			t.Errorf("sent batch %d: got timestamps %v and metadata %v, want %v and %v",
+				i, got.Timestamps, got.Metadata, want.Timestamps, want.Metadata)
+		}
+		if !reflect.DeepEqual(got.MonitoredIntensities, want.MonitoredIntensities) {
+			t.Errorf("sent batch %d: got monitored intensities %v, want %v", i, got.MonitoredIntensities, want.MonitoredIntensities)
+		}
+	}
+	for i, want := range wantReceived {
+		got, err := pr.NextBatch(want.Bits.Size() / 8)
+		if err != nil {
+			t.Fatalf("replaying received batch %d: %v", i, err)
+		}
+		if !bitmap.Equal(got.Bits, want.Bits) || !bitmap.Equal(got.Bases, want.Bases) ||
+			!bitmap.Equal(got.Dropped, want.Dropped) {
+			t.Errorf("received batch %d: replayed bits/bases/dropped differ from the recorded ones", i)
+		}
+		if got.Sequenced != want.Sequenced || got.FirstPulse != want.FirstPulse {
+			t.Errorf("received batch %d: got sequence (%v, %d), want (%v, %d)",
+				i, got.Sequenced, got.FirstPulse, want.Sequenced, want.FirstPulse)
+		}
+		if got.Detector == nil || !reflect.DeepEqual(*got.Detector, *want.Detector) {
+			t.Errorf("received batch %d: got detector events %+v, want %+v", i, got.Detector, want.Detector)
+		}
+	}
+	if _, err := ps.NextBatch(4); !errors.Is(err, io.EOF) {
+		t.Errorf("exhausted ReplaySender returned %v, want EOF", err)
+	}
+}
+
+func TestReplayV1(t *testing.T) {
+	var sBuf, rBuf bytes.Buffer
+	sBuf.Write(senderMagicV1)
+	writeRecord(&sBuf, []byte{0xff}, []byte{0x0f}, []byte{0x03}, []byte{0x0c}, []byte{0xf0})
+	rBuf.Write(receiverMagicV1)
+	writeRecord(&rBuf, []byte{0xff}, []byte{0x0f}, []byte{0x01})
+
+	ps, err := NewReplaySender(&sBuf)
+	if err != nil {
+		t.Fatalf("NewReplaySender: %v", err)
+	}
+	sent, err := ps.NextBatch(1)
+	if err != nil {
+		t.Fatalf("replaying sent batch: %v", err)
+	}
+	if want := []uint8{0, 0, 1, 1, 2, 2, 2, 2}; !bytes.Equal(sent.Intensities, want) || sent.Sequenced {
+		t.Errorf("got intensities %v, sequenced %v, want %v, unsequenced", sent.Intensities, sent.Sequenced, want)
+	}
+	pr, err := NewReplayReceiver(&rBuf)
+	if err != nil {
+		t.Fatalf("NewReplayReceiver: %v", err)
+	}
+	received, err := pr.NextBatch(1)
+	if err != nil {
+		t.Fatalf("replaying received batch: %v", err)
+	}
+	if !bitmap.Equal(received.Dropped, bitmap.NewDense([]byte{0x01}, -1)) || received.Detector != nil {
+		t.Errorf("got received batch %+v, want the recorded fields alone", received)
+	}
+}
+
+func TestReplayErrors(t *testing.T) {
+	if _, err := NewReplaySender(bytes.NewReader(receiverMagic)); err == nil {
+		t.Errorf("ReplaySender accepted a receiver recording")
+	}
+	var buf bytes.Buffer
+	buf.Write(receiverMagicV1)
+	writeRecord(&buf, []byte{1, 2}, []byte{3, 4}, []byte{5, 6})
+	pr, err := NewReplayReceiver(&buf)
+	if err != nil {
+		t.Fatalf("NewReplayReceiver: %v", err)
+	}
+	if _, _, _, err := pr.Next(3); err == nil {
+		t.Errorf("ReplayReceiver replayed a 2 byte batch when 3 were requested")
+	}
+}
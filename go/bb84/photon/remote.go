@@ -0,0 +1,320 @@
+package photon
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+)
+
+// The remote protocol extends the recording format to carry whole batches. On
+// connecting, the serving end writes a magic header identifying whether it
+// serves a sender or a receiver. The client then requests each batch by
+// writing its size in bytes, as a little-endian uint32, to which the server
+// responds with a status byte followed by either the batch's record, or a
+// record holding an error message.
+//
+// A sender's record holds the fields bits, bases, intensities, sequence, and
+// monitored intensities; a receiver's holds bits, bases, dropped, sequence,
+// double clicks, and detector counts. Intensities and monitored intensities
+// are encoded as in the device protocol. Sequence is empty for an unsequenced
+// batch, and otherwise holds the index of the batch's first pulse as a
+// little-endian uint64. Double clicks and detector counts are both empty if
+// the batch reports no detector events, and otherwise the latter holds the
+// batch's dark counts, afterpulses, and dead time losses, each as a
+// little-endian uint64.
+const (
+	statusOK    byte = 0
+	statusError byte = 1
+)
+
+// MaxBatchBytes is the largest batch, in bytes, which may be requested over
+// the remote and device protocols. Larger requests fail with an error, rather
+// than have the server allocate however much memory its client asks for.
+const MaxBatchBytes = 1 << 20
+
+var (
+	remoteSenderMagic   = []byte("bb84rsn2")
+	remoteReceiverMagic = []byte("bb84rrc2")
+)
+
+// ServeSender serves s to a RemoteSender at the other end of conn, until conn
+// is closed. s is adapted to a BatchSender as by NewBatchSender, so that
+// sequencing is passed on to the client. Errors from s are passed on to the
+// client, rather than ending the session.
+func ServeSender(conn io.ReadWriter, s Sender) error {
+	if _, err := conn.Write(remoteSenderMagic); err != nil {
+		return err
+	}
+	bs := NewBatchSender(s)
+	return serve(conn, func(bytes int) ([][]byte, error) {
+		b, err := bs.NextBatch(bytes)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{
+			b.Bits.Data(), b.Bases.Data(), b.Intensities,
+			encodeSequence(b.Sequenced, b.FirstPulse), encodeIntensityRanges(b.MonitoredIntensities),
+		}, nil
+	})
+}
+
+// ServeReceiver serves r to a RemoteReceiver at the other end of conn, until
+// conn is closed. r is adapted to a BatchReceiver as by NewBatchReceiver, so
+// that sequencing and detector events are passed on to the client. Errors
+// from r are passed on to the client, rather than ending the session.
+func ServeReceiver(conn io.ReadWriter, r Receiver) error {
+	if _, err := conn.Write(remoteReceiverMagic); err != nil {
+		return err
+	}
+	br := NewBatchReceiver(r)
+	return serve(conn, func(bytes int) ([][]byte, error) {
+		b, err := br.NextBatch(bytes)
+		if err != nil {
+			return nil, err
+		}
+		doubles, counts := encodeDetectorEvents(b.Detector, bytes)
+		return [][]byte{b.Bits.Data(), b.Bases.Data(), b.Dropped.Data(), encodeSequence(b.Sequenced, b.FirstPulse), doubles, counts}, nil
+	})
+}
+
+func serve(conn io.ReadWriter, next func(bytes int) ([][]byte, error)) error {
+	for {
+		var bytes uint32
+		if err := binary.Read(conn, binary.LittleEndian, &bytes); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("reading request: %w", err)
+		}
+		var fields [][]byte
+		err := checkBatchBytes(int(bytes))
+		if err == nil {
+			fields, err = next(int(bytes))
+		}
+		if err != nil {
+			if _, err := conn.Write([]byte{statusError}); err != nil {
+				return err
+			}
+			if err := writeRecord(conn, []byte(err.Error())); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := conn.Write([]byte{statusOK}); err != nil {
+			return err
+		}
+		if err := writeRecord(conn, fields...); err != nil {
+			return err
+		}
+	}
+}
+
+// A RemoteSender is a BatchSender served from another process by
+// ServeSender. It also implements Sender.
+type RemoteSender struct {
+	conn io.ReadWriter
+}
+
+// NewRemoteSender returns a RemoteSender communicating over conn, e.g. a
+// net.Conn to a server calling ServeSender.
+func NewRemoteSender(conn io.ReadWriter) (*RemoteSender, error) {
+	if _, err := readMagic(conn, remoteSenderMagic); err != nil {
+		return nil, err
+	}
+	return &RemoteSender{conn: conn}, nil
+}
+
+// NextBatch implements the BatchSender interface.
+func (rs *RemoteSender) NextBatch(bytes int) (SentBatch, error) {
+	fields, err := request(rs.conn, 5, bytes)
+	if err != nil {
+		return SentBatch{}, err
+	}
+	b := SentBatch{
+		Bits:        bitmap.NewDense(fields[0], -1),
+		Bases:       bitmap.NewDense(fields[1], -1),
+		Intensities: fields[2],
+	}
+	if b.Sequenced, b.FirstPulse, err = decodeSequence(fields[3]); err != nil {
+		return SentBatch{}, err
+	}
+	if b.MonitoredIntensities, err = decodeIntensityRanges(fields[4]); err != nil {
+		return SentBatch{}, fmt.Errorf("decoding monitored intensities: %w", err)
+	}
+	return b, nil
+}
+
+// Next implements the Sender interface.
+func (rs *RemoteSender) Next(bytes int) (bits, bases, lo, med, hi []byte, err error) {
+	return (&legacySender{rs}).Next(bytes)
+}
+
+// A RemoteReceiver is a BatchReceiver served from another process by
+// ServeReceiver. It also implements Receiver.
+type RemoteReceiver struct {
+	conn io.ReadWriter
+}
+
+// NewRemoteReceiver returns a RemoteReceiver communicating over conn, e.g. a
+// net.Conn to a server calling ServeReceiver.
+func NewRemoteReceiver(conn io.ReadWriter) (*RemoteReceiver, error) {
+	if _, err := readMagic(conn, remoteReceiverMagic); err != nil {
+		return nil, err
+	}
+	return &RemoteReceiver{conn: conn}, nil
+}
+
+// NextBatch implements the BatchReceiver interface.
+func (rr *RemoteReceiver) NextBatch(bytes int) (ReceivedBatch, error) {
+	fields, err := request(rr.conn, 6, bytes)
+	if err != nil {
+		return ReceivedBatch{}, err
+	}
+	b := ReceivedBatch{
+		Bits:    bitmap.NewDense(fields[0], -1),
+		Bases:   bitmap.NewDense(fields[1], -1),
+		Dropped: bitmap.NewDense(fields[2], -1),
+	}
+	if b.Sequenced, b.FirstPulse, err = decodeSequence(fields[3]); err != nil {
+		return ReceivedBatch{}, err
+	}
+	if b.Detector, err = decodeDetectorEvents(fields[4], fields[5]); err != nil {
+		return ReceivedBatch{}, err
+	}
+	return b, nil
+}
+
+// Next implements the Receiver interface.
+func (rr *RemoteReceiver) Next(bytes int) (bits, bases, dropped []byte, err error) {
+	return (&legacyReceiver{rr}).Next(bytes)
+}
+
+func request(conn io.ReadWriter, n, bytes int) ([][]byte, error) {
+	if err := checkBatchBytes(bytes); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(conn, binary.LittleEndian, uint32(bytes)); err != nil {
+		return nil, fmt.Errorf("requesting batch: %w", err)
+	}
+	status := make([]byte, 1)
+	if _, err := io.ReadFull(conn, status); err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+	switch status[0] {
+	case statusOK:
+		return readRecord(conn, n, bytes)
+	case statusError:
+		msg, err := readRecord(conn, 1, -1)
+		if err != nil {
+			return nil, fmt.Errorf("reading remote error: %w", err)
+		}
+		return nil, fmt.Errorf("remote: %s", msg[0])
+	}
+	return nil, fmt.Errorf("unrecognized response status %d", status[0])
+}
+
+func checkBatchBytes(bytes int) error {
+	if bytes <= 0 || bytes > MaxBatchBytes {
+		return fmt.Errorf("batch size %d must lie in [1, %d]", bytes, MaxBatchBytes)
+	}
+	return nil
+}
+
+func encodeSequence(sequenced bool, first uint64) []byte {
+	if !sequenced {
+		return nil
+	}
+	return encodeUint64s(first)
+}
+
+func decodeSequence(b []byte) (sequenced bool, first uint64, err error) {
+	if len(b) == 0 {
+		return false, 0, nil
+	}
+	vs, err := decodeUint64s(b, 1)
+	if err != nil {
+		return false, 0, fmt.Errorf("decoding sequence: %w", err)
+	}
+	return true, vs[0], nil
+}
+
+// encodeDetectorEvents encodes d, from a batch of the given size, as its
+// double clicks and detector counts fields, both of which are empty if d is
+// nil.
+func encodeDetectorEvents(d *DetectorEvents, bytes int) (doubles, counts []byte) {
+	if d == nil {
+		return nil, nil
+	}
+	doubles = d.DoubleClicks
+	if doubles == nil {
+		doubles = make([]byte, bytes)
+	}
+	return doubles, encodeUint64s(uint64(d.DarkCounts), uint64(d.Afterpulses), uint64(d.DeadTimeLosses))
+}
+
+func decodeDetectorEvents(doubles, counts []byte) (*DetectorEvents, error) {
+	if len(counts) == 0 {
+		return nil, nil
+	}
+	vs, err := decodeUint64s(counts, 3)
+	if err != nil {
+		return nil, fmt.Errorf("decoding detector counts: %w", err)
+	}
+	return &DetectorEvents{
+		DoubleClicks:   doubles,
+		DarkCounts:     int(vs[0]),
+		Afterpulses:    int(vs[1]),
+		DeadTimeLosses: int(vs[2]),
+	}, nil
+}
+
+func encodeUint64s(vs ...uint64) []byte {
+	b := make([]byte, 8*len(vs))
+	for i, v := range vs {
+		binary.LittleEndian.PutUint64(b[8*i:], v)
+	}
+	return b
+}
+
+func decodeUint64s(b []byte, n int) ([]uint64, error) {
+	if len(b) != 8*n {
+		return nil, fmt.Errorf("got %d bytes, want %d", len(b), 8*n)
+	}
+	vs := make([]uint64, n)
+	for i := range vs {
+		vs[i] = binary.LittleEndian.Uint64(b[8*i:])
+	}
+	return vs, nil
+}
+
+// encodeIntensityRanges encodes each range as its Min and Max, in turn, as
+// little-endian float64s.
+func encodeIntensityRanges(rs []IntensityRange) []byte {
+	vs := make([]uint64, 0, 2*len(rs))
+	for _, r := range rs {
+		vs = append(vs, math.Float64bits(r.Min), math.Float64bits(r.Max))
+	}
+	return encodeUint64s(vs...)
+}
+
+func decodeIntensityRanges(b []byte) ([]IntensityRange, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	if len(b)%16 != 0 {
+		return nil, fmt.Errorf("got %d bytes, want a multiple of 16", len(b))
+	}
+	vs, err := decodeUint64s(b, len(b)/8)
+	if err != nil {
+		return nil, err
+	}
+	rs := make([]IntensityRange, len(vs)/2)
+	for i := range rs {
+		rs[i] = IntensityRange{Min: math.Float64frombits(vs[2*i]), Max: math.Float64frombits(vs[2*i+1])}
+	}
+	return rs, nil
+}
@@ -0,0 +1,133 @@
+package photon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+)
+
+func TestRemoteChannel(t *testing.T) {
+	opts := SimulatedChannelOpts{
+		PMain:       0.5,
+		MuLo:        0.1,
+		MuMed:       0.2,
+		MuHi:        0.5,
+		PLo:         0.3,
+		PMed:        0.3,
+		PHi:         0.4,
+		SendSeed:    1,
+		ReceiveSeed: 2,
+		Link:        &FiberLink{DetectorEfficiency: 0.8, DarkCountProb: 0.05},
+		Detector:    &Detector{DeadTime: 2, AfterpulseProb: 0.1},
+	}
+	ss, sr := NewSimulatedChannel(opts)
+	wantSS, wantSR := NewSimulatedChannel(opts)
+	wantBR := NewBatchReceiver(wantSR)
+
+	aClient, aServer := net.Pipe()
+	bClient, bServer := net.Pipe()
+	serveErrs := make(chan error, 2)
+	go func() { serveErrs <- ServeSender(aServer, ss) }()
+	go func() { serveErrs <- ServeReceiver(bServer, sr) }()
+	rs, err := NewRemoteSender(aClient)
+	if err != nil {
+		t.Fatalf("NewRemoteSender: %v", err)
+	}
+	rr, err := NewRemoteReceiver(bClient)
+	if err != nil {
+		t.Fatalf("NewRemoteReceiver: %v", err)
+	}
+
+	for i, n := range []int{4, 16, 7} {
+		wantSent, _ := wantSS.NextBatch(n)
+		wantReceived, _ := wantBR.NextBatch(n)
+		// As with a local simulated channel, the sender must go first.
+		sent, err := rs.NextBatch(n)
+		if err != nil {
+			t.Fatalf("remote sending: %v", err)
+		}
+		received, err := rr.NextBatch(n)
+		if err != nil {
+			t.Fatalf("remote receiving: %v", err)
+		}
+		if !bitmap.Equal(sent.Bits, wantSent.Bits) || !bitmap.Equal(sent.Bases, wantSent.Bases) ||
+			!bytes.Equal(sent.Intensities, wantSent.Intensities) {
+			t.Errorf("batch %d: sent bits/bases/intensities differ from the local channel's", i)
+		}
+		if sent.Sequenced != wantSent.Sequenced || sent.FirstPulse != wantSent.FirstPulse {
+			t.Errorf("batch %d: got sent sequence (%v, %d), want (%v, %d)",
+				i, sent.Sequenced, sent.FirstPulse, wantSent.Sequenced, wantSent.FirstPulse)
+		}
+		if !bitmap.Equal(received.Bits, wantReceived.Bits) || !bitmap.Equal(received.Bases, wantReceived.Bases) ||
+			!bitmap.Equal(received.Dropped, wantReceived.Dropped) {
+			t.Errorf("batch %d: received bits/bases/dropped differ from the local channel's", i)
+		}
+		if received.Sequenced != wantReceived.Sequenced || received.FirstPulse != wantReceived.FirstPulse {
+			t.Errorf("batch %d: got received sequence (%v, %d), want (%v, %d)",
+				i, received.Sequenced, received.FirstPulse, wantReceived.Sequenced, wantReceived.FirstPulse)
+		}
+		if received.Detector == nil || !reflect.DeepEqual(*received.Detector, *wantReceived.Detector) {
+			t.Errorf("batch %d: got detector events %+v, want %+v", i, received.Detector, wantReceived.Detector)
+		}
+	}
+
+	aClient.Close()
+	bClient.Close()
+	for i := 0; i < 2; i++ {
+		if err := <-serveErrs; err != nil {
+			t.Errorf("serving: %v", err)
+		}
+	}
+}
+
+// failingReceiver is a Receiver which always fails.
+type failingReceiver struct{}
+
+func (failingReceiver) Next(bytes int) (bits, bases, dropped []byte, err error) {
+	return nil, nil, nil, errors.New("detector on fire")
+}
+
+func TestRemoteErrors(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go ServeReceiver(server, failingReceiver{})
+	if _, err := NewRemoteSender(client); err == nil {
+		t.Fatalf("RemoteSender accepted a receiver's server")
+	}
+
+	client, server = net.Pipe()
+	defer client.Close()
+	go ServeReceiver(server, failingReceiver{})
+	rr, err := NewRemoteReceiver(client)
+	if err != nil {
+		t.Fatalf("NewRemoteReceiver: %v", err)
+	}
+	// Errors don't end the session.
+	for i := 0; i < 2; i++ {
+		if _, _, _, err := rr.Next(4); err == nil || !strings.Contains(err.Error(), "detector on fire") {
+			t.Errorf("got error %v, want the remote's", err)
+		}
+	}
+	// Nor do oversized requests, which the server refuses before allocating
+	// anything.
+	if err := binary.Write(client, binary.LittleEndian, uint32(MaxBatchBytes+1)); err != nil {
+		t.Fatalf("requesting: %v", err)
+	}
+	status := make([]byte, 1)
+	if _, err := io.ReadFull(client, status); err != nil || status[0] != statusError {
+		t.Fatalf("got status %v (error %v), want %d", status, err, statusError)
+	}
+	if msg, err := readRecord(client, 1, -1); err != nil || !strings.Contains(string(msg[0]), "batch size") {
+		t.Errorf("got error record %q (error %v), want one about the batch size", msg, err)
+	}
+	if _, _, _, err := rr.Next(4); err == nil || !strings.Contains(err.Error(), "detector on fire") {
+		t.Errorf("got error %v, want the remote's", err)
+	}
+}
@@ -1,52 +1,123 @@
 package photon
 
 import (
-	"math"
 	"math/rand"
 
 	"github.com/alan-christopher/bb84/go/bb84/bitmap"
 )
 
+// SimulatedChannelOpts packages together the parameters of a simulated quantum
+// channel.
+type SimulatedChannelOpts struct {
+	// PMain specifies the probability with which both the sender and receiver
+	// choose the main basis for any given pulse.
+	PMain float64
+
+	// MuLo, MuMed, and MuHi specify the mean photons per pulse of the low,
+	// medium, and high intensity pulse states, respectively.
+	MuLo, MuMed, MuHi float64
+
+	// PLo, PMed, and PHi specify the probability that any given pulse is
+	// prepared at low, medium, or high intensity. They should sum to one.
+	PLo, PMed, PHi float64
+
+	// Intensities and IntensityProbs, if non-empty, supersede the fields
+	// above, specifying the mean photons per pulse of each of an arbitrary
+	// number of intensity levels, in increasing order, and the probability
+	// with which each level is chosen. The sender's Next method fails if there
+	// are more than three levels, but NextBatch supports any number.
+	Intensities, IntensityProbs []float64
+
+	// SinglePhoton, if set, simulates an ideal single-photon source instead of
+	// an attenuated laser: every pulse carries exactly one photon, and is
+	// reported at intensity level 0. The fields above are then ignored.
+	SinglePhoton bool
+
+	// SendSeed and ReceiveSeed seed the pRNGs driving the sender and receiver,
+	// respectively. The channel's behavior is entirely determined by them.
+	SendSeed, ReceiveSeed int64
+
+	// Link, if non-nil, models the physical channel between sender and
+	// receiver. If nil, the channel is lossless and noiseless, and a pulse is
+	// only dropped if it contains no photons.
+	Link *FiberLink
+
+	// Detector, if non-nil, models non-idealities of the receiver's detectors,
+	// which are then reported via the receiver's DetectorEvents method. If
+	// Link is nil, detection is otherwise ideal.
+	Detector *Detector
+
+	// Eve, if non-nil, attacks every pulse before it enters the link. Her
+	// behavior is driven by a pRNG seeded with EveSeed, and what she learns is
+	// reported via the receiver's EveReport method.
+	Eve     Eavesdropper
+	EveSeed int64
+
+	// Slip, if non-zero, desynchronizes the receiver from the sender, as if
+	// its hardware had slipped a frame: from batch SlipAfter onwards, pulse i
+	// of each batch received is pulse i + Slip of the batch sent. Pulses with
+	// no counterpart are received as vacuum. The receiver remains unaware of
+	// the slip, and continues to report sequence numbers as if in sync.
+	Slip      int
+	SlipAfter int
+}
+
 // NewSimulatedChannel creates a pair of (Sender, Receiver) structs simulating a
 // Quantum channel. It is expected that each call to Send() will be mirrored by
 // a call to Receive(). Expect errors if that is not the case, and for calls to
 // Send() to hang if more than 1 of them are made before a Receive().
-func NewSimulatedChannel(pMain, muLo, muMed, muHi, pLo, pMed, pHi float64,
-	sendRand, receiveRand *rand.Rand) (*SimulatedSender, *SimulatedReceiver) {
+func NewSimulatedChannel(opts SimulatedChannelOpts) (*SimulatedSender, *SimulatedReceiver) {
 	bits := make(chan bitmap.Dense, 1)
 	bases := make(chan bitmap.Dense, 1)
-	drops := make(chan bitmap.Dense, 1)
+	photons := make(chan []int, 1)
 	ss := &SimulatedSender{
-		bits:  bits,
-		bases: bases,
-		drops: drops,
-		muLo:  muLo,
-		muMed: muMed,
-		muHi:  muHi,
-		pLo:   pLo,
-		pMed:  pMed,
-		pHi:   pHi,
-		pMain: pMain,
-		rand:  sendRand,
+		bits:    bits,
+		bases:   bases,
+		photons: photons,
+		mus:     opts.Intensities,
+		probs:   opts.IntensityProbs,
+		pMain:   opts.PMain,
+		rand:    rand.New(rand.NewSource(opts.SendSeed)),
+	}
+	switch {
+	case opts.SinglePhoton:
+		ss.mus, ss.probs, ss.singlePhoton = []float64{1}, []float64{1}, true
+	case len(ss.mus) == 0:
+		ss.mus = []float64{opts.MuLo, opts.MuMed, opts.MuHi}
+		ss.probs = []float64{opts.PLo, opts.PMed, opts.PHi}
 	}
 	sr := &SimulatedReceiver{
-		bits:  bits,
-		bases: bases,
-		drops: drops,
-		pMain: pMain,
-		rand:  receiveRand,
+		bits:      bits,
+		bases:     bases,
+		photons:   photons,
+		pMain:     opts.PMain,
+		link:      opts.Link,
+		slip:      opts.Slip,
+		slipAfter: opts.SlipAfter,
+		rand:      rand.New(rand.NewSource(opts.ReceiveSeed)),
+	}
+	if opts.Detector != nil {
+		sr.detector = &detectorState{Detector: *opts.Detector}
+		if sr.link == nil {
+			sr.link = &FiberLink{DetectorEfficiency: 1}
+		}
+	}
+	if opts.Eve != nil {
+		sr.eve = opts.Eve
+		sr.eveRand = rand.New(rand.NewSource(opts.EveSeed))
 	}
 	return ss, sr
 }
 
 type SimulatedSender struct {
-	bits  chan<- bitmap.Dense
-	bases chan<- bitmap.Dense
-	drops chan<- bitmap.Dense
+	bits    chan<- bitmap.Dense
+	bases   chan<- bitmap.Dense
+	photons chan<- []int
 
-	pMain             float64
-	muLo, muMed, muHi float64
-	pLo, pMed, pHi    float64
+	pMain        float64
+	mus, probs   []float64
+	singlePhoton bool
+	first, next  uint64
 
 	rand *rand.Rand
 }
@@ -55,63 +126,86 @@ type SimulatedReceiver struct {
 	Errors []byte
 	Drops  []byte
 
-	pMain float64
-	bits  <-chan bitmap.Dense
-	bases <-chan bitmap.Dense
-	drops <-chan bitmap.Dense
-	rand  *rand.Rand
+	pMain           float64
+	slip, slipAfter int
+	batches         int
+	first, next     uint64
+	link            *FiberLink
+	detector        *detectorState
+	events          DetectorEvents
+	eve             Eavesdropper
+	eveRand         *rand.Rand
+	report          EveReport
+	bits            <-chan bitmap.Dense
+	bases           <-chan bitmap.Dense
+	photons         <-chan []int
+	rand            *rand.Rand
 }
 
+// Next implements the Sender interface.
 func (ss *SimulatedSender) Next(bytes int) (bits, bases, lo, med, hi []byte, err error) {
-	bits = make([]byte, bytes)
+	return (&legacySender{ss}).Next(bytes)
+}
+
+// NextBatch implements the BatchSender interface.
+func (ss *SimulatedSender) NextBatch(bytes int) (SentBatch, error) {
+	bits := make([]byte, bytes)
 	ss.rand.Read(bits)
 
-	baBases := bitmap.Empty()
-	baLo := bitmap.Empty()
-	baMed := bitmap.Empty()
-	baHi := bitmap.Empty()
-	drops := bitmap.Empty()
+	b := SentBatch{
+		Bits:        bitmap.NewDense(bits, -1),
+		Bases:       bitmap.Empty(),
+		Intensities: make([]uint8, 0, bytes*8),
+		Sequenced:   true,
+	}
+	photons := make([]int, 0, bytes*8)
 	pZ := 1 - ss.pMain
 	for i := 0; i < bytes*8; i++ {
-		baBases.AppendBit(ss.rand.Float64() < pZ)
+		b.Bases.AppendBit(ss.rand.Float64() < pZ)
 
 		r := ss.rand.Float64()
-		isLo := r < ss.pLo
-		isMed := !isLo && r < ss.pLo+ss.pMed
-		isHi := !isLo && !isMed
-		baLo.AppendBit(isLo)
-		baMed.AppendBit(isMed)
-		baHi.AppendBit(isHi)
-
-		mu := ss.muLo
-		if isMed {
-			mu = ss.muMed
-		} else if isHi {
-			mu = ss.muHi
+		level, cum := 0, ss.probs[0]
+		for level < len(ss.probs)-1 && r >= cum {
+			level++
+			cum += ss.probs[level]
 		}
-		drops.AppendBit(ss.rand.Float64() < math.Exp(-mu))
-	}
-	bases = baBases.Data()
-	lo = baLo.Data()
-	med = baMed.Data()
-	hi = baHi.Data()
-	ss.bits <- bitmap.NewDense(bits, -1)
-	ss.bases <- baBases
-	ss.drops <- drops
-	return
+		b.Intensities = append(b.Intensities, uint8(level))
+		if ss.singlePhoton {
+			photons = append(photons, 1)
+		} else {
+			photons = append(photons, poisson(ss.rand, ss.mus[level]))
+		}
+	}
+	ss.bits <- b.Bits
+	ss.bases <- b.Bases
+	ss.photons <- photons
+	ss.first = ss.next
+	ss.next += uint64(bytes * 8)
+	b.FirstPulse = ss.first
+	return b, nil
+}
+
+// FirstPulse implements the Sequencer interface.
+func (ss *SimulatedSender) FirstPulse() uint64 {
+	return ss.first
 }
 
 func (sr *SimulatedReceiver) Next(bytes int) (bits, bases, dropped []byte, err error) {
 	sendBits := <-sr.bits
 	sendBases := <-sr.bases
-	drops := <-sr.drops
+	photons := <-sr.photons
+	if sr.slip != 0 && sr.batches >= sr.slipAfter {
+		sendBits, sendBases, photons = slip(sendBits, sendBases, photons, sr.slip)
+	}
+	sr.batches++
+	sr.first = sr.next
+	sr.next += uint64(sendBits.Size())
 
 	receiveBases := bitmap.Empty()
 	pZ := 1 - sr.pMain
 	for i := 0; i < sendBits.Size(); i++ {
 		receiveBases.AppendBit(sr.rand.Float64() < pZ)
 	}
-
 	synthErrs, err := sr.resize(bitmap.NewDense(sr.Errors, -1), bytes*8)
 	if err != nil {
 		return nil, nil, nil, err
@@ -120,15 +214,90 @@ func (sr *SimulatedReceiver) Next(bytes int) (bits, bases, dropped []byte, err e
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	buf := make([]byte, sendBits.SizeBytes())
-	rand.Read(buf)
-	flips := bitmap.NewDense(buf, -1)
-	flips = bitmap.And(flips, bitmap.XOr(sendBases, receiveBases))
+	// The state arriving at the link only differs from the one sent if Eve
+	// has tampered with it.
+	arriveBits, arriveBases, known := sendBits, sendBases, bitmap.NewDense(nil, sendBits.Size())
+	if sr.eve != nil {
+		arriveBits, arriveBases, photons, known = sr.intercept(sendBits, sendBases, photons)
+	}
+	var flips, drops bitmap.Dense
+	if sr.detector != nil {
+		var detected bitmap.Dense
+		detected, drops, sr.events = sr.detector.detect(sr.link, arriveBits, arriveBases, receiveBases, photons, sr.rand)
+		flips = bitmap.XOr(detected, sendBits)
+	} else {
+		flips, drops = sr.transmit(arriveBases, receiveBases, photons)
+		flips = bitmap.XOr(flips, bitmap.XOr(arriveBits, sendBits))
+	}
 	flips = bitmap.Or(flips, synthErrs)
 	drops = bitmap.Or(drops, synthDrops)
+	if sr.eve != nil {
+		rawKey := bitmap.And(bitmap.Not(drops), bitmap.Not(bitmap.Or(sendBases, receiveBases)))
+		sr.report.Known += bitmap.CountOnes(known)
+		sr.report.RawKey += bitmap.CountOnes(rawKey)
+		sr.report.RawKeyKnown += bitmap.CountOnes(bitmap.And(rawKey, known))
+	}
 	return bitmap.XOr(flips, sendBits).Data(), receiveBases.Data(), drops.Data(), nil
 }
 
+// DetectorEvents implements the DetectorReporter interface. Events are only
+// simulated if the channel was configured with a Detector.
+func (sr *SimulatedReceiver) DetectorEvents() DetectorEvents {
+	return sr.events
+}
+
+// FirstPulse implements the Sequencer interface.
+func (sr *SimulatedReceiver) FirstPulse() uint64 {
+	return sr.first
+}
+
+// EveReport returns a summary of what the channel's Eavesdropper, if any, has
+// learned so far.
+func (sr *SimulatedReceiver) EveReport() EveReport {
+	return sr.report
+}
+
+// intercept subjects a batch of pulses to the channel's Eavesdropper, returning
+// the state of the pulses she forwards on, and which bits she learned.
+func (sr *SimulatedReceiver) intercept(sendBits, sendBases bitmap.Dense,
+	photons []int) (bits, bases bitmap.Dense, forwarded []int, known bitmap.Dense) {
+	pulses := make([]Pulse, len(photons))
+	for i, n := range photons {
+		pulses[i] = Pulse{Bit: sendBits.Get(i), Basis: sendBases.Get(i), Photons: n}
+	}
+	forward, known := sr.eve.Intercept(pulses, sr.eveRand)
+	forwarded = make([]int, len(forward))
+	for i, p := range forward {
+		bits.AppendBit(p.Bit)
+		bases.AppendBit(p.Basis)
+		forwarded[i] = p.Photons
+	}
+	return bits, bases, forwarded, known
+}
+
+// transmit simulates the transmission of pulses through the channel with an
+// idealized detector, returning the resulting bit flips and drops.
+func (sr *SimulatedReceiver) transmit(sendBases, receiveBases bitmap.Dense,
+	photons []int) (flips, drops bitmap.Dense) {
+	channelErrs := bitmap.Empty()
+	for _, n := range photons {
+		if sr.link == nil {
+			drops.AppendBit(n == 0)
+			channelErrs.AppendBit(false)
+			continue
+		}
+		click, flip := sr.link.transmit(n, sr.rand)
+		drops.AppendBit(!click)
+		channelErrs.AppendBit(flip)
+	}
+	buf := make([]byte, bitmap.BytesFor(len(photons)))
+	sr.rand.Read(buf)
+	flips = bitmap.NewDense(buf, len(photons))
+	flips = bitmap.And(flips, bitmap.XOr(sendBases, receiveBases))
+	flips = bitmap.Or(flips, bitmap.And(channelErrs, bitmap.XNor(sendBases, receiveBases)))
+	return flips, drops
+}
+
 func (sr *SimulatedReceiver) resize(r bitmap.Dense, s int) (bitmap.Dense, error) {
 	if r.Size() < s {
 		r2 := bitmap.NewDense(nil, s-r.Size())
@@ -141,3 +310,21 @@ func (sr *SimulatedReceiver) resize(r bitmap.Dense, s int) (bitmap.Dense, error)
 	}
 	return r, nil
 }
+
+// slip shifts a batch of pulses by offset, such that pulse i of the result is
+// pulse i + offset of the input, filling in with vacuum as needed.
+func slip(bits, bases bitmap.Dense, photons []int, offset int) (sBits, sBases bitmap.Dense, sPhotons []int) {
+	sPhotons = make([]int, len(photons))
+	for i := range photons {
+		j := i + offset
+		if j < 0 || j >= len(photons) {
+			sBits.AppendBit(false)
+			sBases.AppendBit(false)
+			continue
+		}
+		sBits.AppendBit(bits.Get(j))
+		sBases.AppendBit(bases.Get(j))
+		sPhotons[i] = photons[j]
+	}
+	return sBits, sBases, sPhotons
+}
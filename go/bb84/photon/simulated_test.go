@@ -1,3 +1,175 @@
-package photon
+package photon_test
 
-// TODO: write some unit tests to make sure SimulatedChannel behaves itself.
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/alan-christopher/bb84/go/bb84"
+	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+	"github.com/alan-christopher/bb84/go/bb84/photon"
+	"github.com/alan-christopher/bb84/go/bb84/photon/photontest"
+)
+
+func TestSimulatedChannelConforms(t *testing.T) {
+	pa := bb84.PulseAttrs{
+		MuLo: 0.05, MuMed: 0.1, MuHi: 0.3,
+		ProbLo: 0.4, ProbMed: 0.3, ProbHi: 0.3,
+	}
+	tcs := []struct {
+		name string
+		link *photon.FiberLink
+	}{
+		{name: "ideal"},
+		{
+			name: "fiber",
+			link: &photon.FiberLink{
+				LengthKm:           10,
+				AttenuationDBPerKm: 0.2,
+				DetectorEfficiency: 0.6,
+				DarkCountProb:      1e-3,
+				Misalignment:       0.02,
+			},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			ss, sr := photon.NewSimulatedChannel(photon.SimulatedChannelOpts{
+				PMain:       0.7,
+				MuLo:        pa.MuLo,
+				MuMed:       pa.MuMed,
+				MuHi:        pa.MuHi,
+				PLo:         pa.ProbLo,
+				PMed:        pa.ProbMed,
+				PHi:         pa.ProbHi,
+				SendSeed:    17,
+				ReceiveSeed: 19,
+				Link:        tc.link,
+			})
+			if _, err := photontest.Check(ss, sr, photontest.Opts{
+				PMain:      0.7,
+				PulseAttrs: pa,
+				Link:       tc.link,
+			}); err != nil {
+				t.Errorf("SimulatedChannel failed conformance checks:\n%v", err)
+			}
+		})
+	}
+}
+
+func TestSimulatedChannelDeterministic(t *testing.T) {
+	opts := photon.SimulatedChannelOpts{
+		PMain:       0.7,
+		MuLo:        0.05,
+		MuMed:       0.1,
+		MuHi:        0.3,
+		PLo:         0.4,
+		PMed:        0.3,
+		PHi:         0.3,
+		SendSeed:    11,
+		ReceiveSeed: 13,
+	}
+	run := func() [][]byte {
+		ss, sr := photon.NewSimulatedChannel(opts)
+		var r [][]byte
+		for i := 0; i < 3; i++ {
+			bits, bases, lo, med, hi, err := ss.Next(64)
+			if err != nil {
+				t.Fatalf("sending: %v", err)
+			}
+			rBits, rBases, dropped, err := sr.Next(64)
+			if err != nil {
+				t.Fatalf("receiving: %v", err)
+			}
+			r = append(r, bits, bases, lo, med, hi, rBits, rBases, dropped)
+		}
+		return r
+	}
+	first, second := run(), run()
+	for i := range first {
+		if !bytes.Equal(first[i], second[i]) {
+			t.Errorf("identically seeded channels diverged at output %d: %v != %v", i, first[i], second[i])
+		}
+	}
+}
+
+func TestSimulatedChannelIntensities(t *testing.T) {
+	probs := []float64{0.1, 0.2, 0.3, 0.4}
+	ss, sr := photon.NewSimulatedChannel(photon.SimulatedChannelOpts{
+		PMain:          0.5,
+		Intensities:    []float64{0, 0.05, 0.1, 0.3},
+		IntensityProbs: probs,
+		SendSeed:       1,
+		ReceiveSeed:    2,
+	})
+	const bytes = 1 << 12
+	b, err := ss.NextBatch(bytes)
+	if err != nil {
+		t.Fatalf("NextBatch: %v", err)
+	}
+	if _, _, _, err := sr.Next(bytes); err != nil {
+		t.Fatalf("receiving: %v", err)
+	}
+	counts := make([]int, len(probs))
+	for _, level := range b.Intensities {
+		counts[level]++
+	}
+	for level, p := range probs {
+		// Well over five standard deviations.
+		if got := float64(counts[level]) / (bytes * 8); got < p-0.02 || got > p+0.02 {
+			t.Errorf("got %f of pulses at level %d, want %f", got, level, p)
+		}
+	}
+	if _, _, _, _, _, err := ss.Next(1); err == nil {
+		t.Errorf("Next reported four intensity levels as three")
+	}
+}
+
+func TestSimulatedChannelSinglePhoton(t *testing.T) {
+	link := &photon.FiberLink{
+		LengthKm:           10,
+		AttenuationDBPerKm: 0.2,
+		DetectorEfficiency: 0.6,
+		DarkCountProb:      0.01,
+		Misalignment:       0.03,
+	}
+	ss, sr := photon.NewSimulatedChannel(photon.SimulatedChannelOpts{
+		PMain:        0.5,
+		MuHi:         0.9,
+		PHi:          1,
+		SinglePhoton: true,
+		SendSeed:     1,
+		ReceiveSeed:  2,
+		Link:         link,
+	})
+	var sent, detected, matched, errs int
+	for i := 0; i < 20; i++ {
+		b, err := ss.NextBatch(1 << 10)
+		if err != nil {
+			t.Fatalf("NextBatch: %v", err)
+		}
+		rBits, rBases, dropped, err := sr.Next(1 << 10)
+		if err != nil {
+			t.Fatalf("receiving: %v", err)
+		}
+		for _, level := range b.Intensities {
+			if level != 0 {
+				t.Fatalf("got a pulse at intensity level %d, want 0", level)
+			}
+		}
+		d := bitmap.Not(bitmap.NewDense(dropped, -1))
+		m := bitmap.And(d, bitmap.XNor(b.Bases, bitmap.NewDense(rBases, -1)))
+		sent += b.Bits.Size()
+		detected += bitmap.CountOnes(d)
+		matched += bitmap.CountOnes(m)
+		errs += bitmap.CountOnes(bitmap.And(m, bitmap.XOr(b.Bits, bitmap.NewDense(rBits, -1))))
+	}
+	q, n := link.SinglePhotonDetectionProb(), float64(sent)
+	if got, sigma := float64(detected), math.Sqrt(n*q*(1-q)); math.Abs(got-n*q) > 5*sigma {
+		t.Errorf("detected %g of %g pulses, want %g +/- %g", got, n, n*q, 5*sigma)
+	}
+	e, m := link.SinglePhotonErrorProb(), float64(matched)
+	if got, sigma := float64(errs), math.Sqrt(m*e*(1-e)); math.Abs(got-m*e) > 5*sigma {
+		t.Errorf("%g errors in %g matched detections, want %g +/- %g", got, m, m*e, 5*sigma)
+	}
+}
@@ -0,0 +1,242 @@
+package photon
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+
+	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+)
+
+// A DetectionEvent is a single time-tagged click registered by a receiver's
+// detectors.
+type DetectionEvent struct {
+	// Time is the time tag of the click, in picoseconds on the receiver's
+	// clock.
+	Time int64
+
+	// Basis and Bit identify the detector which clicked, by the measurement
+	// basis it belongs to and the bit value it registers. As elsewhere in this
+	// package, a false Basis denotes the main basis.
+	Basis, Bit bool
+}
+
+// An EventReceiver receives photons as a stream of time-tagged detection
+// events, rather than as batches aligned to pulse slots.
+type EventReceiver interface {
+	// Events returns, in time order, every detection event tagged earlier than
+	// until which has not been returned by a previous call.
+	Events(until int64) ([]DetectionEvent, error)
+}
+
+// TimeTagOpts configures the mapping of time tags onto pulse slots.
+type TimeTagOpts struct {
+	// Period is the nominal time between pulses, in picoseconds.
+	Period float64
+
+	// Offset is the time, on the receiver's clock, at which the first pulse
+	// is expected to arrive. It should be accurate to well within half a
+	// Period; it is subsequently refined from the events themselves.
+	Offset float64
+
+	// Window is the coincidence window: a click is attributed to a pulse slot
+	// if it lies within Window picoseconds of the slot's expected arrival
+	// time, and is otherwise discarded as noise. It should be wide enough to
+	// accommodate the detectors' timing jitter, plus any clock drift
+	// accumulated over the course of a batch. Defaults to a quarter of Period.
+	Window float64
+
+	// Rand provides randomness for squashing multiple clicks within a single
+	// slot down to a single measurement.
+	Rand *rand.Rand
+}
+
+// ClockEstimate describes the receiver's current estimate of the relationship
+// between its own clock and the pulse slots.
+type ClockEstimate struct {
+	// Offset is the estimated arrival time of the first pulse, and Period the
+	// estimated time between pulses, both on the receiver's clock. Any
+	// relative drift between the sender and receiver clocks is absorbed into
+	// Period.
+	Offset, Period float64
+
+	// Jitter is the standard deviation of recent clicks about their slots'
+	// estimated arrival times.
+	Jitter float64
+}
+
+// A TimeTagReceiver adapts an EventReceiver to the Receiver interface, by
+// mapping time-tagged clicks onto pulse slots. It continuously refines its
+// estimate of the receiver's clock offset and drift from the clicks it sees.
+//
+// A TimeTagReceiver also implements DetectorReporter, reporting any slots on
+// which more than one detector clicked. Such slots are squashed: the
+// measurement reported is chosen uniformly at random from those clicks.
+type TimeTagReceiver struct {
+	er     EventReceiver
+	window float64
+	rand   *rand.Rand
+	clock  ClockEstimate
+	slot   int
+	events DetectorEvents
+
+	// pending holds events read for a previous batch which, once the clock
+	// estimate was refined, turned out to belong to a later one.
+	pending []DetectionEvent
+}
+
+// NewTimeTagReceiver returns a TimeTagReceiver reading events from er.
+func NewTimeTagReceiver(er EventReceiver, opts TimeTagOpts) (*TimeTagReceiver, error) {
+	if opts.Period <= 0 {
+		return nil, errors.New("must provide a positive Period")
+	}
+	if opts.Rand == nil {
+		return nil, errors.New("must provide Rand")
+	}
+	window := opts.Window
+	if window == 0 {
+		window = opts.Period / 4
+	}
+	if window < 0 || window > opts.Period/2 {
+		return nil, errors.New("Window must lie in [0, Period/2]")
+	}
+	return &TimeTagReceiver{
+		er:     er,
+		window: window,
+		rand:   opts.Rand,
+		clock:  ClockEstimate{Offset: opts.Offset, Period: opts.Period},
+	}, nil
+}
+
+// Clock returns the receiver's current clock estimate.
+func (tr *TimeTagReceiver) Clock() ClockEstimate {
+	return tr.clock
+}
+
+// DetectorEvents implements the DetectorReporter interface.
+func (tr *TimeTagReceiver) DetectorEvents() DetectorEvents {
+	return tr.events
+}
+
+// Next implements the Receiver interface.
+func (tr *TimeTagReceiver) Next(bytes int) (bits, bases, dropped []byte, err error) {
+	n := bytes * 8
+	first := tr.slot
+	// Every click closer to one of this batch's slots than to any other
+	// belongs to this batch.
+	until := tr.arrival(first+n) - tr.clock.Period/2
+	evts, err := tr.er.Events(int64(math.Ceil(until)))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	evts = append(tr.pending, evts...)
+	tr.pending = nil
+	tr.slot += n
+
+	// Refine the clock estimate from how far each click lies from its nearest
+	// slot, then attribute clicks to slots using the refined estimate. Clicks
+	// outside the coincidence window are likely noise, and would only bias
+	// the estimate.
+	var fit clockFit
+	for _, e := range evts {
+		k, residual := tr.nearest(float64(e.Time))
+		if k >= first && k < first+n && math.Abs(residual) <= tr.window {
+			fit.add(float64(k-first), residual)
+		}
+	}
+	tr.update(first, fit)
+	// If the refined estimate moved the batch's end later, the clicks in
+	// between belong to this batch too, so read them now rather than leave
+	// them for the next batch to discard.
+	if refined := tr.arrival(first+n) - tr.clock.Period/2; math.Ceil(refined) > math.Ceil(until) {
+		more, err := tr.er.Events(int64(math.Ceil(refined)))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		evts = append(evts, more...)
+	}
+	clicks := make([][]DetectionEvent, n)
+	for _, e := range evts {
+		k, residual := tr.nearest(float64(e.Time))
+		switch {
+		case k >= first+n:
+			tr.pending = append(tr.pending, e)
+		case k >= first && math.Abs(residual) <= tr.window:
+			clicks[k-first] = append(clicks[k-first], e)
+		}
+	}
+
+	bBits, bBases, bDropped, doubles := bitmap.Empty(), bitmap.Empty(), bitmap.Empty(), bitmap.Empty()
+	for _, c := range clicks {
+		if len(c) == 0 {
+			bBits.AppendBit(false)
+			bBases.AppendBit(false)
+			bDropped.AppendBit(true)
+			doubles.AppendBit(false)
+			continue
+		}
+		e := c[tr.rand.Intn(len(c))]
+		bBits.AppendBit(e.Bit)
+		bBases.AppendBit(e.Basis)
+		bDropped.AppendBit(false)
+		doubles.AppendBit(len(c) > 1)
+	}
+	tr.events = DetectorEvents{DoubleClicks: doubles.Data()}
+	return bBits.Data(), bBases.Data(), bDropped.Data(), nil
+}
+
+// arrival returns the estimated arrival time of pulse k.
+func (tr *TimeTagReceiver) arrival(k int) float64 {
+	return tr.clock.Offset + float64(k)*tr.clock.Period
+}
+
+// nearest returns the slot nearest to time t, and how far t lies from that
+// slot's estimated arrival time.
+func (tr *TimeTagReceiver) nearest(t float64) (slot int, residual float64) {
+	k := math.Round((t - tr.clock.Offset) / tr.clock.Period)
+	return int(k), t - tr.arrival(int(k))
+}
+
+// update refines the clock estimate from the residuals of a batch starting at
+// slot first. Batches with too few clicks to say anything are ignored.
+func (tr *TimeTagReceiver) update(first int, fit clockFit) {
+	if fit.n < 2 {
+		return
+	}
+	intercept, slope, jitter := fit.solve()
+	// The fit is relative to the batch's first slot, so re-anchor it at slot
+	// zero before applying it.
+	tr.clock.Period += slope
+	tr.clock.Offset += intercept - slope*float64(first)
+	tr.clock.Jitter = jitter
+}
+
+// clockFit accumulates a least-squares linear fit of timing residuals against
+// slot index.
+type clockFit struct {
+	n                     float64
+	sx, sy, sxx, sxy, syy float64
+}
+
+func (f *clockFit) add(x, y float64) {
+	f.n++
+	f.sx += x
+	f.sy += y
+	f.sxx += x * x
+	f.sxy += x * y
+	f.syy += y * y
+}
+
+// solve returns the fitted intercept and slope, and the standard deviation of
+// the residuals about the fit.
+func (f *clockFit) solve() (intercept, slope, stdDev float64) {
+	den := f.n*f.sxx - f.sx*f.sx
+	if den != 0 {
+		slope = (f.n*f.sxy - f.sx*f.sy) / den
+	}
+	intercept = (f.sy - slope*f.sx) / f.n
+	// Sum of squared residuals, expanded in terms of the accumulated sums.
+	ssr := f.syy - 2*intercept*f.sy - 2*slope*f.sxy +
+		f.n*intercept*intercept + 2*intercept*slope*f.sx + slope*slope*f.sxx
+	return intercept, slope, math.Sqrt(math.Max(ssr, 0) / f.n)
+}
@@ -0,0 +1,171 @@
+package photon
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+)
+
+// fakeEvents is an EventReceiver serving a precomputed stream of events.
+type fakeEvents struct {
+	evts []DetectionEvent
+}
+
+func (f *fakeEvents) Events(until int64) ([]DetectionEvent, error) {
+	i := sort.Search(len(f.evts), func(i int) bool { return f.evts[i].Time >= until })
+	r := f.evts[:i]
+	f.evts = f.evts[i:]
+	return r, nil
+}
+
+func TestTimeTagReceiver(t *testing.T) {
+	const (
+		period = 1000.0
+		offset = 123456.0
+		slots  = 4 * 8 * 512
+	)
+	tcs := []struct {
+		name   string
+		drift  float64
+		jitter float64
+		noise  bool
+	}{
+		{name: "ideal"},
+		{name: "jitter", jitter: 30},
+		{name: "drift", drift: 2e-5, jitter: 10},
+		{name: "noise", jitter: 30, noise: true},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			r := rand.New(rand.NewSource(1))
+			wantBits, wantBases, wantDropped, wantDoubles := bitmap.Empty(), bitmap.Empty(), bitmap.Empty(), bitmap.Empty()
+			var evts []DetectionEvent
+			for k := 0; k < slots; k++ {
+				at := offset + float64(k)*period*(1+tc.drift)
+				if tc.noise && r.Float64() < 0.1 {
+					// Half way between slots, and so outside any reasonable
+					// coincidence window.
+					evts = append(evts, DetectionEvent{Time: int64(at + period/2)})
+				}
+				clicked := r.Float64() < 0.3
+				bit, basis := r.Float64() < 0.5, r.Float64() < 0.5
+				double := clicked && r.Float64() < 0.05
+				wantDropped.AppendBit(!clicked)
+				wantDoubles.AppendBit(double)
+				if !clicked {
+					wantBits.AppendBit(false)
+					wantBases.AppendBit(false)
+					continue
+				}
+				if double {
+					// Both detectors in the same basis click, so only the bit
+					// is in doubt.
+					evts = append(evts, DetectionEvent{Time: int64(at + r.NormFloat64()*tc.jitter), Basis: basis, Bit: !bit})
+				}
+				evts = append(evts, DetectionEvent{Time: int64(at + r.NormFloat64()*tc.jitter), Basis: basis, Bit: bit})
+				wantBits.AppendBit(bit)
+				wantBases.AppendBit(basis)
+			}
+			sort.Slice(evts, func(i, j int) bool { return evts[i].Time < evts[j].Time })
+
+			tr, err := NewTimeTagReceiver(&fakeEvents{evts}, TimeTagOpts{
+				Period: period,
+				Offset: offset + 50,
+				Rand:   rand.New(rand.NewSource(2)),
+			})
+			if err != nil {
+				t.Fatalf("NewTimeTagReceiver: %v", err)
+			}
+			gotBits, gotBases, gotDropped, gotDoubles := bitmap.Empty(), bitmap.Empty(), bitmap.Empty(), bitmap.Empty()
+			for i := 0; i < 4; i++ {
+				bits, bases, dropped, err := tr.Next(512)
+				if err != nil {
+					t.Fatalf("Next: %v", err)
+				}
+				gotBits.Append(bitmap.NewDense(bits, -1))
+				gotBases.Append(bitmap.NewDense(bases, -1))
+				gotDropped.Append(bitmap.NewDense(dropped, -1))
+				gotDoubles.Append(bitmap.NewDense(tr.DetectorEvents().DoubleClicks, -1))
+			}
+
+			if !bitmap.Equal(gotDropped, wantDropped) {
+				t.Errorf("got %d drops, want %d", bitmap.CountOnes(gotDropped), bitmap.CountOnes(wantDropped))
+			}
+			if !bitmap.Equal(gotBases, wantBases) {
+				t.Errorf("got %d basis mismatches", bitmap.CountOnes(bitmap.XOr(gotBases, wantBases)))
+			}
+			if !bitmap.Equal(gotDoubles, wantDoubles) {
+				t.Errorf("got %d double clicks, want %d", bitmap.CountOnes(gotDoubles), bitmap.CountOnes(wantDoubles))
+			}
+			// Double clicks are squashed to a random bit, so only the other
+			// slots need agree.
+			bitErrs := bitmap.And(bitmap.XOr(gotBits, wantBits), bitmap.Not(wantDoubles))
+			if n := bitmap.CountOnes(bitErrs); n != 0 {
+				t.Errorf("got %d bit errors", n)
+			}
+			clock := tr.Clock()
+			if want := period * (1 + tc.drift); math.Abs(clock.Period-want) > 0.01 {
+				t.Errorf("got period estimate %f, want %f", clock.Period, want)
+			}
+			if math.Abs(clock.Offset-offset) > 5 {
+				t.Errorf("got offset estimate %f, want %f", clock.Offset, offset)
+			}
+		})
+	}
+}
+
+func TestTimeTagReceiverRefinedBoundary(t *testing.T) {
+	// The initial offset estimate is 200ps early, so the last slot's late
+	// click lies beyond the batch's initial end, but within its refined one.
+	var evts []DetectionEvent
+	for k := 0; k < 7; k++ {
+		evts = append(evts, DetectionEvent{Time: int64(k * 1000)})
+	}
+	evts = append(evts, DetectionEvent{Time: 7350, Bit: true}, DetectionEvent{Time: 8000})
+	tr, err := NewTimeTagReceiver(&fakeEvents{evts}, TimeTagOpts{
+		Period: 1000,
+		Offset: -200,
+		Window: 450,
+		Rand:   rand.New(rand.NewSource(1)),
+	})
+	if err != nil {
+		t.Fatalf("NewTimeTagReceiver: %v", err)
+	}
+	for i, want := range []string{"00000000", "01111111"} {
+		bits, _, dropped, err := tr.Next(1)
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if got := bitmap.NewDense(dropped, -1); !bitmap.Equal(got, mustFromString(t, want)) {
+			t.Errorf("batch %d: got drops %v, want %s", i, got, want)
+		}
+		if i == 0 && !bitmap.NewDense(bits, -1).Get(7) {
+			t.Errorf("batch 0: the last slot's click was misattributed")
+		}
+	}
+}
+
+func mustFromString(t *testing.T, s string) bitmap.Dense {
+	t.Helper()
+	b, err := bitmap.FromString(s)
+	if err != nil {
+		t.Fatalf("bugged test setup: %v", err)
+	}
+	return b
+}
+
+func TestNewTimeTagReceiverValidation(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, opts := range []TimeTagOpts{
+		{Rand: r},
+		{Period: 1000},
+		{Period: 1000, Window: 600, Rand: r},
+	} {
+		if _, err := NewTimeTagReceiver(&fakeEvents{}, opts); err == nil {
+			t.Errorf("NewTimeTagReceiver accepted %+v", opts)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package bb84
+
+import (
+	"errors"
+	"math"
+
+	"github.com/alan-christopher/bb84/go/bb84/bitmap"
+	"github.com/alan-christopher/bb84/go/bb84/photon"
+)
+
+// DefaultReconciliationEfficiency is a typical ratio of the bits leaked by
+// information reconciliation to the Shannon limit.
+var DefaultReconciliationEfficiency = 1.2
+
+// PlanOpts describes a link to plan key negotiation over.
+type PlanOpts struct {
+	// Peer holds the protocol settings to plan for, with the same defaults as
+	// NewPeer. Only the block sizes, MeasurementBatchBytes, epsilons,
+	// PulseAttrs, ConcentrationBound, InsecureAsymptotic and SampleProp are
+	// used.
+	Peer PeerOpts
+
+	// PMain is the probability that each of Alice and Bob chooses the main
+	// basis for any given pulse.
+	PMain float64
+
+	// Link describes the quantum channel. Its loss determines the detection
+	// rate, and its Misalignment and DarkCountProb the QBER.
+	Link photon.FiberLink
+
+	// ReconciliationEfficiency is the ratio of the bits leaked during
+	// information reconciliation to the Shannon limit, n*h(QBER). Defaults to
+	// DefaultReconciliationEfficiency.
+	ReconciliationEfficiency float64
+}
+
+// A KeyPlan describes the expected outcome of a round of key negotiation.
+type KeyPlan struct {
+	// Pulses is the number of pulses sent, and QBits the number of sifted
+	// detections among them.
+	Pulses int
+	QBits  int
+
+	// QBER is the expected error rate of sifted test basis detections.
+	QBER float64
+
+	// BitsLeaked is the expected number of bits disclosed during
+	// reconciliation.
+	BitsLeaked int
+
+	// ClassicalBytes approximates the bytes exchanged over the classical
+	// channel in both directions. Reconciliation is counted as disclosing
+	// each leaked bit once in each direction, and encoding overheads are
+	// ignored.
+	ClassicalBytes int
+
+	// Estimates holds the results of parameter estimation. Its KeyLen is the
+	// expected final key length, or zero if no key would be extracted.
+	// TestEstimates is as for Stats.
+	Estimates     Estimates
+	TestEstimates Estimates
+
+	// Epsilons reports how failure probabilities would be allotted. Its Auth
+	// only accounts for sifting, verification and, if the peer has set abort
+	// thresholds, abort decision messages.
+	Epsilons Epsilons
+}
+
+// Plan predicts the outcome of a round of key negotiation over the link
+// described by opts, without running the protocol: parameter estimation is run
+// on the expected, noise-free counts of detections and errors at each
+// intensity level. This makes it cheap to size links, and to compare
+// parameters, before touching hardware.
+func Plan(opts PlanOpts) (KeyPlan, error) {
+	peer := opts.Peer
+	if err := checkEstimationOpts(peer); err != nil {
+		return KeyPlan{}, err
+	}
+	if opts.PMain <= 0 || opts.PMain >= 1 {
+		return KeyPlan{}, errors.New("PMain must lie in (0, 1)")
+	}
+	nX, nZ, batchBytes := peer.sizes()
+	cb := peer.concentrationBound()
+	f := opts.ReconciliationEfficiency
+	if f == 0 {
+		f = DefaultReconciliationEfficiency
+	}
+	pa := peer.pulseAttrs()
+	levels, err := pa.decoyLevels(nil)
+	if err != nil {
+		return KeyPlan{}, err
+	}
+
+	// Per pulse, the probability of a detection at each intensity level, and
+	// of a detection being sifted into either basis.
+	link := opts.Link
+	detectionProb, errorProb := link.DetectionProb, link.ErrorProb
+	if pa.singlePhoton() {
+		detectionProb = func(float64) float64 { return link.SinglePhotonDetectionProb() }
+		errorProb = func(float64) float64 { return link.SinglePhotonErrorProb() }
+	}
+	detect := 0.0
+	for _, l := range pa.levels() {
+		detect += l.Prob * detectionProb(l.Mu)
+	}
+	pX, pZ := opts.PMain*opts.PMain, (1-opts.PMain)*(1-opts.PMain)
+	if detect*pX == 0 {
+		return KeyPlan{}, errors.New("link yields no detections")
+	}
+	batchBits := batchBytes * 8
+	batches := math.Ceil(math.Max(float64(nX)/(detect*pX), float64(nZ)/(detect*pZ)) / float64(batchBits))
+	pulses := batches * float64(batchBits)
+
+	// The proportion of each basis's detections disclosed for parameter
+	// estimation.
+	sampleMain, sampleTest := 0.0, 1.0
+	if peer.SampleProp > 0 {
+		sampleMain, sampleTest = peer.SampleProp, peer.SampleProp
+	}
+	var x, z tally
+	qbits, main := 0, 0
+	for _, l := range pa.levels() {
+		detections := pulses * l.Prob * detectionProb(l.Mu)
+		dX, dZ, e := detections*pX, detections*pZ, 0.0
+		if detections > 0 {
+			e = errorProb(l.Mu)
+		}
+		x.main = append(x.main, int(math.Round(dX*(1-sampleMain))))
+		x.test = append(x.test, int(math.Round(dZ*sampleTest)))
+		x.errors = append(x.errors, int(math.Round(dZ*sampleTest*e)))
+		z.main = append(z.main, int(math.Round(dZ*(1-sampleTest))))
+		z.test = append(z.test, int(math.Round(dX*sampleMain)))
+		z.errors = append(z.errors, int(math.Round(dX*sampleMain*e)))
+		qbits += int(math.Round(dX)) + int(math.Round(dZ))
+		main += x.main[len(x.main)-1] + z.main[len(z.main)-1]
+	}
+	blocks := []tally{x}
+	if sampleMain > 0 {
+		blocks = append(blocks, z)
+	}
+	budget := newEpsilonBudget(peer)
+	ests, eps := estimate(blocks, levels, cb, budget)
+	est := ests[0]
+	plan := KeyPlan{
+		Pulses: int(pulses),
+		QBits:  qbits,
+		QBER:   sampleQBER(blocks),
+	}
+	if len(ests) > 1 {
+		plan.TestEstimates = ests[1]
+	}
+	plan.BitsLeaked = int(math.Ceil(f * float64(main) * binaryEntropy(plan.QBER)))
+	if plan.QBER == 0 {
+		plan.BitsLeaked = 0
+	}
+	// Winnow discards the bits it discloses, rather than charging them
+	// against the safe key length, so the key is limited by what remains.
+	est.KeyLen = est.SafeKeyLen
+	if remaining := main - plan.BitsLeaked; remaining < est.KeyLen {
+		est.KeyLen = remaining
+	}
+	if est.KeyLen < 0 {
+		est.KeyLen = 0
+	}
+	plan.Estimates = est
+
+	// Each batch's basis announcements: Bob's dropped pulses, and both
+	// parties' bases and test bits for the pulses received, plus Alice's
+	// packed intensities. Then the abort decisions, if any, reconciliation,
+	// and the verification and extraction seeds and hashes.
+	received := detect * float64(batchBits)
+	sifting := batches * (float64(batchBits)/8 + float64(4+intensityBits(len(levels)))*received/8)
+	if sampleMain > 0 {
+		// Bob's choice of sample.
+		sifting += batches * received / 8
+	}
+	verLen := math.Ceil(math.Log2(1 / eps.Correct))
+	verification := float64(bitmap.BytesFor(main+int(verLen))+bitmap.BytesFor(main+est.KeyLen)) + 2*verLen/8
+	messages := 2*batches + 2
+	if peer.MaxQBER > 0 || peer.MaxPhaseError > 0 {
+		messages += 2
+	}
+	tagBytes := 0.0
+	for k := 1; k <= int(messages); k++ {
+		tagBytes += float64(bitmap.BytesFor(budget.tagBits(k)))
+	}
+	plan.ClassicalBytes = int(math.Ceil(sifting + 2*float64(plan.BitsLeaked)/8 + verification + messages*4 + tagBytes))
+	eps.Auth = budget.authSpent(messages)
+	plan.Epsilons = eps
+	return plan, nil
+}
@@ -0,0 +1,182 @@
+package bb84
+
+import (
+	"math"
+	"testing"
+
+	"github.com/alan-christopher/bb84/go/bb84/photon"
+)
+
+func TestPlan(t *testing.T) {
+	pa := PulseAttrs{Intensities: []Intensity{{Mu: 0, Prob: 0.2}, {Mu: 0.05, Prob: 0.2}, {Mu: 0.3, Prob: 0.6}}}
+	link := photon.FiberLink{
+		LengthKm:           10,
+		AttenuationDBPerKm: 0.2,
+		DetectorEfficiency: 0.6,
+		DarkCountProb:      1e-6,
+		Misalignment:       0.02,
+	}
+	opts := PlanOpts{Peer: PeerOpts{PulseAttrs: pa}, PMain: 0.5, Link: link}
+	plan, err := Plan(opts)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+
+	t.Run("matches negotiation", func(t *testing.T) {
+		chOpts := photon.SimulatedChannelOpts{PMain: opts.PMain, SendSeed: 1234, ReceiveSeed: 5678, Link: &link}
+		for _, l := range pa.Intensities {
+			chOpts.Intensities = append(chOpts.Intensities, l.Mu)
+			chOpts.IntensityProbs = append(chOpts.IntensityProbs, l.Prob)
+		}
+		sender, receiver := photon.NewSimulatedChannel(chOpts)
+		aRes, _ := negotiate(t, sender, receiver, pa, nil)
+		if aRes.err != nil {
+			t.Fatalf("Alice error: %v", aRes.err)
+		}
+		got := aRes.stats
+		batchBits := DefaultMeasurementBatchBytes * 8
+		if math.Abs(float64(got.Pulses-plan.Pulses)) > float64(batchBits) {
+			t.Errorf("planned %d pulses, but negotiation took %d", plan.Pulses, got.Pulses)
+		}
+		if want := float64(got.Estimates.KeyLen); math.Abs(float64(plan.Estimates.KeyLen)-want) > 0.1*want {
+			t.Errorf("planned a key length of %d, but negotiation produced %d", plan.Estimates.KeyLen, got.Estimates.KeyLen)
+		}
+		if want := float64(got.BytesSent + got.BytesRead); math.Abs(float64(plan.ClassicalBytes)-want) > 0.1*want {
+			t.Errorf("planned %d classical bytes, but negotiation exchanged %g", plan.ClassicalBytes, want)
+		}
+		if math.Abs(plan.QBER-got.QBER) > 0.01 {
+			t.Errorf("planned a QBER of %f, but negotiation observed %f", plan.QBER, got.QBER)
+		}
+	})
+
+	t.Run("distance", func(t *testing.T) {
+		far := opts
+		far.Link.LengthKm = 50
+		farPlan, err := Plan(far)
+		if err != nil {
+			t.Fatalf("Plan: %v", err)
+		}
+		if farPlan.Pulses <= plan.Pulses || farPlan.Estimates.KeyLen >= plan.Estimates.KeyLen {
+			t.Errorf("planned (%d pulses, %d bits) at 50km, want more pulses and fewer bits than (%d, %d) at 10km",
+				farPlan.Pulses, farPlan.Estimates.KeyLen, plan.Pulses, plan.Estimates.KeyLen)
+		}
+	})
+
+	t.Run("single photon", func(t *testing.T) {
+		sp := opts
+		sp.Peer.PulseAttrs, sp.Peer.SinglePhotonSource = PulseAttrs{}, true
+		spPlan, err := Plan(sp)
+		if err != nil {
+			t.Fatalf("Plan: %v", err)
+		}
+		sender, receiver := photon.NewSimulatedChannel(photon.SimulatedChannelOpts{
+			PMain:        sp.PMain,
+			SinglePhoton: true,
+			SendSeed:     1234,
+			ReceiveSeed:  5678,
+			Link:         &link,
+		})
+		aRes, _ := negotiate(t, sender, receiver, PulseAttrs{}, func(o *PeerOpts) {
+			o.SinglePhotonSource = true
+		})
+		if aRes.err != nil {
+			t.Fatalf("Alice error: %v", aRes.err)
+		}
+		// Winnow discards far more than the Shannon limit at such a low QBER,
+		// so only compare parameter estimation.
+		if want := float64(aRes.stats.Estimates.SafeKeyLen); math.Abs(float64(spPlan.Estimates.SafeKeyLen)-want) > 0.05*want {
+			t.Errorf("planned a safe key length of %d, but negotiation estimated %d",
+				spPlan.Estimates.SafeKeyLen, aRes.stats.Estimates.SafeKeyLen)
+		}
+	})
+
+	t.Run("sampling", func(t *testing.T) {
+		sampled := opts
+		sampled.Peer.SampleProp = 0.1
+		sampled.Peer.ConcentrationBound = Kato{}
+		sampledPlan, err := Plan(sampled)
+		if err != nil {
+			t.Fatalf("Plan: %v", err)
+		}
+		chOpts := photon.SimulatedChannelOpts{PMain: opts.PMain, SendSeed: 1234, ReceiveSeed: 5678, Link: &link}
+		for _, l := range pa.Intensities {
+			chOpts.Intensities = append(chOpts.Intensities, l.Mu)
+			chOpts.IntensityProbs = append(chOpts.IntensityProbs, l.Prob)
+		}
+		sender, receiver := photon.NewSimulatedChannel(chOpts)
+		aRes, _ := negotiate(t, sender, receiver, pa, func(o *PeerOpts) {
+			o.SampleProp = sampled.Peer.SampleProp
+			o.ConcentrationBound = sampled.Peer.ConcentrationBound
+		})
+		if aRes.err != nil {
+			t.Fatalf("Alice error: %v", aRes.err)
+		}
+		for _, c := range []struct {
+			name      string
+			got, want int
+		}{
+			{"safe key length", sampledPlan.Estimates.SafeKeyLen, aRes.stats.Estimates.SafeKeyLen},
+			{"test basis safe key length", sampledPlan.TestEstimates.SafeKeyLen, aRes.stats.TestEstimates.SafeKeyLen},
+		} {
+			if math.Abs(float64(c.got-c.want)) > 0.1*float64(c.want) {
+				t.Errorf("planned a %s of %d, but negotiation estimated %d", c.name, c.got, c.want)
+			}
+		}
+		unsampled := sampled
+		unsampled.Peer.SampleProp = 0
+		unsampledPlan, err := Plan(unsampled)
+		if err != nil {
+			t.Fatalf("Plan: %v", err)
+		}
+		if sampledPlan.Estimates.KeyLen <= unsampledPlan.Estimates.KeyLen {
+			t.Errorf("planned a key length of %d with sampling, want more than %d without",
+				sampledPlan.Estimates.KeyLen, unsampledPlan.Estimates.KeyLen)
+		}
+	})
+
+	t.Run("asymptotic", func(t *testing.T) {
+		// Finite-size effects should cost less, relatively, the longer the
+		// blocks.
+		prevCost := math.Inf(1)
+		for _, n := range []int{1e4, 1e5, 1e6} {
+			finite := opts
+			finite.Peer.MainBlockSize, finite.Peer.TestBlockSize = n, n
+			finitePlan, err := Plan(finite)
+			if err != nil {
+				t.Fatalf("Plan: %v", err)
+			}
+			asymptotic := finite
+			asymptotic.Peer.InsecureAsymptotic = true
+			asymptoticPlan, err := Plan(asymptotic)
+			if err != nil {
+				t.Fatalf("Plan: %v", err)
+			}
+			if asymptoticPlan.Estimates.Bound != "insecure-asymptotic" {
+				t.Errorf("got asymptotic estimates bound %q, want them flagged insecure", asymptoticPlan.Estimates.Bound)
+			}
+			cost := 1 - float64(finitePlan.Estimates.SafeKeyLen)/float64(asymptoticPlan.Estimates.SafeKeyLen)
+			if cost <= 0 || cost >= prevCost {
+				t.Errorf("finite-size effects cost %f of the asymptotic key at block size %d, want less than %f but more than nothing",
+					cost, n, prevCost)
+			}
+			prevCost = cost
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, o := range []PlanOpts{
+			{Peer: opts.Peer, PMain: 1, Link: link},
+			{Peer: PeerOpts{PulseAttrs: PulseAttrs{Intensities: []Intensity{{Mu: 0.1, Prob: 1}}}}, PMain: 0.5, Link: link},
+			{Peer: opts.Peer, PMain: 0.5},
+			{Peer: PeerOpts{PulseAttrs: pa, InsecureAsymptotic: true, ConcentrationBound: Kato{}}, PMain: 0.5, Link: link},
+			// The zero PulseAttrs only describes a single-photon source if one is
+			// asked for explicitly.
+			{Peer: PeerOpts{}, PMain: 0.5, Link: link},
+			{Peer: PeerOpts{PulseAttrs: pa, SinglePhotonSource: true}, PMain: 0.5, Link: link},
+		} {
+			if _, err := Plan(o); err == nil {
+				t.Errorf("Plan(%+v) succeeded", o)
+			}
+		}
+	})
+}
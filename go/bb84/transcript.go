@@ -0,0 +1,189 @@
+package bb84
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/alan-christopher/bb84/go/generated/bb84pb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// A transcriptWriter records classical messages to an underlying writer as a
+// sequence of length-prefixed TranscriptEntry protos. The length prefix is
+// encoded the same way protoFramer encodes it on the wire.
+type transcriptWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (tw *transcriptWriter) record(dir bb84pb.TranscriptEntry_Direction, m proto.Message, marshalled []byte) error {
+	return tw.write(&bb84pb.TranscriptEntry{
+		Direction:      dir,
+		TimestampNanos: time.Now().UnixNano(),
+		Type:           string(m.ProtoReflect().Descriptor().FullName()),
+		Message:        marshalled,
+	})
+}
+
+// recordRejected records a received frame which was rejected with err, where m
+// is the type of message expected.
+func (tw *transcriptWriter) recordRejected(m proto.Message, marshalled, mac []byte, err error) error {
+	return tw.write(&bb84pb.TranscriptEntry{
+		Direction:      bb84pb.TranscriptEntry_RECEIVED,
+		TimestampNanos: time.Now().UnixNano(),
+		Type:           string(m.ProtoReflect().Descriptor().FullName()),
+		Message:        marshalled,
+		Mac:            mac,
+		Error:          err.Error(),
+	})
+}
+
+func (tw *transcriptWriter) write(pb *bb84pb.TranscriptEntry) error {
+	entry, err := proto.Marshal(pb)
+	if err != nil {
+		return fmt.Errorf("recording transcript: %w", err)
+	}
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if err := binary.Write(tw.w, binary.LittleEndian, int32(len(entry))); err != nil {
+		return fmt.Errorf("recording transcript: %w", err)
+	}
+	if _, err := tw.w.Write(entry); err != nil {
+		return fmt.Errorf("recording transcript: %w", err)
+	}
+	return nil
+}
+
+// A TranscriptEntry is a single classical message recorded by a Peer.
+type TranscriptEntry struct {
+	// Sent is true if the recording peer sent Message, and false if it
+	// received it.
+	Sent    bool
+	Time    time.Time
+	Message proto.Message
+
+	// Err, if non-empty, reports why the recording peer rejected a received
+	// message. Raw and MAC then hold the frame as received, and Message is
+	// decoded from Raw if possible, or else empty.
+	Err      string
+	Raw, MAC []byte
+}
+
+// ReadTranscript decodes every entry of a transcript recorded via
+// PeerOpts.Transcript.
+func ReadTranscript(r io.Reader) ([]TranscriptEntry, error) {
+	var entries []TranscriptEntry
+	for {
+		var eLen int32
+		if err := binary.Read(r, binary.LittleEndian, &eLen); err != nil {
+			if errors.Is(err, io.EOF) {
+				return entries, nil
+			}
+			return nil, err
+		}
+		buf := make([]byte, eLen)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, fmt.Errorf("reading transcript entry %d: %w", len(entries), err)
+		}
+		pb := new(bb84pb.TranscriptEntry)
+		if err := proto.Unmarshal(buf, pb); err != nil {
+			return nil, fmt.Errorf("decoding transcript entry %d: %w", len(entries), err)
+		}
+		mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(pb.Type))
+		if err != nil {
+			return nil, fmt.Errorf("decoding transcript entry %d: %w", len(entries), err)
+		}
+		m := mt.New().Interface()
+		if err := proto.Unmarshal(pb.Message, m); err != nil {
+			if pb.Error == "" {
+				return nil, fmt.Errorf("decoding transcript entry %d: %w", len(entries), err)
+			}
+			m = mt.New().Interface()
+		}
+		e := TranscriptEntry{
+			Sent:    pb.Direction == bb84pb.TranscriptEntry_SENT,
+			Time:    time.Unix(0, pb.TimestampNanos),
+			Message: m,
+			Err:     pb.Error,
+		}
+		if e.Err != "" {
+			e.Raw, e.MAC = pb.Message, pb.Mac
+		}
+		entries = append(entries, e)
+	}
+}
+
+// A DivergenceError reports that a live peer did not send the message a
+// Replayer expected of it.
+type DivergenceError struct {
+	// Index is the position of the expected message in the transcript.
+	Index     int
+	Want, Got proto.Message
+}
+
+func (d *DivergenceError) Error() string {
+	return fmt.Sprintf("transcript entry %d: got %v, want %v", d.Index, d.Got, d.Want)
+}
+
+// A Replayer impersonates one peer of a recorded key negotiation, so that the
+// other peer can be re-run against it, e.g. to debug a failed negotiation.
+type Replayer struct {
+	sideChannel *protoFramer
+	entries     []TranscriptEntry
+}
+
+// NewReplayer returns a Replayer which plays back the recorded peer's side of
+// transcript. opts describes the recorded peer: its ClassicalChannel should be
+// connected to the live peer, and its Secret, EpsilonAuth, EpsilonSecurity,
+// MeasurementBatchBytes, MainBlockSize, PulseAttrs and SinglePhotonSource must
+// match those used during the recording. All other fields are ignored.
+func NewReplayer(transcript io.Reader, opts PeerOpts) (*Replayer, error) {
+	if opts.ClassicalChannel == nil {
+		return nil, errors.New("must provide ClassicalChannel")
+	}
+	if opts.Secret == nil {
+		return nil, errors.New("must provide Secret")
+	}
+	entries, err := ReadTranscript(transcript)
+	if err != nil {
+		return nil, err
+	}
+	opts.Transcript = nil
+	pf, err := newSideChannel(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Replayer{sideChannel: pf, entries: entries}, nil
+}
+
+// Replay walks the transcript in order, sending each message the recorded peer
+// sent and checking that each message it received is matched by the live
+// peer. It stops with a *DivergenceError at the first mismatch. Where the
+// recorded peer rejected a message, and so went no further, Replay stops too,
+// returning the error the live peer's message is rejected with, or a
+// *DivergenceError if it is accepted.
+func (r *Replayer) Replay() (Stats, error) {
+	var stats Stats
+	for i, e := range r.entries {
+		if e.Sent {
+			if err := r.sideChannel.Write(e.Message, &stats); err != nil {
+				return stats, fmt.Errorf("replaying transcript entry %d: %w", i, err)
+			}
+			continue
+		}
+		got := e.Message.ProtoReflect().New().Interface()
+		if err := r.sideChannel.Read(got, &stats); err != nil {
+			return stats, fmt.Errorf("replaying transcript entry %d: %w", i, err)
+		}
+		if e.Err != "" || !proto.Equal(got, e.Message) {
+			return stats, &DivergenceError{Index: i, Want: e.Message, Got: got}
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,241 @@
+package bb84
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/alan-christopher/bb84/go/bb84/photon"
+	"github.com/alan-christopher/bb84/go/generated/bb84pb"
+	"google.golang.org/protobuf/proto"
+)
+
+// exchange has alice write each of msgs in turn, and bob read them.
+func exchange(t *testing.T, alice, bob *protoFramer, msgs []proto.Message) {
+	t.Helper()
+	for _, m := range msgs {
+		wErr := make(chan error, 1)
+		go func() { wErr <- alice.Write(m, &Stats{}) }()
+		got := m.ProtoReflect().New().Interface()
+		if err := bob.Read(got, &Stats{}); err != nil {
+			t.Fatalf("error reading message: %v", err)
+		}
+		if err := <-wErr; err != nil {
+			t.Fatalf("error writing message: %v", err)
+		}
+	}
+}
+
+func TestTranscriptRecordAndReplay(t *testing.T) {
+	otp := make([]byte, 1<<20)
+	rand.Read(otp)
+	opts := PeerOpts{
+		MeasurementBatchBytes: 16,
+		MainBlockSize:         16,
+		EpsilonAuth:           1e-6,
+	}
+	msgs := []proto.Message{
+		&bb84pb.ParityAnnouncement{Parities: &bb84pb.DenseBitArray{Bits: []byte{1, 2}, Len: 16}},
+		&bb84pb.ErrorCorrectionFinished{ExtractSeed: []byte{3, 4, 5}},
+	}
+
+	// Record alice's side of a short exchange.
+	var transcript bytes.Buffer
+	l, r := net.Pipe()
+	aOpts, bOpts := opts, opts
+	aOpts.ClassicalChannel, aOpts.Secret, aOpts.Transcript = l, bytes.NewBuffer(otp), &transcript
+	bOpts.ClassicalChannel, bOpts.Secret = r, bytes.NewBuffer(otp)
+	alice, err := newSideChannel(aOpts)
+	if err != nil {
+		t.Fatalf("building alice: %v", err)
+	}
+	bob, err := newSideChannel(bOpts)
+	if err != nil {
+		t.Fatalf("building bob: %v", err)
+	}
+	exchange(t, alice, bob, msgs[:1])
+	exchange(t, bob, alice, msgs[1:])
+
+	entries, err := ReadTranscript(bytes.NewReader(transcript.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadTranscript: %v", err)
+	}
+	if len(entries) != len(msgs) {
+		t.Fatalf("got %d transcript entries, want %d", len(entries), len(msgs))
+	}
+	if !entries[0].Sent || entries[1].Sent {
+		t.Errorf("transcript directions wrong: %v, %v", entries[0].Sent, entries[1].Sent)
+	}
+	for i, e := range entries {
+		if !proto.Equal(e.Message, msgs[i]) {
+			t.Errorf("entry %d: got %v, want %v", i, e.Message, msgs[i])
+		}
+	}
+
+	tcs := []struct {
+		name       string
+		bobSends   proto.Message
+		wantDiverg bool
+	}{
+		{"faithful", msgs[1], false},
+		{"diverged", &bb84pb.ErrorCorrectionFinished{ExtractSeed: []byte{6}}, true},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			l, r := net.Pipe()
+			rOpts, bOpts := opts, opts
+			rOpts.ClassicalChannel, rOpts.Secret = l, bytes.NewBuffer(otp)
+			bOpts.ClassicalChannel, bOpts.Secret = r, bytes.NewBuffer(otp)
+			replayer, err := NewReplayer(bytes.NewReader(transcript.Bytes()), rOpts)
+			if err != nil {
+				t.Fatalf("NewReplayer: %v", err)
+			}
+			bob, err := newSideChannel(bOpts)
+			if err != nil {
+				t.Fatalf("building bob: %v", err)
+			}
+			rErr := make(chan error, 1)
+			go func() {
+				_, err := replayer.Replay()
+				rErr <- err
+			}()
+			got := new(bb84pb.ParityAnnouncement)
+			if err := bob.Read(got, &Stats{}); err != nil {
+				t.Fatalf("reading replayed message: %v", err)
+			}
+			if !proto.Equal(got, msgs[0]) {
+				t.Errorf("replayed message mangled: got %v, want %v", got, msgs[0])
+			}
+			if err := bob.Write(tc.bobSends, &Stats{}); err != nil {
+				t.Fatalf("writing to replayer: %v", err)
+			}
+			err = <-rErr
+			var d *DivergenceError
+			if tc.wantDiverg != errors.As(err, &d) {
+				t.Errorf("Replay() == %v, want divergence: %v", err, tc.wantDiverg)
+			}
+			if !tc.wantDiverg && err != nil {
+				t.Errorf("Replay() == %v", err)
+			}
+		})
+	}
+}
+
+func TestTranscriptRecordsRejected(t *testing.T) {
+	otp, otp2 := make([]byte, 1<<20), make([]byte, 1<<20)
+	rand.Read(otp)
+	rand.Read(otp2)
+	opts := PeerOpts{
+		MeasurementBatchBytes: 16,
+		MainBlockSize:         16,
+		EpsilonAuth:           1e-6,
+	}
+	var transcript bytes.Buffer
+	l, r := net.Pipe()
+	aOpts, bOpts := opts, opts
+	aOpts.ClassicalChannel, aOpts.Secret = l, bytes.NewBuffer(otp)
+	bOpts.ClassicalChannel, bOpts.Secret, bOpts.Transcript = r, bytes.NewBuffer(otp), &transcript
+	alice, err := newSideChannel(aOpts)
+	if err != nil {
+		t.Fatalf("building alice: %v", err)
+	}
+	bob, err := newSideChannel(bOpts)
+	if err != nil {
+		t.Fatalf("building bob: %v", err)
+	}
+	// Only the one-time pads disagree.
+	bob.secret = bytes.NewBuffer(otp2)
+
+	msg := &bb84pb.ParityAnnouncement{Parities: &bb84pb.DenseBitArray{Bits: []byte{1, 2}, Len: 16}}
+	wErr := make(chan error, 1)
+	go func() { wErr <- alice.Write(msg, &Stats{}) }()
+	if err := bob.Read(new(bb84pb.ParityAnnouncement), &Stats{}); !errors.Is(err, ErrInvalidMAC) {
+		t.Fatalf("Read() == %v, want %v", err, ErrInvalidMAC)
+	}
+	if err := <-wErr; err != nil {
+		t.Fatalf("error writing message: %v", err)
+	}
+
+	entries, err := ReadTranscript(bytes.NewReader(transcript.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadTranscript: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d transcript entries, want 1", len(entries))
+	}
+	e := entries[0]
+	raw, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatalf("proto.Marshal: %v", err)
+	}
+	if e.Sent || e.Err == "" || !bytes.Equal(e.Raw, raw) || len(e.MAC) == 0 || !proto.Equal(e.Message, msg) {
+		t.Errorf("got entry %+v, want the rejected frame received, with its error", e)
+	}
+}
+
+func TestTranscriptReplayNegotiation(t *testing.T) {
+	pa := threeLevelAttrs()
+	otp := make([]byte, 1<<23)
+	rand.Read(otp)
+
+	// Record Alice's side of a negotiation, and the batches Bob received.
+	var transcript, batches bytes.Buffer
+	sender, receiver := photon.NewSimulatedChannel(decoyChannelOpts(pa.levels()))
+	recorder, err := photon.NewRecordingReceiver(receiver, &batches)
+	if err != nil {
+		t.Fatalf("NewRecordingReceiver: %v", err)
+	}
+	aRes, bRes := negotiate(t, sender, recorder, pa, func(o *PeerOpts) {
+		o.Secret = bytes.NewBuffer(otp)
+		if o.Sender != nil {
+			o.Transcript = &transcript
+		}
+	})
+	checkKeysAgree(t, aRes, bRes)
+
+	// Re-run Bob against the recording.
+	l, r := net.Pipe()
+	replayer, err := NewReplayer(bytes.NewReader(transcript.Bytes()), PeerOpts{
+		ClassicalChannel: l,
+		Secret:           bytes.NewBuffer(otp),
+		PulseAttrs:       pa,
+	})
+	if err != nil {
+		t.Fatalf("NewReplayer: %v", err)
+	}
+	replayReceiver, err := photon.NewReplayReceiver(bytes.NewReader(batches.Bytes()))
+	if err != nil {
+		t.Fatalf("NewReplayReceiver: %v", err)
+	}
+	bob, err := NewPeer(PeerOpts{
+		Receiver:         replayReceiver,
+		ClassicalChannel: r,
+		Rand:             rand.New(rand.NewSource(1337)),
+		Secret:           bytes.NewBuffer(otp),
+		WinnowOpts: &WinnowOpts{
+			Iters:    []int{3, 3, 3, 4, 6, 7, 7, 7},
+			SyncRand: rand.New(rand.NewSource(17)),
+		},
+		PulseAttrs: pa,
+	})
+	if err != nil {
+		t.Fatalf("Building Bob: %v", err)
+	}
+	rErr := make(chan error, 1)
+	go func() {
+		_, err := replayer.Replay()
+		rErr <- err
+	}()
+	key, _, err := bob.NegotiateKey()
+	if err != nil {
+		t.Fatalf("Bob error against replay: %v", err)
+	}
+	if err := <-rErr; err != nil {
+		t.Errorf("Replay() == %v", err)
+	}
+	if !bytes.Equal(key.Data(), bRes.key.Data()) {
+		t.Errorf("Bob's replayed key differs from the original")
+	}
+}
@@ -32,17 +32,47 @@ var (
 	pLo   = flag.Float64Slice("pLo", []float64{0.34}, "The proportion of low intensity photon pulses.")
 	pMed  = flag.Float64Slice("pMed", []float64{0.33}, "The proportion of medium intensity photon pulses.")
 	pHi   = flag.Float64Slice("pHi", []float64{0.33}, "The proportion of high intensity photon pulses.")
-	qber  = flag.Float64Slice("qber", []float64{0.01}, "The qbers to observe when bases align.")
+	qber  = flag.Float64Slice("qber", []float64{0.01}, "The qbers to observe when bases align. Ignored if --fiber is set.")
+	seed  = flag.Int64("seed", 1234, "The seed from which all of each experiment's randomness is derived.")
+
+	fiber    = flag.Bool("fiber", false, "Simulate a physical fiber link, rather than injecting a fixed qber.")
+	km       = flag.Float64Slice("km", []float64{25}, "The length of the fiber link, in km.")
+	dbPerKm  = flag.Float64Slice("dbPerKm", []float64{0.2}, "The attenuation of the fiber link, in dB/km.")
+	lossDB   = flag.Float64Slice("lossDB", []float64{1}, "The fixed insertion loss of the fiber link, in dB.")
+	detEff   = flag.Float64Slice("detEff", []float64{0.6}, "The efficiency of the receiver's detectors.")
+	pDark    = flag.Float64Slice("pDark", []float64{1e-6}, "The probability of a dark count per pulse.")
+	misalign = flag.Float64Slice("misalign", []float64{0.01}, "The optical misalignment error rate of the fiber link.")
+
+	bound = flag.StringSlice("bound", []string{"hoeffding"},
+		"The concentration bound to use in parameter estimation: hoeffding, chernoff, or kato. "+
+			"insecure-asymptotic instead computes the asymptotic GLLP key length, with no finite-size "+
+			"corrections; its keys are NOT secure, but comparing it with a bound shows what finite-size effects cost.")
+	sampleProp = flag.Float64Slice("sampleProp", []float64{0},
+		"The proportion of measurements in each basis to disclose for parameter estimation, keeping the rest for "+
+			"key. Zero discloses every test basis measurement instead.")
 )
 
+// bounds maps each --bound to its ConcentrationBound.
+var bounds = map[string]bb84.ConcentrationBound{
+	"hoeffding": bb84.Hoeffding{},
+	"chernoff":  bb84.Chernoff{},
+	"kato":      bb84.Kato{},
+}
+
+// insecureAsymptotic is the --bound which selects PeerOpts.InsecureAsymptotic
+// in place of a ConcentrationBound.
+const insecureAsymptotic = "insecure-asymptotic"
+
 var (
-	inputs = []string{"qBatch", "nX", "nZ", "pX", "muLo", "muMed", "muHi", "pLo", "pMed", "pHi", "qber"}
+	inputs = []string{"qBatch", "nX", "nZ", "pX", "muLo", "muMed", "muHi", "pLo", "pMed", "pHi", "qber",
+		"km", "dbPerKm", "lossDB", "detEff", "pDark", "misalign", "bound", "sampleProp"}
 	// TODO: consider using reflection to pull this out of the Experiment data
 	//   type.
 	columns = []string{"QBatchBytes", "NX", "NZ", "PX", "MuLo", "MuMed", "MuHi",
-		"PLo", "PMed", "PHi", "QBER", "Pulses", "QBits", "EmpiricalQBER", "KeyBits",
-		"AliceMessages", "BobMessages", "AliceClassicalBytes", "BobClassicalBytes",
-		"Succeeded"}
+		"PLo", "PMed", "PHi", "QBER", "LengthKm", "DBPerKm", "InsertionLossDB",
+		"DetectorEfficiency", "DarkCountProb", "Misalignment", "Bound", "SampleProp", "Pulses", "QBits", "EmpiricalQBER", "KeyBits",
+		"SafeKeyBits", "PhaseErrorBound", "AliceMessages", "BobMessages",
+		"AliceClassicalBytes", "BobClassicalBytes", "Succeeded"}
 )
 
 // An Experiment packages together the result of benchmarking a single
@@ -56,11 +86,27 @@ type Experiment struct {
 	PLo, PMed, PHi    float64
 	QBER              float64
 
+	// Fields describing the fiber link, only meaningful if --fiber is set.
+	LengthKm           float64
+	DBPerKm            float64
+	InsertionLossDB    float64
+	DetectorEfficiency float64
+	DarkCountProb      float64
+	Misalignment       float64
+
+	// Bound names the concentration bound used in parameter estimation.
+	Bound string
+
+	// SampleProp is as for bb84.PeerOpts.
+	SampleProp float64
+
 	// Fields corresponding to experiment results
 	Pulses              int
 	QBits               int
 	EmpiricalQBER       float64
 	KeyBits             int
+	SafeKeyBits         int
+	PhaseErrorBound     float64
 	AliceMessages       int
 	BobMessages         int
 	AliceClassicalBytes int
@@ -89,6 +135,16 @@ func main() {
 			PMed:        args[inpIndex("pMed")].(float64),
 			PHi:         args[inpIndex("pHi")].(float64),
 			QBER:        args[inpIndex("qber")].(float64),
+
+			LengthKm:           args[inpIndex("km")].(float64),
+			DBPerKm:            args[inpIndex("dbPerKm")].(float64),
+			InsertionLossDB:    args[inpIndex("lossDB")].(float64),
+			DetectorEfficiency: args[inpIndex("detEff")].(float64),
+			DarkCountProb:      args[inpIndex("pDark")].(float64),
+			Misalignment:       args[inpIndex("misalign")].(float64),
+
+			Bound:      args[inpIndex("bound")].(string),
+			SampleProp: args[inpIndex("sampleProp")].(float64),
 		}
 		if err := bench(exp); err != nil {
 			log.Printf("Benching %v: %v", exp, err)
@@ -109,33 +165,57 @@ func inpIndex(v string) int {
 }
 
 func bench(exp *Experiment) error {
+	asymptotic := exp.Bound == insecureAsymptotic
+	cb, ok := bounds[exp.Bound]
+	if !ok && !asymptotic {
+		return fmt.Errorf("unknown concentration bound %q", exp.Bound)
+	}
 	l, r := net.Pipe()
 	pa := bb84.PulseAttrs{}
 	pa.MuLo, pa.MuMed, pa.MuHi = exp.MuLo, exp.MuMed, exp.MuHi
 	pa.ProbLo, pa.ProbMed, pa.ProbHi = exp.PLo, exp.PMed, exp.PHi
-	sender, receiver := photon.NewSimulatedChannel(
-		exp.PX,                         // pMain
-		pa.MuLo,                        // muLo
-		pa.MuMed,                       // muMed
-		pa.MuHi,                        // muHi
-		pa.ProbLo,                      // pLo
-		pa.ProbMed,                     // pMed
-		pa.ProbHi,                      // pHi
-		rand.New(rand.NewSource(1234)), // sendrand
-		rand.New(rand.NewSource(5678)), // receiveRand
-	)
+	// Every experiment draws from an identically seeded source, so that results
+	// are reproducible and only vary with the parameters being benchmarked.
+	seeds := rand.New(rand.NewSource(*seed))
+	var link *photon.FiberLink
+	if *fiber {
+		link = &photon.FiberLink{
+			LengthKm:           exp.LengthKm,
+			AttenuationDBPerKm: exp.DBPerKm,
+			InsertionLossDB:    exp.InsertionLossDB,
+			DetectorEfficiency: exp.DetectorEfficiency,
+			DarkCountProb:      exp.DarkCountProb,
+			Misalignment:       exp.Misalignment,
+		}
+	}
+	sender, receiver := photon.NewSimulatedChannel(photon.SimulatedChannelOpts{
+		PMain:       exp.PX,
+		MuLo:        pa.MuLo,
+		MuMed:       pa.MuMed,
+		MuHi:        pa.MuHi,
+		PLo:         pa.ProbLo,
+		PMed:        pa.ProbMed,
+		PHi:         pa.ProbHi,
+		SendSeed:    seeds.Int63(),
+		ReceiveSeed: seeds.Int63(),
+		Link:        link,
+	})
 	otp := make([]byte, 1<<23) // TODO: the amount of otp to create should be derived from experiment parameters
-	rand.Read(otp)
+	seeds.Read(otp)
+	syncSeed := seeds.Int63()
 	a, err := bb84.NewPeer(bb84.PeerOpts{
 		Sender:           sender,
 		ClassicalChannel: l,
-		Rand:             rand.New(rand.NewSource(42)),
+		Rand:             rand.New(rand.NewSource(seeds.Int63())),
 		Secret:           bytes.NewBuffer(otp),
 		WinnowOpts: &bb84.WinnowOpts{
 			Iters:    []int{3, 3, 3, 4, 6, 7, 7, 7},
-			SyncRand: rand.New(rand.NewSource(17)),
+			SyncRand: rand.New(rand.NewSource(syncSeed)),
 		},
 		PulseAttrs:            pa,
+		ConcentrationBound:    cb,
+		InsecureAsymptotic:    asymptotic,
+		SampleProp:            exp.SampleProp,
 		MeasurementBatchBytes: exp.QBatchBytes,
 		MainBlockSize:         exp.NX,
 		TestBlockSize:         exp.NZ,
@@ -146,13 +226,16 @@ func bench(exp *Experiment) error {
 	b, err := bb84.NewPeer(bb84.PeerOpts{
 		Receiver:         receiver,
 		ClassicalChannel: r,
-		Rand:             rand.New(rand.NewSource(1337)),
+		Rand:             rand.New(rand.NewSource(seeds.Int63())),
 		Secret:           bytes.NewBuffer(otp),
 		WinnowOpts: &bb84.WinnowOpts{
 			Iters:    []int{3, 3, 3, 4, 6, 7, 7, 7},
-			SyncRand: rand.New(rand.NewSource(17)),
+			SyncRand: rand.New(rand.NewSource(syncSeed)),
 		},
 		PulseAttrs:            pa,
+		ConcentrationBound:    cb,
+		InsecureAsymptotic:    asymptotic,
+		SampleProp:            exp.SampleProp,
 		MeasurementBatchBytes: exp.QBatchBytes,
 		MainBlockSize:         exp.NX,
 		TestBlockSize:         exp.NZ,
@@ -160,24 +243,41 @@ func bench(exp *Experiment) error {
 	if err != nil {
 		return err
 	}
-	batchBits := exp.QBatchBytes * 8
-	legitErrs := bitmap.NewDense(nil, batchBits)
-	for i := 0; i < int(float64(batchBits)*exp.QBER); i++ {
-		legitErrs.Flip(i)
+	if link == nil {
+		batchBits := exp.QBatchBytes * 8
+		legitErrs := bitmap.NewDense(nil, batchBits)
+		for i := 0; i < int(float64(batchBits)*exp.QBER); i++ {
+			legitErrs.Flip(i)
+		}
+		legitErrs.Shuffle(rand.New(rand.NewSource(seeds.Int63())))
+		receiver.Errors = legitErrs.Data()
 	}
-	legitErrs.Shuffle(rand.New(rand.NewSource(99)))
-	receiver.Errors = legitErrs.Data()
 
-	go b.NegotiateKey()
+	bStats := make(chan bb84.Stats, 1)
+	go func() {
+		_, stats, err := b.NegotiateKey()
+		if err != nil {
+			r.Close()
+		}
+		bStats <- stats
+	}()
 	k, stats, err := a.NegotiateKey()
+	if err != nil {
+		// Bob may be blocked waiting on a message that will never come, and
+		// vice versa.
+		l.Close()
+	}
+	bobStats := <-bStats
 	exp.Pulses = stats.Pulses
 	exp.QBits = stats.QBits
 	exp.EmpiricalQBER = stats.QBER
 	exp.KeyBits = k.Size()
+	exp.SafeKeyBits = stats.Estimates.SafeKeyLen
+	exp.PhaseErrorBound = stats.Estimates.PhaseError
 	exp.AliceMessages = stats.MessagesSent
-	exp.BobMessages = stats.MessagesSent
+	exp.BobMessages = bobStats.MessagesSent
 	exp.AliceClassicalBytes = stats.BytesSent
-	exp.BobClassicalBytes = stats.BytesRead
+	exp.BobClassicalBytes = bobStats.BytesSent
 	exp.Succeeded = err == nil
 	return err
 }
@@ -204,6 +304,10 @@ func lookupInput(name string) []interface{} {
 		for _, val := range v {
 			r = append(r, val)
 		}
+	} else if v, err := flag.CommandLine.GetStringSlice(name); err == nil {
+		for _, val := range v {
+			r = append(r, val)
+		}
 	} else {
 		log.Fatalf("Unknown type for input %s", name)
 	}
@@ -0,0 +1,126 @@
+// qchand hosts a simulated quantum channel in its own process, serving the
+// sending end to one client and the receiving end to another over unix
+// sockets. This lets separate Alice and Bob processes run against the
+// simulation exactly as they would against hardware, by wrapping their
+// connections with photon.NewRemoteSender and photon.NewRemoteReceiver.
+//
+// Each end accepts one client at a time. A client which disconnects may be
+// replaced by a new one, which picks up where the channel left off.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+
+	"github.com/alan-christopher/bb84/go/bb84/photon"
+	flag "github.com/spf13/pflag"
+)
+
+var (
+	senderSocket   = flag.String("senderSocket", "/tmp/bb84-sender.sock", "The unix socket on which to serve the sending end of the channel.")
+	receiverSocket = flag.String("receiverSocket", "/tmp/bb84-receiver.sock", "The unix socket on which to serve the receiving end of the channel.")
+
+	pX    = flag.Float64("pX", 0.7, "The probability of sending a bit in the main basis.")
+	muLo  = flag.Float64("muLo", 0.05, "The mean photons per pulse of the low intensity preparation.")
+	muMed = flag.Float64("muMed", 0.1, "The mean photons per pulse of the medium intensity preparation.")
+	muHi  = flag.Float64("muHi", 0.3, "The mean photons per pulse of the high intensity preparation.")
+	pLo   = flag.Float64("pLo", 0.34, "The proportion of low intensity photon pulses.")
+	pMed  = flag.Float64("pMed", 0.33, "The proportion of medium intensity photon pulses.")
+	pHi   = flag.Float64("pHi", 0.33, "The proportion of high intensity photon pulses.")
+	seed  = flag.Int64("seed", 1234, "The seed from which the channel's randomness is derived.")
+
+	fiber    = flag.Bool("fiber", false, "Simulate a physical fiber link, rather than a lossless one.")
+	km       = flag.Float64("km", 25, "The length of the fiber link, in km.")
+	dbPerKm  = flag.Float64("dbPerKm", 0.2, "The attenuation of the fiber link, in dB/km.")
+	lossDB   = flag.Float64("lossDB", 1, "The fixed insertion loss of the fiber link, in dB.")
+	detEff   = flag.Float64("detEff", 0.6, "The efficiency of the receiver's detectors.")
+	pDark    = flag.Float64("pDark", 1e-6, "The probability of a dark count per pulse.")
+	misalign = flag.Float64("misalign", 0.01, "The optical misalignment error rate of the fiber link.")
+)
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves the channel until either listener fails. It returns errors
+// rather than exiting, so that the listeners' sockets are always cleaned up.
+func run() error {
+	var link *photon.FiberLink
+	if *fiber {
+		link = &photon.FiberLink{
+			LengthKm:           *km,
+			AttenuationDBPerKm: *dbPerKm,
+			InsertionLossDB:    *lossDB,
+			DetectorEfficiency: *detEff,
+			DarkCountProb:      *pDark,
+			Misalignment:       *misalign,
+		}
+	}
+	sender, receiver := photon.NewSimulatedChannel(photon.SimulatedChannelOpts{
+		PMain:       *pX,
+		MuLo:        *muLo,
+		MuMed:       *muMed,
+		MuHi:        *muHi,
+		PLo:         *pLo,
+		PMed:        *pMed,
+		PHi:         *pHi,
+		SendSeed:    *seed,
+		ReceiveSeed: *seed + 1,
+		Link:        link,
+	})
+
+	sl, err := listen(*senderSocket)
+	if err != nil {
+		return fmt.Errorf("listening for senders: %w", err)
+	}
+	defer sl.Close()
+	rl, err := listen(*receiverSocket)
+	if err != nil {
+		return fmt.Errorf("listening for receivers: %w", err)
+	}
+	defer rl.Close()
+
+	errs := make(chan error, 2)
+	go func() {
+		errs <- serveEach(sl, func(c net.Conn) error { return photon.ServeSender(c, sender) })
+	}()
+	go func() {
+		errs <- serveEach(rl, func(c net.Conn) error { return photon.ServeReceiver(c, receiver) })
+	}()
+	log.Printf("Serving sender on %s and receiver on %s", *senderSocket, *receiverSocket)
+	if err := <-errs; err != nil {
+		return fmt.Errorf("accepting connections: %w", err)
+	}
+	return nil
+}
+
+// listen listens on a unix socket at path, first removing any stale socket a
+// previous run left behind.
+func listen(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return net.Listen("unix", path)
+}
+
+// serveEach accepts connections from l one at a time, serving each until it
+// closes.
+func serveEach(l net.Listener, serve func(net.Conn) error) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		log.Printf("Accepted connection on %s", l.Addr())
+		if err := serve(c); err != nil {
+			log.Printf("Serving %s: %v", l.Addr(), err)
+		}
+		c.Close()
+	}
+}
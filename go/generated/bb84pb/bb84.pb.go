@@ -20,6 +20,52 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+type TranscriptEntry_Direction int32
+
+const (
+	TranscriptEntry_SENT     TranscriptEntry_Direction = 0
+	TranscriptEntry_RECEIVED TranscriptEntry_Direction = 1
+)
+
+// Enum value maps for TranscriptEntry_Direction.
+var (
+	TranscriptEntry_Direction_name = map[int32]string{
+		0: "SENT",
+		1: "RECEIVED",
+	}
+	TranscriptEntry_Direction_value = map[string]int32{
+		"SENT":     0,
+		"RECEIVED": 1,
+	}
+)
+
+func (x TranscriptEntry_Direction) Enum() *TranscriptEntry_Direction {
+	p := new(TranscriptEntry_Direction)
+	*p = x
+	return p
+}
+
+func (x TranscriptEntry_Direction) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (TranscriptEntry_Direction) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_bb84_proto_enumTypes[0].Descriptor()
+}
+
+func (TranscriptEntry_Direction) Type() protoreflect.EnumType {
+	return &file_proto_bb84_proto_enumTypes[0]
+}
+
+func (x TranscriptEntry_Direction) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use TranscriptEntry_Direction.Descriptor instead.
+func (TranscriptEntry_Direction) EnumDescriptor() ([]byte, []int) {
+	return file_proto_bb84_proto_rawDescGZIP(), []int{11, 0}
+}
+
 type DenseBitArray struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -139,14 +185,37 @@ type BasisAnnouncement struct {
 	Bases *DenseBitArray `protobuf:"bytes,1,opt,name=bases,proto3" json:"bases,omitempty"`
 	// Specifies which pulses in a photon-sequence were lost.
 	Dropped *DenseBitArray `protobuf:"bytes,2,opt,name=dropped,proto3" json:"dropped,omitempty"`
-	// Specifies the values measured in the Z, or test, basis.
+	// Specifies the values measured in the Z, or test, basis, or of the
+	// sampled pulses if sampled is present.
 	TestBits *DenseBitArray `protobuf:"bytes,3,opt,name=test_bits,json=testBits,proto3" json:"test_bits,omitempty"`
-	// Specifies which photons were sent on weak pulses.
-	Lo *DenseBitArray `protobuf:"bytes,4,opt,name=lo,proto3" json:"lo,omitempty"`
-	// Specifies which photons were sent on medium pulses.
-	Med *DenseBitArray `protobuf:"bytes,5,opt,name=med,proto3" json:"med,omitempty"`
-	// Specifies which photons were sent on strong pulses.
-	Hi *DenseBitArray `protobuf:"bytes,6,opt,name=hi,proto3" json:"hi,omitempty"`
+	// Identifies the pulses which make up the batch, if the announcer's
+	// hardware tracks them.
+	Pulses *PulseRange `protobuf:"bytes,7,opt,name=pulses,proto3" json:"pulses,omitempty"`
+	// The alignment offset the sender has applied to the batch, if any: pulse
+	// i of the receiver's batch corresponds to pulse i + offset of the
+	// sender's. Only pulses present in both batches are announced.
+	Offset int32 `protobuf:"varint,8,opt,name=offset,proto3" json:"offset,omitempty"`
+	// The range of mean photon numbers measured at each intensity level over
+	// the batch, if the sender monitors them.
+	MonitoredIntensities []*IntensityRange `protobuf:"bytes,10,rep,name=monitored_intensities,json=monitoredIntensities,proto3" json:"monitored_intensities,omitempty"`
+	// The received pulses whose measured values test_bits discloses, whatever
+	// their basis, when only a random sample is disclosed. If absent, test_bits
+	// discloses the values of all pulses measured in the test basis.
+	Sampled *DenseBitArray `protobuf:"bytes,11,opt,name=sampled,proto3" json:"sampled,omitempty"`
+	// In the sender's announcement, the receiver's disclosed measurements
+	// spent searching for the alignment offset, which are excluded from
+	// parameter estimation. Indexed like the receiver's announcement, i.e.
+	// before the offset is applied.
+	Aligned *DenseBitArray `protobuf:"bytes,12,opt,name=aligned,proto3" json:"aligned,omitempty"`
+	// Whether the announcer has set thresholds on its estimates at which it
+	// aborts the round. Unless either peer has, they skip exchanging
+	// EstimationFinished messages.
+	AbortLimits bool `protobuf:"varint,13,opt,name=abort_limits,json=abortLimits,proto3" json:"abort_limits,omitempty"`
+	// Specifies the intensity level each photon was sent at, with levels
+	// numbered in increasing order of intensity. Each photon's level takes
+	// ceil(log2(n)) bits for n levels, least significant first, so none are
+	// needed for a single level.
+	Intensities *DenseBitArray `protobuf:"bytes,14,opt,name=intensities,proto3" json:"intensities,omitempty"`
 }
 
 func (x *BasisAnnouncement) Reset() {
@@ -202,27 +271,167 @@ func (x *BasisAnnouncement) GetTestBits() *DenseBitArray {
 	return nil
 }
 
-func (x *BasisAnnouncement) GetLo() *DenseBitArray {
+func (x *BasisAnnouncement) GetPulses() *PulseRange {
 	if x != nil {
-		return x.Lo
+		return x.Pulses
 	}
 	return nil
 }
 
-func (x *BasisAnnouncement) GetMed() *DenseBitArray {
+func (x *BasisAnnouncement) GetOffset() int32 {
 	if x != nil {
-		return x.Med
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *BasisAnnouncement) GetMonitoredIntensities() []*IntensityRange {
+	if x != nil {
+		return x.MonitoredIntensities
+	}
+	return nil
+}
+
+func (x *BasisAnnouncement) GetSampled() *DenseBitArray {
+	if x != nil {
+		return x.Sampled
+	}
+	return nil
+}
+
+func (x *BasisAnnouncement) GetAligned() *DenseBitArray {
+	if x != nil {
+		return x.Aligned
 	}
 	return nil
 }
 
-func (x *BasisAnnouncement) GetHi() *DenseBitArray {
+func (x *BasisAnnouncement) GetAbortLimits() bool {
+	if x != nil {
+		return x.AbortLimits
+	}
+	return false
+}
+
+func (x *BasisAnnouncement) GetIntensities() *DenseBitArray {
 	if x != nil {
-		return x.Hi
+		return x.Intensities
 	}
 	return nil
 }
 
+type IntensityRange struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Min float64 `protobuf:"fixed64,1,opt,name=min,proto3" json:"min,omitempty"`
+	Max float64 `protobuf:"fixed64,2,opt,name=max,proto3" json:"max,omitempty"`
+}
+
+func (x *IntensityRange) Reset() {
+	*x = IntensityRange{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_bb84_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *IntensityRange) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IntensityRange) ProtoMessage() {}
+
+func (x *IntensityRange) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_bb84_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IntensityRange.ProtoReflect.Descriptor instead.
+func (*IntensityRange) Descriptor() ([]byte, []int) {
+	return file_proto_bb84_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *IntensityRange) GetMin() float64 {
+	if x != nil {
+		return x.Min
+	}
+	return 0
+}
+
+func (x *IntensityRange) GetMax() float64 {
+	if x != nil {
+		return x.Max
+	}
+	return 0
+}
+
+type PulseRange struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// The index of the batch's first pulse within the overall pulse stream.
+	First uint64 `protobuf:"varint,1,opt,name=first,proto3" json:"first,omitempty"`
+	// The number of pulses in the batch.
+	Count uint64 `protobuf:"varint,2,opt,name=count,proto3" json:"count,omitempty"`
+}
+
+func (x *PulseRange) Reset() {
+	*x = PulseRange{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_bb84_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PulseRange) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PulseRange) ProtoMessage() {}
+
+func (x *PulseRange) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_bb84_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PulseRange.ProtoReflect.Descriptor instead.
+func (*PulseRange) Descriptor() ([]byte, []int) {
+	return file_proto_bb84_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *PulseRange) GetFirst() uint64 {
+	if x != nil {
+		return x.First
+	}
+	return 0
+}
+
+func (x *PulseRange) GetCount() uint64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
 type HashAnnouncement struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -238,7 +447,7 @@ type HashAnnouncement struct {
 func (x *HashAnnouncement) Reset() {
 	*x = HashAnnouncement{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_bb84_proto_msgTypes[3]
+		mi := &file_proto_bb84_proto_msgTypes[5]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -251,7 +460,7 @@ func (x *HashAnnouncement) String() string {
 func (*HashAnnouncement) ProtoMessage() {}
 
 func (x *HashAnnouncement) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_bb84_proto_msgTypes[3]
+	mi := &file_proto_bb84_proto_msgTypes[5]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -264,7 +473,7 @@ func (x *HashAnnouncement) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use HashAnnouncement.ProtoReflect.Descriptor instead.
 func (*HashAnnouncement) Descriptor() ([]byte, []int) {
-	return file_proto_bb84_proto_rawDescGZIP(), []int{3}
+	return file_proto_bb84_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *HashAnnouncement) GetSeed() []byte {
@@ -294,7 +503,7 @@ type ParityAnnouncement struct {
 func (x *ParityAnnouncement) Reset() {
 	*x = ParityAnnouncement{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_bb84_proto_msgTypes[4]
+		mi := &file_proto_bb84_proto_msgTypes[6]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -307,7 +516,7 @@ func (x *ParityAnnouncement) String() string {
 func (*ParityAnnouncement) ProtoMessage() {}
 
 func (x *ParityAnnouncement) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_bb84_proto_msgTypes[4]
+	mi := &file_proto_bb84_proto_msgTypes[6]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -320,7 +529,7 @@ func (x *ParityAnnouncement) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ParityAnnouncement.ProtoReflect.Descriptor instead.
 func (*ParityAnnouncement) Descriptor() ([]byte, []int) {
-	return file_proto_bb84_proto_rawDescGZIP(), []int{4}
+	return file_proto_bb84_proto_rawDescGZIP(), []int{6}
 }
 
 func (x *ParityAnnouncement) GetParities() *DenseBitArray {
@@ -345,7 +554,7 @@ type SyndromeAnnouncement struct {
 func (x *SyndromeAnnouncement) Reset() {
 	*x = SyndromeAnnouncement{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_bb84_proto_msgTypes[5]
+		mi := &file_proto_bb84_proto_msgTypes[7]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -358,7 +567,7 @@ func (x *SyndromeAnnouncement) String() string {
 func (*SyndromeAnnouncement) ProtoMessage() {}
 
 func (x *SyndromeAnnouncement) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_bb84_proto_msgTypes[5]
+	mi := &file_proto_bb84_proto_msgTypes[7]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -371,7 +580,7 @@ func (x *SyndromeAnnouncement) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use SyndromeAnnouncement.ProtoReflect.Descriptor instead.
 func (*SyndromeAnnouncement) Descriptor() ([]byte, []int) {
-	return file_proto_bb84_proto_rawDescGZIP(), []int{5}
+	return file_proto_bb84_proto_rawDescGZIP(), []int{7}
 }
 
 func (x *SyndromeAnnouncement) GetSyndromes() []*DenseBitArray {
@@ -381,6 +590,55 @@ func (x *SyndromeAnnouncement) GetSyndromes() []*DenseBitArray {
 	return nil
 }
 
+type EstimationFinished struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Why the sender is aborting the round, having finished parameter
+	// estimation, or empty if it is willing to continue.
+	AbortReason string `protobuf:"bytes,1,opt,name=abort_reason,json=abortReason,proto3" json:"abort_reason,omitempty"`
+}
+
+func (x *EstimationFinished) Reset() {
+	*x = EstimationFinished{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_bb84_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *EstimationFinished) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EstimationFinished) ProtoMessage() {}
+
+func (x *EstimationFinished) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_bb84_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EstimationFinished.ProtoReflect.Descriptor instead.
+func (*EstimationFinished) Descriptor() ([]byte, []int) {
+	return file_proto_bb84_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *EstimationFinished) GetAbortReason() string {
+	if x != nil {
+		return x.AbortReason
+	}
+	return ""
+}
+
 type ErrorCorrectionFinished struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -392,12 +650,14 @@ type ErrorCorrectionFinished struct {
 	VerifySeed []byte `protobuf:"bytes,2,opt,name=verify_seed,json=verifySeed,proto3" json:"verify_seed,omitempty"`
 	// The result of hashing our error-corrected, but unextracted, key.
 	VerifyHash *DenseBitArray `protobuf:"bytes,3,opt,name=verify_hash,json=verifyHash,proto3" json:"verify_hash,omitempty"`
+	// The sender's view of parameter estimation, for diagnostic purposes.
+	Estimates *Estimates `protobuf:"bytes,4,opt,name=estimates,proto3" json:"estimates,omitempty"`
 }
 
 func (x *ErrorCorrectionFinished) Reset() {
 	*x = ErrorCorrectionFinished{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_bb84_proto_msgTypes[6]
+		mi := &file_proto_bb84_proto_msgTypes[9]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -410,7 +670,7 @@ func (x *ErrorCorrectionFinished) String() string {
 func (*ErrorCorrectionFinished) ProtoMessage() {}
 
 func (x *ErrorCorrectionFinished) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_bb84_proto_msgTypes[6]
+	mi := &file_proto_bb84_proto_msgTypes[9]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -423,7 +683,7 @@ func (x *ErrorCorrectionFinished) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ErrorCorrectionFinished.ProtoReflect.Descriptor instead.
 func (*ErrorCorrectionFinished) Descriptor() ([]byte, []int) {
-	return file_proto_bb84_proto_rawDescGZIP(), []int{6}
+	return file_proto_bb84_proto_rawDescGZIP(), []int{9}
 }
 
 func (x *ErrorCorrectionFinished) GetExtractSeed() []byte {
@@ -447,6 +707,222 @@ func (x *ErrorCorrectionFinished) GetVerifyHash() *DenseBitArray {
 	return nil
 }
 
+func (x *ErrorCorrectionFinished) GetEstimates() *Estimates {
+	if x != nil {
+		return x.Estimates
+	}
+	return nil
+}
+
+type Estimates struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Lower bounds on the detections due to vacuum pulses in the main and test
+	// bases.
+	VacuumX float64 `protobuf:"fixed64,1,opt,name=vacuum_x,json=vacuumX,proto3" json:"vacuum_x,omitempty"`
+	VacuumZ float64 `protobuf:"fixed64,2,opt,name=vacuum_z,json=vacuumZ,proto3" json:"vacuum_z,omitempty"`
+	// Lower bounds on the detections due to single-photon pulses in the main
+	// and test bases.
+	SinglePhotonX float64 `protobuf:"fixed64,3,opt,name=single_photon_x,json=singlePhotonX,proto3" json:"single_photon_x,omitempty"`
+	SinglePhotonZ float64 `protobuf:"fixed64,4,opt,name=single_photon_z,json=singlePhotonZ,proto3" json:"single_photon_z,omitempty"`
+	// An upper bound on the phase error rate of main basis single-photon
+	// detections.
+	PhaseError float64 `protobuf:"fixed64,5,opt,name=phase_error,json=phaseError,proto3" json:"phase_error,omitempty"`
+	// The safe key length before accounting for error correction leakage.
+	SafeKeyLen int64 `protobuf:"varint,6,opt,name=safe_key_len,json=safeKeyLen,proto3" json:"safe_key_len,omitempty"`
+	// The key length after accounting for error correction leakage.
+	KeyLen int64 `protobuf:"varint,7,opt,name=key_len,json=keyLen,proto3" json:"key_len,omitempty"`
+	// The name of the concentration bound the estimates were computed with.
+	Bound string `protobuf:"bytes,8,opt,name=bound,proto3" json:"bound,omitempty"`
+}
+
+func (x *Estimates) Reset() {
+	*x = Estimates{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_bb84_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Estimates) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Estimates) ProtoMessage() {}
+
+func (x *Estimates) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_bb84_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Estimates.ProtoReflect.Descriptor instead.
+func (*Estimates) Descriptor() ([]byte, []int) {
+	return file_proto_bb84_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *Estimates) GetVacuumX() float64 {
+	if x != nil {
+		return x.VacuumX
+	}
+	return 0
+}
+
+func (x *Estimates) GetVacuumZ() float64 {
+	if x != nil {
+		return x.VacuumZ
+	}
+	return 0
+}
+
+func (x *Estimates) GetSinglePhotonX() float64 {
+	if x != nil {
+		return x.SinglePhotonX
+	}
+	return 0
+}
+
+func (x *Estimates) GetSinglePhotonZ() float64 {
+	if x != nil {
+		return x.SinglePhotonZ
+	}
+	return 0
+}
+
+func (x *Estimates) GetPhaseError() float64 {
+	if x != nil {
+		return x.PhaseError
+	}
+	return 0
+}
+
+func (x *Estimates) GetSafeKeyLen() int64 {
+	if x != nil {
+		return x.SafeKeyLen
+	}
+	return 0
+}
+
+func (x *Estimates) GetKeyLen() int64 {
+	if x != nil {
+		return x.KeyLen
+	}
+	return 0
+}
+
+func (x *Estimates) GetBound() string {
+	if x != nil {
+		return x.Bound
+	}
+	return ""
+}
+
+type TranscriptEntry struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Whether the recording peer sent or received the message.
+	Direction TranscriptEntry_Direction `protobuf:"varint,1,opt,name=direction,proto3,enum=bb84.TranscriptEntry_Direction" json:"direction,omitempty"`
+	// When the message was sent or received, in nanoseconds since the Unix
+	// epoch.
+	TimestampNanos int64 `protobuf:"varint,2,opt,name=timestamp_nanos,json=timestampNanos,proto3" json:"timestamp_nanos,omitempty"`
+	// The fully qualified name of the message's type, e.g.
+	// "bb84.BasisAnnouncement".
+	Type string `protobuf:"bytes,3,opt,name=type,proto3" json:"type,omitempty"`
+	// The serialized message, as it appeared on the wire.
+	Message []byte `protobuf:"bytes,4,opt,name=message,proto3" json:"message,omitempty"`
+	// The message's authentication code, as it appeared on the wire. Only
+	// recorded for messages which were rejected.
+	Mac []byte `protobuf:"bytes,5,opt,name=mac,proto3" json:"mac,omitempty"`
+	// If non-empty, why a received message was rejected, e.g. because it
+	// failed authentication or could not be decoded.
+	Error string `protobuf:"bytes,6,opt,name=error,proto3" json:"error,omitempty"`
+}
+
+func (x *TranscriptEntry) Reset() {
+	*x = TranscriptEntry{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_bb84_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TranscriptEntry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TranscriptEntry) ProtoMessage() {}
+
+func (x *TranscriptEntry) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_bb84_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TranscriptEntry.ProtoReflect.Descriptor instead.
+func (*TranscriptEntry) Descriptor() ([]byte, []int) {
+	return file_proto_bb84_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *TranscriptEntry) GetDirection() TranscriptEntry_Direction {
+	if x != nil {
+		return x.Direction
+	}
+	return TranscriptEntry_SENT
+}
+
+func (x *TranscriptEntry) GetTimestampNanos() int64 {
+	if x != nil {
+		return x.TimestampNanos
+	}
+	return 0
+}
+
+func (x *TranscriptEntry) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *TranscriptEntry) GetMessage() []byte {
+	if x != nil {
+		return x.Message
+	}
+	return nil
+}
+
+func (x *TranscriptEntry) GetMac() []byte {
+	if x != nil {
+		return x.Mac
+	}
+	return nil
+}
+
+func (x *TranscriptEntry) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
 var File_proto_bb84_proto protoreflect.FileDescriptor
 
 var file_proto_bb84_proto_rawDesc = []byte{
@@ -458,7 +934,7 @@ var file_proto_bb84_proto_rawDesc = []byte{
 	0x3c, 0x0a, 0x0e, 0x53, 0x70, 0x61, 0x72, 0x73, 0x65, 0x42, 0x69, 0x74, 0x41, 0x72, 0x72, 0x61,
 	0x79, 0x12, 0x18, 0x0a, 0x07, 0x69, 0x6e, 0x64, 0x69, 0x63, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03,
 	0x28, 0x05, 0x52, 0x07, 0x69, 0x6e, 0x64, 0x69, 0x63, 0x65, 0x73, 0x12, 0x10, 0x0a, 0x03, 0x6c,
-	0x65, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x03, 0x6c, 0x65, 0x6e, 0x22, 0x90, 0x02,
+	0x65, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x03, 0x6c, 0x65, 0x6e, 0x22, 0xfc, 0x03,
 	0x0a, 0x11, 0x42, 0x61, 0x73, 0x69, 0x73, 0x41, 0x6e, 0x6e, 0x6f, 0x75, 0x6e, 0x63, 0x65, 0x6d,
 	0x65, 0x6e, 0x74, 0x12, 0x29, 0x0a, 0x05, 0x62, 0x61, 0x73, 0x65, 0x73, 0x18, 0x01, 0x20, 0x01,
 	0x28, 0x0b, 0x32, 0x13, 0x2e, 0x62, 0x62, 0x38, 0x34, 0x2e, 0x44, 0x65, 0x6e, 0x73, 0x65, 0x42,
@@ -469,37 +945,98 @@ var file_proto_bb84_proto_rawDesc = []byte{
 	0x09, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x62, 0x69, 0x74, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b,
 	0x32, 0x13, 0x2e, 0x62, 0x62, 0x38, 0x34, 0x2e, 0x44, 0x65, 0x6e, 0x73, 0x65, 0x42, 0x69, 0x74,
 	0x41, 0x72, 0x72, 0x61, 0x79, 0x52, 0x08, 0x74, 0x65, 0x73, 0x74, 0x42, 0x69, 0x74, 0x73, 0x12,
-	0x23, 0x0a, 0x02, 0x6c, 0x6f, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x62, 0x62,
-	0x38, 0x34, 0x2e, 0x44, 0x65, 0x6e, 0x73, 0x65, 0x42, 0x69, 0x74, 0x41, 0x72, 0x72, 0x61, 0x79,
-	0x52, 0x02, 0x6c, 0x6f, 0x12, 0x25, 0x0a, 0x03, 0x6d, 0x65, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28,
-	0x0b, 0x32, 0x13, 0x2e, 0x62, 0x62, 0x38, 0x34, 0x2e, 0x44, 0x65, 0x6e, 0x73, 0x65, 0x42, 0x69,
-	0x74, 0x41, 0x72, 0x72, 0x61, 0x79, 0x52, 0x03, 0x6d, 0x65, 0x64, 0x12, 0x23, 0x0a, 0x02, 0x68,
-	0x69, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x62, 0x62, 0x38, 0x34, 0x2e, 0x44,
-	0x65, 0x6e, 0x73, 0x65, 0x42, 0x69, 0x74, 0x41, 0x72, 0x72, 0x61, 0x79, 0x52, 0x02, 0x68, 0x69,
-	0x22, 0x3a, 0x0a, 0x10, 0x48, 0x61, 0x73, 0x68, 0x41, 0x6e, 0x6e, 0x6f, 0x75, 0x6e, 0x63, 0x65,
-	0x6d, 0x65, 0x6e, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x73, 0x65, 0x65, 0x64, 0x18, 0x01, 0x20, 0x01,
-	0x28, 0x0c, 0x52, 0x04, 0x73, 0x65, 0x65, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x68, 0x61, 0x73, 0x68,
-	0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x68, 0x61, 0x73, 0x68, 0x22, 0x45, 0x0a, 0x12,
-	0x50, 0x61, 0x72, 0x69, 0x74, 0x79, 0x41, 0x6e, 0x6e, 0x6f, 0x75, 0x6e, 0x63, 0x65, 0x6d, 0x65,
-	0x6e, 0x74, 0x12, 0x2f, 0x0a, 0x08, 0x70, 0x61, 0x72, 0x69, 0x74, 0x69, 0x65, 0x73, 0x18, 0x01,
-	0x20, 0x01, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x62, 0x62, 0x38, 0x34, 0x2e, 0x44, 0x65, 0x6e, 0x73,
-	0x65, 0x42, 0x69, 0x74, 0x41, 0x72, 0x72, 0x61, 0x79, 0x52, 0x08, 0x70, 0x61, 0x72, 0x69, 0x74,
-	0x69, 0x65, 0x73, 0x22, 0x49, 0x0a, 0x14, 0x53, 0x79, 0x6e, 0x64, 0x72, 0x6f, 0x6d, 0x65, 0x41,
-	0x6e, 0x6e, 0x6f, 0x75, 0x6e, 0x63, 0x65, 0x6d, 0x65, 0x6e, 0x74, 0x12, 0x31, 0x0a, 0x09, 0x73,
-	0x79, 0x6e, 0x64, 0x72, 0x6f, 0x6d, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x13,
-	0x2e, 0x62, 0x62, 0x38, 0x34, 0x2e, 0x44, 0x65, 0x6e, 0x73, 0x65, 0x42, 0x69, 0x74, 0x41, 0x72,
-	0x72, 0x61, 0x79, 0x52, 0x09, 0x73, 0x79, 0x6e, 0x64, 0x72, 0x6f, 0x6d, 0x65, 0x73, 0x22, 0x93,
-	0x01, 0x0a, 0x17, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x43, 0x6f, 0x72, 0x72, 0x65, 0x63, 0x74, 0x69,
-	0x6f, 0x6e, 0x46, 0x69, 0x6e, 0x69, 0x73, 0x68, 0x65, 0x64, 0x12, 0x21, 0x0a, 0x0c, 0x65, 0x78,
-	0x74, 0x72, 0x61, 0x63, 0x74, 0x5f, 0x73, 0x65, 0x65, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c,
-	0x52, 0x0b, 0x65, 0x78, 0x74, 0x72, 0x61, 0x63, 0x74, 0x53, 0x65, 0x65, 0x64, 0x12, 0x1f, 0x0a,
-	0x0b, 0x76, 0x65, 0x72, 0x69, 0x66, 0x79, 0x5f, 0x73, 0x65, 0x65, 0x64, 0x18, 0x02, 0x20, 0x01,
-	0x28, 0x0c, 0x52, 0x0a, 0x76, 0x65, 0x72, 0x69, 0x66, 0x79, 0x53, 0x65, 0x65, 0x64, 0x12, 0x34,
-	0x0a, 0x0b, 0x76, 0x65, 0x72, 0x69, 0x66, 0x79, 0x5f, 0x68, 0x61, 0x73, 0x68, 0x18, 0x03, 0x20,
-	0x01, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x62, 0x62, 0x38, 0x34, 0x2e, 0x44, 0x65, 0x6e, 0x73, 0x65,
-	0x42, 0x69, 0x74, 0x41, 0x72, 0x72, 0x61, 0x79, 0x52, 0x0a, 0x76, 0x65, 0x72, 0x69, 0x66, 0x79,
-	0x48, 0x61, 0x73, 0x68, 0x42, 0x12, 0x5a, 0x10, 0x67, 0x65, 0x6e, 0x65, 0x72, 0x61, 0x74, 0x65,
-	0x64, 0x2f, 0x62, 0x62, 0x38, 0x34, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x28, 0x0a, 0x06, 0x70, 0x75, 0x6c, 0x73, 0x65, 0x73, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x10, 0x2e, 0x62, 0x62, 0x38, 0x34, 0x2e, 0x50, 0x75, 0x6c, 0x73, 0x65, 0x52, 0x61, 0x6e, 0x67,
+	0x65, 0x52, 0x06, 0x70, 0x75, 0x6c, 0x73, 0x65, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x6f, 0x66, 0x66,
+	0x73, 0x65, 0x74, 0x18, 0x08, 0x20, 0x01, 0x28, 0x05, 0x52, 0x06, 0x6f, 0x66, 0x66, 0x73, 0x65,
+	0x74, 0x12, 0x49, 0x0a, 0x15, 0x6d, 0x6f, 0x6e, 0x69, 0x74, 0x6f, 0x72, 0x65, 0x64, 0x5f, 0x69,
+	0x6e, 0x74, 0x65, 0x6e, 0x73, 0x69, 0x74, 0x69, 0x65, 0x73, 0x18, 0x0a, 0x20, 0x03, 0x28, 0x0b,
+	0x32, 0x14, 0x2e, 0x62, 0x62, 0x38, 0x34, 0x2e, 0x49, 0x6e, 0x74, 0x65, 0x6e, 0x73, 0x69, 0x74,
+	0x79, 0x52, 0x61, 0x6e, 0x67, 0x65, 0x52, 0x14, 0x6d, 0x6f, 0x6e, 0x69, 0x74, 0x6f, 0x72, 0x65,
+	0x64, 0x49, 0x6e, 0x74, 0x65, 0x6e, 0x73, 0x69, 0x74, 0x69, 0x65, 0x73, 0x12, 0x2d, 0x0a, 0x07,
+	0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x64, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x13, 0x2e,
+	0x62, 0x62, 0x38, 0x34, 0x2e, 0x44, 0x65, 0x6e, 0x73, 0x65, 0x42, 0x69, 0x74, 0x41, 0x72, 0x72,
+	0x61, 0x79, 0x52, 0x07, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x64, 0x12, 0x2d, 0x0a, 0x07, 0x61,
+	0x6c, 0x69, 0x67, 0x6e, 0x65, 0x64, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x62,
+	0x62, 0x38, 0x34, 0x2e, 0x44, 0x65, 0x6e, 0x73, 0x65, 0x42, 0x69, 0x74, 0x41, 0x72, 0x72, 0x61,
+	0x79, 0x52, 0x07, 0x61, 0x6c, 0x69, 0x67, 0x6e, 0x65, 0x64, 0x12, 0x21, 0x0a, 0x0c, 0x61, 0x62,
+	0x6f, 0x72, 0x74, 0x5f, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x73, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x08,
+	0x52, 0x0b, 0x61, 0x62, 0x6f, 0x72, 0x74, 0x4c, 0x69, 0x6d, 0x69, 0x74, 0x73, 0x12, 0x35, 0x0a,
+	0x0b, 0x69, 0x6e, 0x74, 0x65, 0x6e, 0x73, 0x69, 0x74, 0x69, 0x65, 0x73, 0x18, 0x0e, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x13, 0x2e, 0x62, 0x62, 0x38, 0x34, 0x2e, 0x44, 0x65, 0x6e, 0x73, 0x65, 0x42,
+	0x69, 0x74, 0x41, 0x72, 0x72, 0x61, 0x79, 0x52, 0x0b, 0x69, 0x6e, 0x74, 0x65, 0x6e, 0x73, 0x69,
+	0x74, 0x69, 0x65, 0x73, 0x4a, 0x04, 0x08, 0x04, 0x10, 0x05, 0x4a, 0x04, 0x08, 0x05, 0x10, 0x06,
+	0x4a, 0x04, 0x08, 0x06, 0x10, 0x07, 0x4a, 0x04, 0x08, 0x09, 0x10, 0x0a, 0x22, 0x34, 0x0a, 0x0e,
+	0x49, 0x6e, 0x74, 0x65, 0x6e, 0x73, 0x69, 0x74, 0x79, 0x52, 0x61, 0x6e, 0x67, 0x65, 0x12, 0x10,
+	0x0a, 0x03, 0x6d, 0x69, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x01, 0x52, 0x03, 0x6d, 0x69, 0x6e,
+	0x12, 0x10, 0x0a, 0x03, 0x6d, 0x61, 0x78, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x03, 0x6d,
+	0x61, 0x78, 0x22, 0x38, 0x0a, 0x0a, 0x50, 0x75, 0x6c, 0x73, 0x65, 0x52, 0x61, 0x6e, 0x67, 0x65,
+	0x12, 0x14, 0x0a, 0x05, 0x66, 0x69, 0x72, 0x73, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52,
+	0x05, 0x66, 0x69, 0x72, 0x73, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x05, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x22, 0x3a, 0x0a, 0x10,
+	0x48, 0x61, 0x73, 0x68, 0x41, 0x6e, 0x6e, 0x6f, 0x75, 0x6e, 0x63, 0x65, 0x6d, 0x65, 0x6e, 0x74,
+	0x12, 0x12, 0x0a, 0x04, 0x73, 0x65, 0x65, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04,
+	0x73, 0x65, 0x65, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x68, 0x61, 0x73, 0x68, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x0c, 0x52, 0x04, 0x68, 0x61, 0x73, 0x68, 0x22, 0x45, 0x0a, 0x12, 0x50, 0x61, 0x72, 0x69,
+	0x74, 0x79, 0x41, 0x6e, 0x6e, 0x6f, 0x75, 0x6e, 0x63, 0x65, 0x6d, 0x65, 0x6e, 0x74, 0x12, 0x2f,
+	0x0a, 0x08, 0x70, 0x61, 0x72, 0x69, 0x74, 0x69, 0x65, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x13, 0x2e, 0x62, 0x62, 0x38, 0x34, 0x2e, 0x44, 0x65, 0x6e, 0x73, 0x65, 0x42, 0x69, 0x74,
+	0x41, 0x72, 0x72, 0x61, 0x79, 0x52, 0x08, 0x70, 0x61, 0x72, 0x69, 0x74, 0x69, 0x65, 0x73, 0x22,
+	0x49, 0x0a, 0x14, 0x53, 0x79, 0x6e, 0x64, 0x72, 0x6f, 0x6d, 0x65, 0x41, 0x6e, 0x6e, 0x6f, 0x75,
+	0x6e, 0x63, 0x65, 0x6d, 0x65, 0x6e, 0x74, 0x12, 0x31, 0x0a, 0x09, 0x73, 0x79, 0x6e, 0x64, 0x72,
+	0x6f, 0x6d, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x62, 0x62, 0x38,
+	0x34, 0x2e, 0x44, 0x65, 0x6e, 0x73, 0x65, 0x42, 0x69, 0x74, 0x41, 0x72, 0x72, 0x61, 0x79, 0x52,
+	0x09, 0x73, 0x79, 0x6e, 0x64, 0x72, 0x6f, 0x6d, 0x65, 0x73, 0x22, 0x37, 0x0a, 0x12, 0x45, 0x73,
+	0x74, 0x69, 0x6d, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x46, 0x69, 0x6e, 0x69, 0x73, 0x68, 0x65, 0x64,
+	0x12, 0x21, 0x0a, 0x0c, 0x61, 0x62, 0x6f, 0x72, 0x74, 0x5f, 0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x61, 0x62, 0x6f, 0x72, 0x74, 0x52, 0x65, 0x61,
+	0x73, 0x6f, 0x6e, 0x22, 0xc2, 0x01, 0x0a, 0x17, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x43, 0x6f, 0x72,
+	0x72, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x46, 0x69, 0x6e, 0x69, 0x73, 0x68, 0x65, 0x64, 0x12,
+	0x21, 0x0a, 0x0c, 0x65, 0x78, 0x74, 0x72, 0x61, 0x63, 0x74, 0x5f, 0x73, 0x65, 0x65, 0x64, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0b, 0x65, 0x78, 0x74, 0x72, 0x61, 0x63, 0x74, 0x53, 0x65,
+	0x65, 0x64, 0x12, 0x1f, 0x0a, 0x0b, 0x76, 0x65, 0x72, 0x69, 0x66, 0x79, 0x5f, 0x73, 0x65, 0x65,
+	0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0a, 0x76, 0x65, 0x72, 0x69, 0x66, 0x79, 0x53,
+	0x65, 0x65, 0x64, 0x12, 0x34, 0x0a, 0x0b, 0x76, 0x65, 0x72, 0x69, 0x66, 0x79, 0x5f, 0x68, 0x61,
+	0x73, 0x68, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x62, 0x62, 0x38, 0x34, 0x2e,
+	0x44, 0x65, 0x6e, 0x73, 0x65, 0x42, 0x69, 0x74, 0x41, 0x72, 0x72, 0x61, 0x79, 0x52, 0x0a, 0x76,
+	0x65, 0x72, 0x69, 0x66, 0x79, 0x48, 0x61, 0x73, 0x68, 0x12, 0x2d, 0x0a, 0x09, 0x65, 0x73, 0x74,
+	0x69, 0x6d, 0x61, 0x74, 0x65, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x62,
+	0x62, 0x38, 0x34, 0x2e, 0x45, 0x73, 0x74, 0x69, 0x6d, 0x61, 0x74, 0x65, 0x73, 0x52, 0x09, 0x65,
+	0x73, 0x74, 0x69, 0x6d, 0x61, 0x74, 0x65, 0x73, 0x22, 0x83, 0x02, 0x0a, 0x09, 0x45, 0x73, 0x74,
+	0x69, 0x6d, 0x61, 0x74, 0x65, 0x73, 0x12, 0x19, 0x0a, 0x08, 0x76, 0x61, 0x63, 0x75, 0x75, 0x6d,
+	0x5f, 0x78, 0x18, 0x01, 0x20, 0x01, 0x28, 0x01, 0x52, 0x07, 0x76, 0x61, 0x63, 0x75, 0x75, 0x6d,
+	0x58, 0x12, 0x19, 0x0a, 0x08, 0x76, 0x61, 0x63, 0x75, 0x75, 0x6d, 0x5f, 0x7a, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x01, 0x52, 0x07, 0x76, 0x61, 0x63, 0x75, 0x75, 0x6d, 0x5a, 0x12, 0x26, 0x0a, 0x0f,
+	0x73, 0x69, 0x6e, 0x67, 0x6c, 0x65, 0x5f, 0x70, 0x68, 0x6f, 0x74, 0x6f, 0x6e, 0x5f, 0x78, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0d, 0x73, 0x69, 0x6e, 0x67, 0x6c, 0x65, 0x50, 0x68, 0x6f,
+	0x74, 0x6f, 0x6e, 0x58, 0x12, 0x26, 0x0a, 0x0f, 0x73, 0x69, 0x6e, 0x67, 0x6c, 0x65, 0x5f, 0x70,
+	0x68, 0x6f, 0x74, 0x6f, 0x6e, 0x5f, 0x7a, 0x18, 0x04, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0d, 0x73,
+	0x69, 0x6e, 0x67, 0x6c, 0x65, 0x50, 0x68, 0x6f, 0x74, 0x6f, 0x6e, 0x5a, 0x12, 0x1f, 0x0a, 0x0b,
+	0x70, 0x68, 0x61, 0x73, 0x65, 0x5f, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x05, 0x20, 0x01, 0x28,
+	0x01, 0x52, 0x0a, 0x70, 0x68, 0x61, 0x73, 0x65, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x12, 0x20, 0x0a,
+	0x0c, 0x73, 0x61, 0x66, 0x65, 0x5f, 0x6b, 0x65, 0x79, 0x5f, 0x6c, 0x65, 0x6e, 0x18, 0x06, 0x20,
+	0x01, 0x28, 0x03, 0x52, 0x0a, 0x73, 0x61, 0x66, 0x65, 0x4b, 0x65, 0x79, 0x4c, 0x65, 0x6e, 0x12,
+	0x17, 0x0a, 0x07, 0x6b, 0x65, 0x79, 0x5f, 0x6c, 0x65, 0x6e, 0x18, 0x07, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x06, 0x6b, 0x65, 0x79, 0x4c, 0x65, 0x6e, 0x12, 0x14, 0x0a, 0x05, 0x62, 0x6f, 0x75, 0x6e,
+	0x64, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x62, 0x6f, 0x75, 0x6e, 0x64, 0x22, 0xf4,
+	0x01, 0x0a, 0x0f, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x63, 0x72, 0x69, 0x70, 0x74, 0x45, 0x6e, 0x74,
+	0x72, 0x79, 0x12, 0x3d, 0x0a, 0x09, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x1f, 0x2e, 0x62, 0x62, 0x38, 0x34, 0x2e, 0x54, 0x72, 0x61,
+	0x6e, 0x73, 0x63, 0x72, 0x69, 0x70, 0x74, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x2e, 0x44, 0x69, 0x72,
+	0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x09, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x69, 0x6f,
+	0x6e, 0x12, 0x27, 0x0a, 0x0f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x5f, 0x6e,
+	0x61, 0x6e, 0x6f, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x74, 0x69, 0x6d, 0x65,
+	0x73, 0x74, 0x61, 0x6d, 0x70, 0x4e, 0x61, 0x6e, 0x6f, 0x73, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x79,
+	0x70, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x18,
+	0x0a, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0c, 0x52,
+	0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x6d, 0x61, 0x63, 0x18,
+	0x05, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x6d, 0x61, 0x63, 0x12, 0x14, 0x0a, 0x05, 0x65, 0x72,
+	0x72, 0x6f, 0x72, 0x18, 0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72,
+	0x22, 0x23, 0x0a, 0x09, 0x44, 0x69, 0x72, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x08, 0x0a,
+	0x04, 0x53, 0x45, 0x4e, 0x54, 0x10, 0x00, 0x12, 0x0c, 0x0a, 0x08, 0x52, 0x45, 0x43, 0x45, 0x49,
+	0x56, 0x45, 0x44, 0x10, 0x01, 0x42, 0x12, 0x5a, 0x10, 0x67, 0x65, 0x6e, 0x65, 0x72, 0x61, 0x74,
+	0x65, 0x64, 0x2f, 0x62, 0x62, 0x38, 0x34, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x33,
 }
 
 var (
@@ -514,31 +1051,42 @@ func file_proto_bb84_proto_rawDescGZIP() []byte {
 	return file_proto_bb84_proto_rawDescData
 }
 
-var file_proto_bb84_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_proto_bb84_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_proto_bb84_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
 var file_proto_bb84_proto_goTypes = []interface{}{
-	(*DenseBitArray)(nil),           // 0: bb84.DenseBitArray
-	(*SparseBitArray)(nil),          // 1: bb84.SparseBitArray
-	(*BasisAnnouncement)(nil),       // 2: bb84.BasisAnnouncement
-	(*HashAnnouncement)(nil),        // 3: bb84.HashAnnouncement
-	(*ParityAnnouncement)(nil),      // 4: bb84.ParityAnnouncement
-	(*SyndromeAnnouncement)(nil),    // 5: bb84.SyndromeAnnouncement
-	(*ErrorCorrectionFinished)(nil), // 6: bb84.ErrorCorrectionFinished
+	(TranscriptEntry_Direction)(0),  // 0: bb84.TranscriptEntry.Direction
+	(*DenseBitArray)(nil),           // 1: bb84.DenseBitArray
+	(*SparseBitArray)(nil),          // 2: bb84.SparseBitArray
+	(*BasisAnnouncement)(nil),       // 3: bb84.BasisAnnouncement
+	(*IntensityRange)(nil),          // 4: bb84.IntensityRange
+	(*PulseRange)(nil),              // 5: bb84.PulseRange
+	(*HashAnnouncement)(nil),        // 6: bb84.HashAnnouncement
+	(*ParityAnnouncement)(nil),      // 7: bb84.ParityAnnouncement
+	(*SyndromeAnnouncement)(nil),    // 8: bb84.SyndromeAnnouncement
+	(*EstimationFinished)(nil),      // 9: bb84.EstimationFinished
+	(*ErrorCorrectionFinished)(nil), // 10: bb84.ErrorCorrectionFinished
+	(*Estimates)(nil),               // 11: bb84.Estimates
+	(*TranscriptEntry)(nil),         // 12: bb84.TranscriptEntry
 }
 var file_proto_bb84_proto_depIdxs = []int32{
-	0, // 0: bb84.BasisAnnouncement.bases:type_name -> bb84.DenseBitArray
-	0, // 1: bb84.BasisAnnouncement.dropped:type_name -> bb84.DenseBitArray
-	0, // 2: bb84.BasisAnnouncement.test_bits:type_name -> bb84.DenseBitArray
-	0, // 3: bb84.BasisAnnouncement.lo:type_name -> bb84.DenseBitArray
-	0, // 4: bb84.BasisAnnouncement.med:type_name -> bb84.DenseBitArray
-	0, // 5: bb84.BasisAnnouncement.hi:type_name -> bb84.DenseBitArray
-	0, // 6: bb84.ParityAnnouncement.parities:type_name -> bb84.DenseBitArray
-	0, // 7: bb84.SyndromeAnnouncement.syndromes:type_name -> bb84.DenseBitArray
-	0, // 8: bb84.ErrorCorrectionFinished.verify_hash:type_name -> bb84.DenseBitArray
-	9, // [9:9] is the sub-list for method output_type
-	9, // [9:9] is the sub-list for method input_type
-	9, // [9:9] is the sub-list for extension type_name
-	9, // [9:9] is the sub-list for extension extendee
-	0, // [0:9] is the sub-list for field type_name
+	1,  // 0: bb84.BasisAnnouncement.bases:type_name -> bb84.DenseBitArray
+	1,  // 1: bb84.BasisAnnouncement.dropped:type_name -> bb84.DenseBitArray
+	1,  // 2: bb84.BasisAnnouncement.test_bits:type_name -> bb84.DenseBitArray
+	5,  // 3: bb84.BasisAnnouncement.pulses:type_name -> bb84.PulseRange
+	4,  // 4: bb84.BasisAnnouncement.monitored_intensities:type_name -> bb84.IntensityRange
+	1,  // 5: bb84.BasisAnnouncement.sampled:type_name -> bb84.DenseBitArray
+	1,  // 6: bb84.BasisAnnouncement.aligned:type_name -> bb84.DenseBitArray
+	1,  // 7: bb84.BasisAnnouncement.intensities:type_name -> bb84.DenseBitArray
+	1,  // 8: bb84.ParityAnnouncement.parities:type_name -> bb84.DenseBitArray
+	1,  // 9: bb84.SyndromeAnnouncement.syndromes:type_name -> bb84.DenseBitArray
+	1,  // 10: bb84.ErrorCorrectionFinished.verify_hash:type_name -> bb84.DenseBitArray
+	11, // 11: bb84.ErrorCorrectionFinished.estimates:type_name -> bb84.Estimates
+	0,  // 12: bb84.TranscriptEntry.direction:type_name -> bb84.TranscriptEntry.Direction
+	13, // [13:13] is the sub-list for method output_type
+	13, // [13:13] is the sub-list for method input_type
+	13, // [13:13] is the sub-list for extension type_name
+	13, // [13:13] is the sub-list for extension extendee
+	0,  // [0:13] is the sub-list for field type_name
 }
 
 func init() { file_proto_bb84_proto_init() }
@@ -584,7 +1132,7 @@ func file_proto_bb84_proto_init() {
 			}
 		}
 		file_proto_bb84_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*HashAnnouncement); i {
+			switch v := v.(*IntensityRange); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -596,7 +1144,7 @@ func file_proto_bb84_proto_init() {
 			}
 		}
 		file_proto_bb84_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*ParityAnnouncement); i {
+			switch v := v.(*PulseRange); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -608,7 +1156,7 @@ func file_proto_bb84_proto_init() {
 			}
 		}
 		file_proto_bb84_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*SyndromeAnnouncement); i {
+			switch v := v.(*HashAnnouncement); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -620,6 +1168,42 @@ func file_proto_bb84_proto_init() {
 			}
 		}
 		file_proto_bb84_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ParityAnnouncement); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_bb84_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SyndromeAnnouncement); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_bb84_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*EstimationFinished); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_bb84_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*ErrorCorrectionFinished); i {
 			case 0:
 				return &v.state
@@ -631,19 +1215,44 @@ func file_proto_bb84_proto_init() {
 				return nil
 			}
 		}
+		file_proto_bb84_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Estimates); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_bb84_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TranscriptEntry); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_proto_bb84_proto_rawDesc,
-			NumEnums:      0,
-			NumMessages:   7,
+			NumEnums:      1,
+			NumMessages:   12,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
 		GoTypes:           file_proto_bb84_proto_goTypes,
 		DependencyIndexes: file_proto_bb84_proto_depIdxs,
+		EnumInfos:         file_proto_bb84_proto_enumTypes,
 		MessageInfos:      file_proto_bb84_proto_msgTypes,
 	}.Build()
 	File_proto_bb84_proto = out.File